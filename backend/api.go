@@ -20,27 +20,6 @@ type AgendaItem struct {
 	UserID         string    `json:"UserID" format:"uuid"`
 }
 
-// AgendaInvite represents an invitation to view a user's agenda
-type AgendaInvite struct {
-	ResourceID    string                     `json:"ResourceID" format:"uuid" doc:"The unique identifier of the agenda invite"`
-	UserID        string                     `json:"UserID" format:"uuid" doc:"The ID of the user associated with the invite"`
-	Description   string                     `json:"Description"`
-	ExpiresAt     time.Time                  `json:"ExpiresAt" format:"date-time"`
-	NotBefore     time.Time                  `json:"NotBefore" format:"date-time"`
-	NotAfter      time.Time                  `json:"NotAfter" format:"date-time"`
-	PaddingBefore string                     `json:"PaddingBefore" doc:"Duration before the event"`
-	PaddingAfter  string                     `json:"PaddingAfter" doc:"Duration after the event"`
-	SlotSizes     []string                   `json:"SlotSizes" doc:"Array of slot sizes as durations"`
-	AgendaSources []controllers.AgendaSource `json:"AgendaSources"`
-}
-
-// AgendaItemView represents a view of an agenda item without sensitive user data
-type AgendaItemView struct {
-	StartTime   time.Time `json:"StartTime" format:"date-time"`
-	EndTime     time.Time `json:"EndTime" format:"date-time"`
-	Description string    `json:"Description"`
-}
-
 // CreateAgendaItemsInput represents the input for creating agenda items
 type CreateAgendaItemsInput struct {
 	Body []AgendaItem
@@ -79,56 +58,17 @@ type DeleteAgendaItemInput struct {
 	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda item"`
 }
 
-// CreateAgendaInviteInput represents the input for creating an agenda invite
-type CreateAgendaInviteInput struct {
-	Body AgendaInvite
-}
-
-// CreateAgendaInviteOutput represents the output for creating an agenda invite
-type CreateAgendaInviteOutput struct {
-	Body AgendaInvite
-}
-
-// GetAgendaInviteInput represents the input for getting an agenda invite
-type GetAgendaInviteInput struct {
-	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
-}
-
-// GetAgendaInviteOutput represents the output for getting an agenda invite
-type GetAgendaInviteOutput struct {
-	Body AgendaInvite
-}
-
-// UpdateAgendaInviteInput represents the input for updating an agenda invite
-type UpdateAgendaInviteInput struct {
-	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
-	Body AgendaInvite
-}
-
-// UpdateAgendaInviteOutput represents the output for updating an agenda invite
-type UpdateAgendaInviteOutput struct {
-	Body AgendaInvite
-}
-
-// DeleteAgendaInviteInput represents the input for deleting an agenda invite
-type DeleteAgendaInviteInput struct {
-	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
-}
-
-// ViewAgendaInviteInput represents the input for viewing an agenda invite
-type ViewAgendaInviteInput struct {
-	ID       string    `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
-	DateFrom time.Time `query:"DateFrom,omitempty" format:"date-time" doc:"The start date and time for filtering agenda items"`
-	DateTo   time.Time `query:"DateTo,omitempty" format:"date-time" doc:"The end date and time for filtering agenda items"`
-}
-
-// ViewAgendaInviteOutput represents the output for viewing an agenda invite
-type ViewAgendaInviteOutput struct {
-	Body []AgendaItemView
-}
-
 // addRoutes registers all API routes with the provided API instance
-func addRoutes(api huma.API, userController *controllers.UserController, agendaSourceController *controllers.AgendaSourceController) {
+func addRoutes(
+	api huma.API,
+	userController *controllers.UserController,
+	passwordResetController *controllers.PasswordResetController,
+	agendaSourceController *controllers.AgendaSourceController,
+	authController *controllers.AuthController,
+	apiTokenController *controllers.ApiTokenController,
+	agendaInviteController *controllers.AgendaInviteController,
+	adminController *controllers.AdminController,
+) {
 	// Register user endpoints
 	huma.Register(api, huma.Operation{
 		OperationID: "register-user",
@@ -139,6 +79,30 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Tags:        []string{"Users"},
 	}, userController.CreateUser)
 
+	huma.Register(api, huma.Operation{
+		OperationID: "get-me",
+		Method:      http.MethodGet,
+		Path:        "/api/me",
+		Summary:     "Get the authenticated user",
+		Description: "Retrieves the account details of the user the access token belongs to.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.GetMe)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-user",
+		Method:      http.MethodGet,
+		Path:        "/api/users/{id}",
+		Summary:     "Get a user",
+		Description: "Retrieves the account details of the user specified by the `id`. Users can only retrieve their own account.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.GetUser)
+
 	huma.Register(api, huma.Operation{
 		OperationID: "update-user",
 		Method:      http.MethodPut,
@@ -151,16 +115,269 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		},
 	}, userController.UpdateUser)
 
+	huma.Register(api, huma.Operation{
+		OperationID: "delete-user",
+		Method:      http.MethodDelete,
+		Path:        "/api/users/{id}",
+		Summary:     "Delete a user",
+		Description: "Permanently deletes the user specified by the `id` along with their agenda sources, items and invites, and revokes all of their sessions and tokens.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.DeleteUser)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-profile",
+		Method:      http.MethodGet,
+		Path:        "/api/users/{id}/profile",
+		Summary:     "Get a user's preferences",
+		Description: "Retrieves the display name, timezone, locale, week start and working hours of the user specified by the `id`. Unset preferences are returned with their defaults.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.GetProfile)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "update-profile",
+		Method:      http.MethodPut,
+		Path:        "/api/users/{id}/profile",
+		Summary:     "Update a user's preferences",
+		Description: "Updates the given preferences of the user specified by the `id`. They are the defaults for new agenda invites and for the public view of the user's agenda.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.UpdateProfile)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "export-user",
+		Method:      http.MethodGet,
+		Path:        "/api/users/{id}/export",
+		Summary:     "Export all data of a user",
+		Description: "Returns one archive with the profile, agenda sources, agenda items, agenda invites and procedural agendas of the user specified by the `id`. Agenda source URLs are redacted and their credentials left out unless `includeSecrets` is set.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.ExportUser)
+
+	huma.Register(api, huma.Operation{
+		OperationID:  "import-user",
+		Method:       http.MethodPost,
+		Path:         "/api/users/{id}/import",
+		Summary:      "Import an exported archive",
+		Description:  "Restores an archive from `export-user` into the account specified by the `id`, which must not have agenda sources or invites yet. All records get new IDs. Agenda sources exported with redacted URLs need their URL updated afterwards.",
+		Tags:         []string{"Users"},
+		MaxBodyBytes: 32 << 20,
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.ImportUser)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "verify-email",
+		Method:      http.MethodGet,
+		Path:        "/api/verify-email",
+		Summary:     "Confirm an email address",
+		Description: "Confirms the email address a verification link was sent to. Links are signed and expire.",
+		Tags:        []string{"Users"},
+	}, userController.VerifyEmail)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "resend-verification",
+		Method:      http.MethodPost,
+		Path:        "/api/verify-email/resend",
+		Summary:     "Resend the email verification link",
+		Description: "Sends a new verification link to the authenticated user's unverified email address.",
+		Tags:        []string{"Users"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, userController.ResendVerification)
+
+	huma.Register(api, huma.Operation{
+		OperationID:   "request-password-reset",
+		Method:        http.MethodPost,
+		Path:          "/api/password-reset/request",
+		Summary:       "Request a password reset",
+		Description:   "Emails a single-use, short-lived reset link if the address belongs to an account. The response doesn't reveal whether it does.",
+		Tags:          []string{"Users"},
+		DefaultStatus: http.StatusAccepted,
+	}, passwordResetController.RequestPasswordReset)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "reset-password",
+		Method:      http.MethodPost,
+		Path:        "/api/password-reset/confirm",
+		Summary:     "Reset a password",
+		Description: "Sets a new password using the token from a reset email. All sessions of the account are revoked.",
+		Tags:        []string{"Users"},
+	}, passwordResetController.ResetPassword)
+
+	// Register authentication endpoints
+	huma.Register(api, huma.Operation{
+		OperationID: "login",
+		Method:      http.MethodPost,
+		Path:        "/api/login",
+		Summary:     "Log in with email and password",
+		Description: "Checks the user's credentials and returns a short-lived access token to use with the `BearerAuth` scheme. Users with two-factor authentication get an `mfaToken` to complete the login with `login-2fa` instead.",
+		Tags:        []string{"Auth"},
+	}, authController.Login)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "login-2fa",
+		Method:      http.MethodPost,
+		Path:        "/api/login/2fa",
+		Summary:     "Complete a login with a second factor",
+		Description: "Completes a login started with `login` for users with two-factor authentication, using a TOTP code or an unused recovery code. The `mfaToken` completes one login, only the latest one issued to the user is accepted, and after 5 wrong codes the login has to be started again.",
+		Tags:        []string{"Auth"},
+	}, authController.LoginTwoFactor)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "enroll-2fa",
+		Method:      http.MethodPost,
+		Path:        "/api/2fa/enroll",
+		Summary:     "Start two-factor enrollment",
+		Description: "Generates a new TOTP secret. Two-factor authentication is enabled once a code from it is confirmed.",
+		Tags:        []string{"Auth"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, authController.EnrollTwoFactor)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "confirm-2fa",
+		Method:      http.MethodPost,
+		Path:        "/api/2fa/confirm",
+		Summary:     "Confirm two-factor enrollment",
+		Description: "Enables two-factor authentication given a current TOTP code, and returns single-use recovery codes.",
+		Tags:        []string{"Auth"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, authController.ConfirmTwoFactor)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "disable-2fa",
+		Method:      http.MethodPost,
+		Path:        "/api/2fa/disable",
+		Summary:     "Disable two-factor authentication",
+		Description: "Disables two-factor authentication given a current TOTP code or an unused recovery code.",
+		Tags:        []string{"Auth"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, authController.DisableTwoFactor)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-oidc-providers",
+		Method:      http.MethodGet,
+		Path:        "/api/oidc/providers",
+		Summary:     "List the single sign-on providers",
+		Description: "Lists the OpenID Connect providers users can log in with, and whether password login is disabled.",
+		Tags:        []string{"Auth"},
+	}, authController.GetOidcProviders)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "start-oidc-login",
+		Method:      http.MethodPost,
+		Path:        "/api/oidc/{provider}/start",
+		Summary:     "Start a single sign-on login",
+		Description: "Starts an OpenID Connect authorization code flow with PKCE. Send the user to the returned `authorizationUrl` and keep the `flowToken` for `complete-oidc-login`.",
+		Tags:        []string{"Auth"},
+	}, authController.StartOidcLogin)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "complete-oidc-login",
+		Method:      http.MethodPost,
+		Path:        "/api/oidc/{provider}/callback",
+		Summary:     "Complete a single sign-on login",
+		Description: "Redeems the authorization code the provider passed to the redirect URL and starts a session. Unknown identities are linked to the account with the same verified email address, or get a new account. Two-factor authentication is left to the provider.",
+		Tags:        []string{"Auth"},
+	}, authController.CompleteOidcLogin)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "refresh-token",
+		Method:      http.MethodPost,
+		Path:        "/api/token/refresh",
+		Summary:     "Exchange a refresh token",
+		Description: "Rotates the refresh token of a session and returns a new access token. A refresh token can only be used once; reusing it revokes the session.",
+		Tags:        []string{"Auth"},
+	}, authController.RefreshToken)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "logout",
+		Method:      http.MethodPost,
+		Path:        "/api/logout",
+		Summary:     "Log out of the current session",
+		Description: "Revokes the session the access token belongs to, invalidating its refresh token.",
+		Tags:        []string{"Auth"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, authController.Logout)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "logout-all",
+		Method:      http.MethodPost,
+		Path:        "/api/logout-all",
+		Summary:     "Sign out everywhere",
+		Description: "Revokes every session of the authenticated user.",
+		Tags:        []string{"Auth"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, authController.LogoutAll)
+
+	// Register personal access token endpoints
+	huma.Register(api, huma.Operation{
+		OperationID: "create-api-token",
+		Method:      http.MethodPost,
+		Path:        "/api/tokens",
+		Summary:     "Create a personal access token",
+		Description: "Creates a named token for scripts and sync agents, limited to the given scopes and optionally expiring. The token is only returned by this operation; store it safely.",
+		Tags:        []string{"API Tokens"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, apiTokenController.CreateApiToken)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-api-tokens",
+		Method:      http.MethodGet,
+		Path:        "/api/tokens",
+		Summary:     "List personal access tokens",
+		Description: "Retrieves the authenticated user's personal access tokens, newest first. Supports pagination.",
+		Tags:        []string{"API Tokens"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, apiTokenController.GetApiTokens)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "delete-api-token",
+		Method:      http.MethodDelete,
+		Path:        "/api/tokens/{id}",
+		Summary:     "Revoke a personal access token",
+		Description: "Revokes a personal access token by its unique identifier.",
+		Tags:        []string{"API Tokens"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, apiTokenController.DeleteApiToken)
+
 	// Register agenda source endpoints
 	huma.Register(api, huma.Operation{
 		OperationID: "get-agenda-sources",
 		Method:      http.MethodGet,
 		Path:        "/api/agenda-sources",
 		Summary:     "Get a list of agenda sources",
-		Description: "Retrieves a list of all agenda sources. Supports ordering by `updatedAt` and pagination.",
+		Description: "Retrieves the agenda sources owned by the authenticated user. Supports ordering by `updatedAt` and pagination.",
 		Tags:        []string{"Agenda Sources"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeSourcesRead}},
 		},
 	}, agendaSourceController.GetAgendaSources)
 
@@ -172,7 +389,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Creates a new agenda source with a URL and type.",
 		Tags:        []string{"Agenda Sources"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeSourcesWrite}},
 		},
 	}, agendaSourceController.CreateAgendaSource)
 
@@ -184,7 +401,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Retrieves an agenda source by its unique identifier.",
 		Tags:        []string{"Agenda Sources"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeSourcesRead}},
 		},
 	}, agendaSourceController.GetAgendaSource)
 
@@ -196,7 +413,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Updates the URL and/or type of an agenda source by its unique identifier.",
 		Tags:        []string{"Agenda Sources"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeSourcesWrite}},
 		},
 	}, agendaSourceController.UpdateAgendaSource)
 
@@ -208,10 +425,58 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Deletes an agenda source by its unique identifier.",
 		Tags:        []string{"Agenda Sources"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeSourcesWrite}},
 		},
 	}, agendaSourceController.DeleteAgendaSource)
 
+	huma.Register(api, huma.Operation{
+		OperationID: "reveal-agenda-source-url",
+		Method:      http.MethodPost,
+		Path:        "/api/agenda-sources/{id}/reveal-url",
+		Summary:     "Reveal the URL of an agenda source",
+		Description: "Returns the full URL of an agenda source, which other responses redact as it usually grants access to the calendar. Only available with a session, not with personal access tokens.",
+		Tags:        []string{"Agenda Sources"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, agendaSourceController.RevealAgendaSourceURL)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "sync-agenda-source",
+		Method:      http.MethodPost,
+		Path:        "/api/agenda-sources/{id}/sync",
+		Summary:     "Sync an agenda source now",
+		Description: "Syncs an agenda source right away and returns the outcome, which is also recorded in its sync history. A sync that fails still returns 200 with the `failed` status and the error; 409 is returned while the agenda source is already being synced, or when it was imported without its real URL and needs it first.",
+		Tags:        []string{"Agenda Sources"},
+		Security: []map[string][]string{
+			{"BearerAuth": {controllers.ScopeSourcesWrite}},
+		},
+	}, agendaSourceController.SyncAgendaSource)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "get-agenda-source-syncs",
+		Method:      http.MethodGet,
+		Path:        "/api/agenda-sources/{id}/syncs",
+		Summary:     "Get the sync history of an agenda source",
+		Description: "Retrieves the latest syncs of an agenda source, newest first, with their duration and the number of agenda items they added, updated and removed. Supports pagination.",
+		Tags:        []string{"Agenda Sources"},
+		Security: []map[string][]string{
+			{"BearerAuth": {controllers.ScopeSourcesRead}},
+		},
+	}, agendaSourceController.GetAgendaSourceSyncs)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "list-agenda-source-types",
+		Method:      http.MethodGet,
+		Path:        "/api/agenda-source-types",
+		Summary:     "List the agenda source types",
+		Description: "Lists the types of agenda sources this server supports, with the URL schemes each of them accepts.",
+		Tags:        []string{"Agenda Sources"},
+		Security: []map[string][]string{
+			{"BearerAuth": {controllers.ScopeSourcesRead}},
+		},
+	}, agendaSourceController.ListAgendaSourceTypes)
+
 	// Register agenda item endpoints
 	huma.Register(api, huma.Operation{
 		OperationID: "create-agenda-items",
@@ -221,7 +486,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Accepts multiple AgendaItem objects and creates or updates them.",
 		Tags:        []string{"Agenda Items"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeItemsWrite}},
 		},
 	}, func(ctx context.Context, input *CreateAgendaItemsInput) (*struct{}, error) {
 		// This is a mock implementation - just return 200 OK
@@ -236,7 +501,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Retrieves agenda items based on query parameters.",
 		Tags:        []string{"Agenda Items"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeItemsRead}},
 		},
 	}, func(ctx context.Context, input *GetAgendaItemsInput) (*GetAgendaItemsOutput, error) {
 		// This is a mock implementation
@@ -268,7 +533,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Retrieves an agenda item by its ResourceID.",
 		Tags:        []string{"Agenda Items"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeItemsRead}},
 		},
 	}, func(ctx context.Context, input *GetAgendaItemInput) (*GetAgendaItemOutput, error) {
 		// This is a mock implementation
@@ -290,7 +555,7 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Deletes agenda items by their ResourceIDs.",
 		Tags:        []string{"Agenda Items"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeItemsWrite}},
 		},
 	}, func(ctx context.Context, input *DeleteAgendaItemInput) (*struct{}, error) {
 		// This is a mock implementation - just return 204 No Content
@@ -303,18 +568,12 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Method:      http.MethodPost,
 		Path:        "/api/agenda-invites",
 		Summary:     "Create a new agenda invite",
-		Description: "Creates a new AgendaInvite.",
+		Description: "Creates a new AgendaInvite showing some of the caller's agenda sources. Its timezone and working hours follow the caller's profile unless it sets its own. The user's email address must be verified.",
 		Tags:        []string{"Agenda Invites"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeInvitesWrite}},
 		},
-	}, func(ctx context.Context, input *CreateAgendaInviteInput) (*CreateAgendaInviteOutput, error) {
-		// This is a mock implementation
-		resp := &CreateAgendaInviteOutput{}
-		resp.Body = input.Body
-		resp.Body.ResourceID = uuid.New().String()
-		return resp, nil
-	})
+	}, agendaInviteController.CreateAgendaInvite)
 
 	huma.Register(api, huma.Operation{
 		OperationID: "get-agenda-invite",
@@ -324,50 +583,21 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Retrieves an AgendaInvite by its ResourceID.",
 		Tags:        []string{"Agenda Invites"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeInvitesRead}},
 		},
-	}, func(ctx context.Context, input *GetAgendaInviteInput) (*GetAgendaInviteOutput, error) {
-		// This is a mock implementation
-		resp := &GetAgendaInviteOutput{}
-		resp.Body.ResourceID = input.ID
-		resp.Body.UserID = uuid.New().String()
-		resp.Body.Description = "Sample agenda invite"
-		resp.Body.ExpiresAt = time.Now().Add(7 * 24 * time.Hour)
-		resp.Body.NotBefore = time.Now()
-		resp.Body.NotAfter = time.Now().Add(7 * 24 * time.Hour)
-		resp.Body.PaddingBefore = "30m"
-		resp.Body.PaddingAfter = "30m"
-		resp.Body.SlotSizes = []string{"1h", "30m"}
-		resp.Body.AgendaSources = []controllers.AgendaSource{
-			{
-				ID:        uuid.New().String(),
-				URL:       "https://example.com/calendar",
-				Type:      "proton",
-				UserID:    uuid.New().String(),
-				CreatedAt: time.Now().Add(-24 * time.Hour),
-				UpdatedAt: time.Now(),
-			},
-		}
-		return resp, nil
-	})
+	}, agendaInviteController.GetAgendaInvite)
 
 	huma.Register(api, huma.Operation{
 		OperationID: "update-agenda-invite",
 		Method:      http.MethodPut,
 		Path:        "/api/agenda-invites/{id}",
 		Summary:     "Update an agenda invite by ID",
-		Description: "Updates an AgendaInvite by its ResourceID.",
+		Description: "Replaces the settings of an AgendaInvite by its ResourceID. Omitted settings are cleared, so the timezone and working hours follow the owner's profile again. The user's email address must be verified.",
 		Tags:        []string{"Agenda Invites"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeInvitesWrite}},
 		},
-	}, func(ctx context.Context, input *UpdateAgendaInviteInput) (*UpdateAgendaInviteOutput, error) {
-		// This is a mock implementation
-		resp := &UpdateAgendaInviteOutput{}
-		resp.Body = input.Body
-		resp.Body.ResourceID = input.ID
-		return resp, nil
-	})
+	}, agendaInviteController.UpdateAgendaInvite)
 
 	huma.Register(api, huma.Operation{
 		OperationID: "delete-agenda-invite",
@@ -377,35 +607,80 @@ func addRoutes(api huma.API, userController *controllers.UserController, agendaS
 		Description: "Deletes an AgendaInvite by its ResourceID.",
 		Tags:        []string{"Agenda Invites"},
 		Security: []map[string][]string{
-			{"BearerAuth": {}},
+			{"BearerAuth": {controllers.ScopeInvitesWrite}},
 		},
-	}, func(ctx context.Context, input *DeleteAgendaInviteInput) (*struct{}, error) {
-		// This is a mock implementation - just return 204 No Content
-		return nil, nil
-	})
+	}, agendaInviteController.DeleteAgendaInvite)
 
 	huma.Register(api, huma.Operation{
 		OperationID: "view-agenda-invite",
 		Method:      http.MethodGet,
 		Path:        "/api/view-agenda-invite/{id}",
 		Summary:     "Publicly available view of a user agenda",
-		Description: "Retrieves a list of AgendaItemViews for the specified invite ID within the given date range.",
+		Description: "Retrieves a list of AgendaItemViews for the specified invite ID within the given date range, in the timezone of the invite or its owner. Items show as much as the visibility of the invite, or else of their agenda source, allows: only busy time, also their titles, or also their locations and notes. Without a start date the range starts at the beginning of the current day in that timezone. Ranges longer than six weeks are refused with 422. Returns 410 Gone if the invite has expired or its owner's account is disabled.",
 		Tags:        []string{"Agenda Invites"},
-	}, func(ctx context.Context, input *ViewAgendaInviteInput) (*ViewAgendaInviteOutput, error) {
-		// This is a mock implementation
-		resp := &ViewAgendaInviteOutput{}
-		resp.Body = []AgendaItemView{
-			{
-				StartTime:   time.Now().Add(1 * time.Hour),
-				EndTime:     time.Now().Add(2 * time.Hour),
-				Description: "Sample agenda item",
-			},
-			{
-				StartTime:   time.Now().Add(3 * time.Hour),
-				EndTime:     time.Now().Add(4 * time.Hour),
-				Description: "Another sample agenda item",
-			},
-		}
-		return resp, nil
-	})
+	}, agendaInviteController.ViewAgendaInvite)
+
+	// Register admin endpoints
+	huma.Register(api, huma.Operation{
+		OperationID: "get-users",
+		Method:      http.MethodGet,
+		Path:        "/api/admin/users",
+		Summary:     "List and search users",
+		Description: "Retrieves users with pagination, optionally filtered by email, role or status. Admins only.",
+		Tags:        []string{"Admin"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, adminController.GetUsers)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "disable-user",
+		Method:      http.MethodPost,
+		Path:        "/api/admin/users/{id}/disable",
+		Summary:     "Disable a user",
+		Description: "Disables the account specified by the `id` and revokes its sessions. Disabled users can't authenticate and their invites are gone from the public view. Admins only.",
+		Tags:        []string{"Admin"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, adminController.DisableUser)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "enable-user",
+		Method:      http.MethodPost,
+		Path:        "/api/admin/users/{id}/enable",
+		Summary:     "Re-enable a user",
+		Description: "Re-enables the disabled account specified by the `id`. Admins only.",
+		Tags:        []string{"Admin"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, adminController.EnableUser)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "set-user-role",
+		Method:      http.MethodPut,
+		Path:        "/api/admin/users/{id}/role",
+		Summary:     "Change a user's role",
+		Description: "Grants or removes admin rights for the user specified by the `id`. Admins only.",
+		Tags:        []string{"Admin"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, adminController.SetUserRole)
+
+	huma.Register(api, huma.Operation{
+		OperationID: "force-delete-user",
+		Method:      http.MethodDelete,
+		Path:        "/api/admin/users/{id}",
+		Summary:     "Force-delete a user",
+		Description: "Permanently deletes the account specified by the `id` with all of its data, like `delete-user`. Admins only.",
+		Tags:        []string{"Admin"},
+		Security: []map[string][]string{
+			{"BearerAuth": {}},
+		},
+	}, adminController.ForceDeleteUser)
+
+	// The supported source types are only known at runtime
+	controllers.DocumentSourceTypes(api, agendaSourceController.Providers)
 }
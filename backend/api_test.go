@@ -2,22 +2,40 @@ package main
 
 import (
 	"awesomeProject/controllers"
+	"awesomeProject/mailer"
 	"awesomeProject/models"
+	"awesomeProject/oidc"
+	"awesomeProject/oidc/oidctest"
+	"awesomeProject/secrets"
+	"awesomeProject/sources"
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"regexp"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/danielgtaylor/huma/v2/humatest"
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// testKeyring encrypts the secrets of agenda sources in the tests
+var testKeyring, _ = secrets.NewKeyring(secrets.Key{ID: "test", Secret: bytes.Repeat([]byte{7}, secrets.KeySize)})
+
 // setupTestDB creates a test database connection
 func setupTestDB() (*gorm.DB, error) {
 	// Use environment variables or default values
@@ -46,6 +64,8 @@ func setupTestDB() (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		host, port, user, password, dbname)
 
+	secrets.Register(testKeyring)
+
 	// Connect to PostgreSQL
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -53,7 +73,7 @@ func setupTestDB() (*gorm.DB, error) {
 	}
 
 	// Auto-migrate all models
-	err = db.AutoMigrate(&models.User{}, &models.AgendaSource{})
+	err = db.AutoMigrate(&models.User{}, &models.Session{}, &models.ApiToken{}, &models.PasswordResetToken{}, &models.RecoveryCode{}, &models.OidcIdentity{}, &models.AgendaSource{}, &models.CalendarCollection{}, &models.CalendarObject{}, &models.SyncRun{}, &models.AgendaItem{}, &models.ProceduralAgenda{}, &models.AgendaInvite{})
 	if err != nil {
 		return nil, err
 	}
@@ -61,25 +81,249 @@ func setupTestDB() (*gorm.DB, error) {
 	return db, nil
 }
 
+// testAuthConfig is the token configuration shared by all API tests
+var testAuthConfig = &controllers.AuthConfig{
+	SigningKey:      []byte("test-signing-key"),
+	Issuer:          "proton-agenda-test",
+	Audience:        "proton-agenda-test-api",
+	AccessTokenTTL:  15 * time.Minute,
+	RefreshTokenTTL: 24 * time.Hour,
+}
+
+// testPasswordHasher uses cheap argon2id parameters to keep the tests fast
+var testPasswordHasher = &controllers.PasswordHasher{
+	Memory:      1024,
+	Iterations:  1,
+	Parallelism: 1,
+	SaltLength:  16,
+	KeyLength:   32,
+}
+
+// testOutbox collects the emails sent by the API under test
+var testOutbox = &mailer.OutboxMailer{From: "noreply@example.com"}
+
+// testOidcServer is a stub identity provider the API under test can log in with
+var testOidcServer = oidctest.NewServer("proton-agenda", "client-secret")
+
+// testOidcProvider is the API's configuration of testOidcServer
+var testOidcProvider, _ = oidc.NewProvider(oidc.Config{
+	Name:         "stub",
+	Issuer:       testOidcServer.Issuer(),
+	ClientID:     "proton-agenda",
+	ClientSecret: "client-secret",
+	RedirectURL:  "https://agenda.example.com/oidc/stub/callback",
+})
+
 // setupAPI creates a test API with the controllers
 func setupAPI(t *testing.T, db *gorm.DB) humatest.TestAPI {
 	_, api := humatest.New(t)
 
+	// Enforce bearer authentication like the real server does
+	api.UseMiddleware(controllers.NewAuthMiddleware(api, db, testAuthConfig))
+
+	// The test servers are the only private hosts sources may reach
+	guard, err := sources.NewGuard([]string{"127.0.0.1"})
+	if err != nil {
+		t.Fatalf("Failed to create guard: %v", err)
+	}
+	feeds := sources.ICSProvider{Client: guard.Client(10 * time.Second), Guard: guard}
+	providers := sources.NewRegistry(&feeds, &sources.ProtonProvider{ICSProvider: feeds}, &sources.CalDAVProvider{DB: db, Client: feeds.Client, Guard: guard})
 	// Create controllers with the real DB
 	userController := &controllers.UserController{
-		DB: db,
+		DB:        db,
+		Passwords: testPasswordHasher,
+		Providers: providers,
+		Verifier: &controllers.EmailVerifier{
+			Config:    testAuthConfig,
+			Mailer:    testOutbox,
+			PublicURL: "https://agenda.example.com",
+			TTL:       time.Hour,
+		},
+	}
+
+	passwordResetController := &controllers.PasswordResetController{
+		DB:        db,
+		Passwords: testPasswordHasher,
+		Mailer:    testOutbox,
+		PublicURL: "https://agenda.example.com",
+		TTL:       30 * time.Minute,
 	}
 
 	agendaSourceController := &controllers.AgendaSourceController{
+		DB:        db,
+		Providers: providers,
+		Scheduler: &sources.Scheduler{Syncer: &sources.Syncer{DB: db, Providers: providers}},
+	}
+
+	authController := &controllers.AuthController{
+		DB:            db,
+		Config:        testAuthConfig,
+		Passwords:     testPasswordHasher,
+		OidcProviders: []*oidc.Provider{testOidcProvider},
+	}
+
+	apiTokenController := &controllers.ApiTokenController{
+		DB: db,
+	}
+
+	agendaInviteController := &controllers.AgendaInviteController{
+		DB: db,
+	}
+
+	adminController := &controllers.AdminController{
 		DB: db,
 	}
 
 	// Register routes using the addRoutes function
-	addRoutes(api, userController, agendaSourceController)
+	addRoutes(api, userController, passwordResetController, agendaSourceController, authController, apiTokenController, agendaInviteController, adminController)
 
 	return api
 }
 
+// createTestAdmin stores a user with the admin role directly in the database
+func createTestAdmin(t *testing.T, db *gorm.DB) models.User {
+	admin := createTestUser(t, db)
+	if err := db.Model(&admin).Update("role", models.RoleAdmin).Error; err != nil {
+		t.Fatalf("Failed to promote test user: %v", err)
+	}
+	return admin
+}
+
+// createTestUser stores a user directly in the database
+func createTestUser(t *testing.T, db *gorm.DB) models.User {
+	user := models.User{
+		ResourceID:   uuid.New(),
+		Email:        "user-" + uuid.NewString() + "@example.com",
+		PasswordHash: "hashedpassword",
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+	return user
+}
+
+// createTestSession stores a new session of the user, which access tokens are bound to
+func createTestSession(t *testing.T, db *gorm.DB, user models.User) *models.Session {
+	session := models.Session{
+		ResourceID:       uuid.New(),
+		UserID:           user.ID,
+		RefreshTokenHash: uuid.NewString(),
+		ExpiresAt:        time.Now().Add(time.Hour),
+		LastUsedAt:       time.Now(),
+	}
+	if err := db.Create(&session).Error; err != nil {
+		t.Fatalf("Failed to create test session: %v", err)
+	}
+	return &session
+}
+
+// authHeader returns an Authorization header carrying an access token for a new session of the user
+func authHeader(t *testing.T, db *gorm.DB, user models.User) string {
+	token, _, err := testAuthConfig.IssueSessionAccessToken(&user, createTestSession(t, db, user))
+	if err != nil {
+		t.Fatalf("Failed to issue access token: %v", err)
+	}
+	return "Authorization: Bearer " + token
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	user := createTestUser(t, db)
+
+	t.Run("Missing token", func(t *testing.T) {
+		resp := api.Get("/api/agenda-sources")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Header().Get("WWW-Authenticate"), "Bearer")
+	})
+
+	t.Run("Malformed token", func(t *testing.T) {
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer not-a-jwt")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Token signed with another key", func(t *testing.T) {
+		other := *testAuthConfig
+		other.SigningKey = []byte("some-other-key")
+		token, _, err := other.IssueSessionAccessToken(&user, createTestSession(t, db, user))
+		assert.NoError(t, err)
+
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Token for another audience", func(t *testing.T) {
+		other := *testAuthConfig
+		other.Audience = "someone-else"
+		token, _, err := other.IssueSessionAccessToken(&user, createTestSession(t, db, user))
+		assert.NoError(t, err)
+
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		other := *testAuthConfig
+		other.AccessTokenTTL = -time.Minute
+		token, _, err := other.IssueSessionAccessToken(&user, createTestSession(t, db, user))
+		assert.NoError(t, err)
+
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Token for a deleted user", func(t *testing.T) {
+		ghost := models.User{ResourceID: uuid.New()}
+		token, _, err := testAuthConfig.IssueSessionAccessToken(&ghost, &models.Session{ResourceID: uuid.New()})
+		assert.NoError(t, err)
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Token without a session", func(t *testing.T) {
+		now := time.Now()
+		claims := controllers.AccessClaims{RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    testAuthConfig.Issuer,
+			Subject:   user.ResourceID.String(),
+			Audience:  jwt.ClaimStrings{testAuthConfig.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(time.Hour)),
+		}}
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(testAuthConfig.SigningKey)
+		assert.NoError(t, err)
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Token of a revoked session", func(t *testing.T) {
+		session := createTestSession(t, db, user)
+		token, _, err := testAuthConfig.IssueSessionAccessToken(&user, session)
+		assert.NoError(t, err)
+		assert.NoError(t, db.Model(session).Update("revoked_at", time.Now()).Error)
+		resp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Valid token", func(t *testing.T) {
+		resp := api.Get("/api/agenda-sources", authHeader(t, db, user))
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("Public operation without token", func(t *testing.T) {
+		invite := models.AgendaInvite{ResourceID: uuid.New(), UserID: user.ID}
+		assert.NoError(t, db.Create(&invite).Error)
+
+		resp := api.Get("/api/view-agenda-invite/" + invite.ResourceID.String())
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+}
+
 func TestCreateUser(t *testing.T) {
 	// Setup test database
 	db, err := setupTestDB()
@@ -90,36 +334,1730 @@ func TestCreateUser(t *testing.T) {
 	// Setup API
 	api := setupAPI(t, db)
 
+	email := "test-" + uuid.NewString() + "@example.com"
+
 	// Test successful user creation
 	t.Run("Successful user registration", func(t *testing.T) {
 		// Make a request to register a user
 		resp := api.Post("/api/register", map[string]interface{}{
-			"email":    "test@example.com",
+			"email":    email,
 			"password": "password123",
 		})
 
 		// Check response status code
 		assert.Equal(t, http.StatusOK, resp.Code)
 
-		// Parse response body
-		var responseBody struct {
-			ID        string    `json:"id"`
-			Email     string    `json:"email"`
-			CreatedAt time.Time `json:"createdAt"`
-			UpdatedAt time.Time `json:"updatedAt"`
-		}
-		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
-		assert.NoError(t, err)
+		// Parse response body
+		var responseBody struct {
+			ID        string    `json:"id"`
+			Email     string    `json:"email"`
+			CreatedAt time.Time `json:"createdAt"`
+			UpdatedAt time.Time `json:"updatedAt"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+
+		// Verify response fields
+		assert.NotEmpty(t, responseBody.ID)
+		assert.Equal(t, email, responseBody.Email)
+		assert.False(t, responseBody.CreatedAt.IsZero())
+		assert.False(t, responseBody.UpdatedAt.IsZero())
+	})
+
+	// Emails are unique regardless of case
+	t.Run("Duplicate email", func(t *testing.T) {
+		resp := api.Post("/api/register", map[string]interface{}{
+			"email":    strings.ToUpper(email),
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusConflict, resp.Code)
+
+		var problem struct {
+			Status int    `json:"status"`
+			Code   string `json:"code"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &problem))
+		assert.Equal(t, http.StatusConflict, problem.Status)
+		assert.Equal(t, controllers.CodeEmailTaken, problem.Code)
+	})
+}
+
+func TestLogin(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	// Register a user through the API
+	email := "login-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+
+	t.Run("Password is stored hashed", func(t *testing.T) {
+		var user models.User
+		err := db.Where("email = ?", email).First(&user).Error
+		assert.NoError(t, err)
+		assert.NotEqual(t, "password123", user.PasswordHash)
+		assert.Contains(t, user.PasswordHash, "$argon2id$")
+	})
+
+	t.Run("Successful login", func(t *testing.T) {
+		resp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var responseBody struct {
+			AccessToken string    `json:"accessToken"`
+			TokenType   string    `json:"tokenType"`
+			ExpiresAt   time.Time `json:"expiresAt"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, responseBody.AccessToken)
+		assert.Equal(t, "Bearer", responseBody.TokenType)
+		assert.True(t, responseBody.ExpiresAt.After(time.Now()))
+
+		// The token grants access to protected operations
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+responseBody.AccessToken)
+		assert.Equal(t, http.StatusOK, listResp.Code)
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		resp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "wrongpassword",
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Unknown email", func(t *testing.T) {
+		resp := api.Post("/api/login", map[string]interface{}{
+			"email":    "nobody-" + uuid.NewString() + "@example.com",
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Outdated hash is upgraded on login", func(t *testing.T) {
+		// Store a hash made with weaker parameters than the configured ones
+		weak := *testPasswordHasher
+		weak.Memory = 512
+		hash, err := weak.Hash("password123")
+		assert.NoError(t, err)
+		err = db.Model(&models.User{}).Where("email = ?", email).Update("password_hash", hash).Error
+		assert.NoError(t, err)
+
+		resp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var user models.User
+		err = db.Where("email = ?", email).First(&user).Error
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, user.PasswordHash)
+		assert.Contains(t, user.PasswordHash, "m=1024,")
+	})
+}
+
+// tokenResponse mirrors controllers.TokenResponse for decoding test responses
+type tokenResponse struct {
+	AccessToken  string `json:"accessToken"`
+	RefreshToken string `json:"refreshToken"`
+}
+
+// login logs the user in through the API and returns the issued tokens
+func login(t *testing.T, api humatest.TestAPI, email, password string) tokenResponse {
+	resp := api.Post("/api/login", map[string]interface{}{
+		"email":    email,
+		"password": password,
+	})
+	if resp.Code != http.StatusOK {
+		t.Fatalf("Login failed with status %d: %s", resp.Code, resp.Body.String())
+	}
+	var tokens tokenResponse
+	if err := json.Unmarshal(resp.Body.Bytes(), &tokens); err != nil {
+		t.Fatalf("Failed to decode login response: %v", err)
+	}
+	return tokens
+}
+
+func TestSessions(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	// Register a user through the API
+	email := "session-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+
+	t.Run("Refresh rotates the token", func(t *testing.T) {
+		tokens := login(t, api, email, "password123")
+		assert.NotEmpty(t, tokens.RefreshToken)
+
+		resp := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": tokens.RefreshToken,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var rotated tokenResponse
+		err := json.Unmarshal(resp.Body.Bytes(), &rotated)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, rotated.AccessToken)
+		assert.NotEqual(t, tokens.RefreshToken, rotated.RefreshToken)
+
+		// Reusing the old refresh token revokes the session entirely
+		reuse := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": tokens.RefreshToken,
+		})
+		assert.Equal(t, http.StatusUnauthorized, reuse.Code)
+
+		next := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": rotated.RefreshToken,
+		})
+		assert.Equal(t, http.StatusUnauthorized, next.Code)
+
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+rotated.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+	})
+
+	t.Run("Unknown refresh token", func(t *testing.T) {
+		resp := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": "not-a-token",
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("Logout revokes the session", func(t *testing.T) {
+		tokens := login(t, api, email, "password123")
+		auth := "Authorization: Bearer " + tokens.AccessToken
+
+		resp := api.Post("/api/logout", auth)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		listResp := api.Get("/api/agenda-sources", auth)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+
+		refreshResp := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": tokens.RefreshToken,
+		})
+		assert.Equal(t, http.StatusUnauthorized, refreshResp.Code)
+	})
+
+	t.Run("Sign out everywhere", func(t *testing.T) {
+		first := login(t, api, email, "password123")
+		second := login(t, api, email, "password123")
+
+		resp := api.Post("/api/logout-all", "Authorization: Bearer "+first.AccessToken)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		for _, tokens := range []tokenResponse{first, second} {
+			listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+tokens.AccessToken)
+			assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+		}
+	})
+
+	t.Run("Password change revokes other sessions", func(t *testing.T) {
+		current := login(t, api, email, "password123")
+		other := login(t, api, email, "password123")
+
+		var user models.User
+		err := db.Where("email = ?", email).First(&user).Error
+		assert.NoError(t, err)
+
+		resp := api.Put("/api/users/"+user.ResourceID.String(), "Authorization: Bearer "+current.AccessToken, map[string]interface{}{
+			"password": "newpassword123",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		currentResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+current.AccessToken)
+		assert.Equal(t, http.StatusOK, currentResp.Code)
+
+		otherResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+other.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, otherResp.Code)
+	})
+}
+
+func TestApiTokens(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	auth := authHeader(t, db, createTestUser(t, db))
+
+	// Create a read-only token
+	createResp := api.Post("/api/tokens", auth, map[string]interface{}{
+		"name":   "cron sync",
+		"scopes": []string{"sources:read"},
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+
+	var created struct {
+		ID     string   `json:"id"`
+		Name   string   `json:"name"`
+		Prefix string   `json:"prefix"`
+		Scopes []string `json:"scopes"`
+		Token  string   `json:"token"`
+	}
+	err = json.Unmarshal(createResp.Body.Bytes(), &created)
+	assert.NoError(t, err)
+	assert.Equal(t, "cron sync", created.Name)
+	assert.Equal(t, []string{"sources:read"}, created.Scopes)
+	assert.Contains(t, created.Token, controllers.ApiTokenPrefix)
+	assert.Contains(t, created.Token, created.Prefix)
+	patAuth := "Authorization: Bearer " + created.Token
+
+	t.Run("Only the hash is stored", func(t *testing.T) {
+		var stored models.ApiToken
+		err := db.Where("resource_id = ?", created.ID).First(&stored).Error
+		assert.NoError(t, err)
+		assert.NotEqual(t, created.Token, stored.TokenHash)
+		assert.NotContains(t, stored.TokenHash, created.Token)
+	})
+
+	t.Run("Token with the required scope", func(t *testing.T) {
+		resp := api.Get("/api/agenda-sources", patAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var stored models.ApiToken
+		err := db.Where("resource_id = ?", created.ID).First(&stored).Error
+		assert.NoError(t, err)
+		assert.NotNil(t, stored.LastUsedAt)
+	})
+
+	t.Run("Token without the required scope", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", patAuth, map[string]interface{}{
+			"url":  "https://example.com/calendar",
+			"type": "proton",
+		})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Token can't manage tokens", func(t *testing.T) {
+		resp := api.Get("/api/tokens", patAuth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("List tokens", func(t *testing.T) {
+		resp := api.Get("/api/tokens", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), created.ID)
+		assert.NotContains(t, resp.Body.String(), created.Token)
+	})
+
+	t.Run("Expiry in the past is rejected", func(t *testing.T) {
+		resp := api.Post("/api/tokens", auth, map[string]interface{}{
+			"name":      "stale",
+			"scopes":    []string{"items:write"},
+			"expiresAt": time.Now().Add(-time.Hour),
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		resp := api.Post("/api/tokens", auth, map[string]interface{}{
+			"name":      "short lived",
+			"scopes":    []string{"sources:read"},
+			"expiresAt": time.Now().Add(time.Hour),
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var shortLived struct {
+			ID    string `json:"id"`
+			Token string `json:"token"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &shortLived)
+		assert.NoError(t, err)
+
+		err = db.Model(&models.ApiToken{}).Where("resource_id = ?", shortLived.ID).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error
+		assert.NoError(t, err)
+
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+shortLived.Token)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+	})
+
+	t.Run("Revoked token", func(t *testing.T) {
+		resp := api.Delete("/api/tokens/"+created.ID, auth)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		listResp := api.Get("/api/agenda-sources", patAuth)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+	})
+}
+
+// verificationLinkPattern extracts the token from a verification email
+var verificationLinkPattern = regexp.MustCompile(`/api/verify-email\?token=(\S+)`)
+
+// lastVerificationToken returns the token of the last verification link sent to the address
+func lastVerificationToken(t *testing.T, email string) string {
+	msg, ok := testOutbox.Last(email)
+	if !ok {
+		t.Fatalf("No email sent to %s", email)
+	}
+	match := verificationLinkPattern.FindStringSubmatch(msg.Body)
+	if match == nil {
+		t.Fatalf("No verification link in email: %s", msg.Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatalf("Malformed verification link: %v", err)
+	}
+	return token
+}
+
+func TestEmailVerification(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	// Register a user through the API
+	email := "verify-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+	assert.Contains(t, createResp.Body.String(), `"emailVerified":false`)
+
+	var user models.User
+	err = db.Where("email = ?", email).First(&user).Error
+	assert.NoError(t, err)
+	assert.Nil(t, user.EmailVerifiedAt)
+	auth := authHeader(t, db, user)
+
+	invite := map[string]interface{}{
+		"Description":   "Office hours",
+		"ExpiresAt":     time.Now().Add(24 * time.Hour),
+		"NotBefore":     time.Now(),
+		"NotAfter":      time.Now().Add(7 * 24 * time.Hour),
+		"PaddingBefore": "0s",
+		"PaddingAfter":  "0s",
+		"SlotSizes":     []string{"30m"},
+	}
+
+	t.Run("Unverified user can't publish invites", func(t *testing.T) {
+		resp := api.Post("/api/agenda-invites", auth, invite)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Tampered link is rejected", func(t *testing.T) {
+		token := lastVerificationToken(t, email)
+		resp := api.Get("/api/verify-email?token=" + url.QueryEscape(token+"x"))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Access token can't verify an email", func(t *testing.T) {
+		token, _, err := testAuthConfig.IssueSessionAccessToken(&user, createTestSession(t, db, user))
+		assert.NoError(t, err)
+		resp := api.Get("/api/verify-email?token=" + url.QueryEscape(token))
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Verification link confirms the address", func(t *testing.T) {
+		token := lastVerificationToken(t, email)
+		resp := api.Get("/api/verify-email?token=" + url.QueryEscape(token))
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"emailVerified":true`)
+
+		// Verification tokens are not access tokens
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+token)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+	})
+
+	t.Run("Verified user can publish invites", func(t *testing.T) {
+		resp := api.Post("/api/agenda-invites", auth, invite)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("Resend is refused once verified", func(t *testing.T) {
+		resp := api.Post("/api/verify-email/resend", auth)
+		assert.Equal(t, http.StatusConflict, resp.Code)
+	})
+
+	t.Run("Changing the email requires verifying it again", func(t *testing.T) {
+		oldToken := lastVerificationToken(t, email)
+		newEmail := "verify-" + uuid.NewString() + "@example.com"
+		resp := api.Put("/api/users/"+user.ResourceID.String(), auth, map[string]interface{}{
+			"email": newEmail,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"emailVerified":false`)
+
+		// A link for the previous address no longer works
+		oldResp := api.Get("/api/verify-email?token=" + url.QueryEscape(oldToken))
+		assert.Equal(t, http.StatusBadRequest, oldResp.Code)
+
+		newResp := api.Get("/api/verify-email?token=" + url.QueryEscape(lastVerificationToken(t, newEmail)))
+		assert.Equal(t, http.StatusOK, newResp.Code)
+	})
+}
+
+// resetLinkPattern extracts the token from a password reset email
+var resetLinkPattern = regexp.MustCompile(`/reset-password\?token=(\S+)`)
+
+// waitForResetToken waits for the background reset email and returns its token
+func waitForResetToken(t *testing.T, email string, previous string) string {
+	var token string
+	assert.Eventually(t, func() bool {
+		msg, ok := testOutbox.Last(email)
+		if !ok {
+			return false
+		}
+		match := resetLinkPattern.FindStringSubmatch(msg.Body)
+		if match == nil {
+			return false
+		}
+		token, _ = url.QueryUnescape(match[1])
+		return token != previous
+	}, 5*time.Second, 10*time.Millisecond)
+	return token
+}
+
+func TestPasswordReset(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	// Register a user through the API and log in
+	email := "reset-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+	session := login(t, api, email, "password123")
+
+	t.Run("Unknown email looks the same", func(t *testing.T) {
+		resp := api.Post("/api/password-reset/request", map[string]interface{}{
+			"email": "nobody-" + uuid.NewString() + "@example.com",
+		})
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+	})
+
+	t.Run("Invalid token", func(t *testing.T) {
+		resp := api.Post("/api/password-reset/confirm", map[string]interface{}{
+			"token":    "not-a-token",
+			"password": "newpassword123",
+		})
+		assert.Equal(t, http.StatusBadRequest, resp.Code)
+	})
+
+	t.Run("Expired token", func(t *testing.T) {
+		resp := api.Post("/api/password-reset/request", map[string]interface{}{"email": email})
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		token := waitForResetToken(t, email, "")
+
+		err := db.Model(&models.PasswordResetToken{}).
+			Where("user_id = (SELECT id FROM users WHERE email = ?)", email).
+			Update("expires_at", time.Now().Add(-time.Minute)).Error
+		assert.NoError(t, err)
+
+		confirmResp := api.Post("/api/password-reset/confirm", map[string]interface{}{
+			"token":    token,
+			"password": "newpassword123",
+		})
+		assert.Equal(t, http.StatusBadRequest, confirmResp.Code)
+	})
+
+	t.Run("Reset the password", func(t *testing.T) {
+		previous, _ := testOutbox.Last(email)
+		resp := api.Post("/api/password-reset/request", map[string]interface{}{"email": email})
+		assert.Equal(t, http.StatusAccepted, resp.Code)
+		token := waitForResetToken(t, email, resetLinkPattern.FindStringSubmatch(previous.Body)[1])
+
+		var stored models.PasswordResetToken
+		err := db.Where("token_hash = ?", token).First(&stored).Error
+		assert.Error(t, err, "the token must only be stored hashed")
+
+		confirmResp := api.Post("/api/password-reset/confirm", map[string]interface{}{
+			"token":    token,
+			"password": "newpassword123",
+		})
+		assert.Equal(t, http.StatusNoContent, confirmResp.Code)
+
+		// The token is single-use
+		againResp := api.Post("/api/password-reset/confirm", map[string]interface{}{
+			"token":    token,
+			"password": "anotherpassword123",
+		})
+		assert.Equal(t, http.StatusBadRequest, againResp.Code)
+
+		// Existing sessions are gone, the new password works
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+session.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+		login(t, api, email, "newpassword123")
+	})
+}
+
+// testTotpCode computes the code an authenticator app would show at the given time
+func testTotpCode(t *testing.T, secret string, at time.Time) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("Failed to decode TOTP secret: %v", err)
+	}
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(at.Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+func TestTwoFactor(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	email := "2fa-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+	auth := "Authorization: Bearer " + login(t, api, email, "password123").AccessToken
+
+	// Enroll
+	enrollResp := api.Post("/api/2fa/enroll", auth)
+	assert.Equal(t, http.StatusOK, enrollResp.Code)
+	var enrollment struct {
+		Secret     string `json:"secret"`
+		OtpauthURI string `json:"otpauthUri"`
+	}
+	err = json.Unmarshal(enrollResp.Body.Bytes(), &enrollment)
+	assert.NoError(t, err)
+	assert.Contains(t, enrollment.OtpauthURI, "otpauth://totp/")
+
+	wrongResp := api.Post("/api/2fa/confirm", auth, map[string]interface{}{
+		"code": "000000",
+	})
+	if testTotpCode(t, enrollment.Secret, time.Now()) != "000000" {
+		assert.Equal(t, http.StatusUnprocessableEntity, wrongResp.Code)
+	}
+
+	confirmResp := api.Post("/api/2fa/confirm", auth, map[string]interface{}{
+		"code": testTotpCode(t, enrollment.Secret, time.Now()),
+	})
+	assert.Equal(t, http.StatusOK, confirmResp.Code)
+	var confirmation struct {
+		RecoveryCodes []string `json:"recoveryCodes"`
+	}
+	err = json.Unmarshal(confirmResp.Body.Bytes(), &confirmation)
+	assert.NoError(t, err)
+	assert.Len(t, confirmation.RecoveryCodes, 10)
+
+	// The password alone no longer starts a session
+	startLogin := func() string {
+		resp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var body struct {
+			AccessToken string `json:"accessToken"`
+			MfaRequired bool   `json:"mfaRequired"`
+			MfaToken    string `json:"mfaToken"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &body)
+		assert.NoError(t, err)
+		assert.True(t, body.MfaRequired)
+		assert.Empty(t, body.AccessToken)
+		return body.MfaToken
+	}
+
+	t.Run("Login with TOTP", func(t *testing.T) {
+		mfaToken := startLogin()
+
+		// The MFA token is not an access token
+		listResp := api.Get("/api/agenda-sources", "Authorization: Bearer "+mfaToken)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+
+		// The confirmation code was already used, take the next one
+		code := testTotpCode(t, enrollment.Secret, time.Now().Add(30*time.Second))
+		resp := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": mfaToken,
+			"code":     code,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens tokenResponse
+		err := json.Unmarshal(resp.Body.Bytes(), &tokens)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, tokens.AccessToken)
+
+		// A code can't be replayed
+		replay := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": startLogin(),
+			"code":     code,
+		})
+		assert.Equal(t, http.StatusUnauthorized, replay.Code)
+	})
+
+	t.Run("Recovery codes are single use", func(t *testing.T) {
+		code := strings.ToUpper(confirmation.RecoveryCodes[0])
+		resp := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": startLogin(),
+			"code":     code,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		reuse := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": startLogin(),
+			"code":     code,
+		})
+		assert.Equal(t, http.StatusUnauthorized, reuse.Code)
+	})
+
+	t.Run("Invalid MFA token", func(t *testing.T) {
+		resp := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": "not-a-token",
+			"code":     confirmation.RecoveryCodes[1],
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+
+	t.Run("MFA tokens are single use", func(t *testing.T) {
+		mfaToken := startLogin()
+		resp := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": mfaToken,
+			"code":     confirmation.RecoveryCodes[3],
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		reuse := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": mfaToken,
+			"code":     confirmation.RecoveryCodes[4],
+		})
+		assert.Equal(t, http.StatusUnauthorized, reuse.Code)
+		assert.Contains(t, reuse.Body.String(), "start again")
+
+		// Only the latest login can be completed
+		previous := startLogin()
+		startLogin()
+		resp = api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": previous,
+			"code":     confirmation.RecoveryCodes[4],
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), "start again")
+	})
+
+	t.Run("Wrong codes are limited", func(t *testing.T) {
+		mfaToken := startLogin()
+		for range 5 {
+			resp := api.Post("/api/login/2fa", map[string]interface{}{
+				"mfaToken": mfaToken,
+				"code":     "wrong",
+			})
+			assert.Equal(t, http.StatusUnauthorized, resp.Code)
+			assert.Contains(t, resp.Body.String(), "Invalid code")
+		}
+
+		// Not even the right code is accepted with the token anymore
+		resp := api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": mfaToken,
+			"code":     confirmation.RecoveryCodes[4],
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+		assert.Contains(t, resp.Body.String(), "start again")
+
+		resp = api.Post("/api/login/2fa", map[string]interface{}{
+			"mfaToken": startLogin(),
+			"code":     confirmation.RecoveryCodes[4],
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("Disabling requires a code", func(t *testing.T) {
+		resp := api.Post("/api/2fa/disable", auth, map[string]interface{}{
+			"code": "wrong",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+		resp = api.Post("/api/2fa/disable", auth, map[string]interface{}{
+			"code": confirmation.RecoveryCodes[2],
+		})
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		tokens := login(t, api, email, "password123")
+		assert.NotEmpty(t, tokens.AccessToken)
+	})
+}
+
+func TestUpdateUser(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	// First, create a user through the API
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    "update-test-" + uuid.NewString() + "@example.com",
+		"password": "password123",
+	})
+
+	// Check that user was created successfully
+	assert.Equal(t, http.StatusOK, createResp.Code)
+
+	// Parse the response to get the user ID
+	var createResponseBody struct {
+		ID        string    `json:"id"`
+		Email     string    `json:"email"`
+		CreatedAt time.Time `json:"createdAt"`
+		UpdatedAt time.Time `json:"updatedAt"`
+	}
+	err = json.Unmarshal(createResp.Body.Bytes(), &createResponseBody)
+	assert.NoError(t, err)
+	userID := createResponseBody.ID
+
+	var user models.User
+	err = db.Where("resource_id = ?", userID).First(&user).Error
+	assert.NoError(t, err)
+	auth := authHeader(t, db, user)
+
+	updatedEmail := "updated-" + uuid.NewString() + "@example.com"
+
+	// Test successful user update
+	t.Run("Successful user update", func(t *testing.T) {
+		// Create a context with authentication
+		ctx := context.Background()
+
+		// Make a request to update a user
+		resp := api.PutCtx(ctx, "/api/users/"+userID, auth, map[string]interface{}{
+			"email":    updatedEmail,
+			"password": "newpassword123",
+		})
+
+		// Check response status code
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// Parse response body
+		var responseBody struct {
+			ID        string    `json:"id"`
+			Email     string    `json:"email"`
+			CreatedAt time.Time `json:"createdAt"`
+			UpdatedAt time.Time `json:"updatedAt"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+
+		// Verify response fields
+		assert.Equal(t, userID, responseBody.ID)
+		assert.Equal(t, updatedEmail, responseBody.Email)
+		assert.False(t, responseBody.CreatedAt.IsZero())
+		assert.False(t, responseBody.UpdatedAt.IsZero())
+	})
+
+	// Test taking another user's email address
+	t.Run("Email taken", func(t *testing.T) {
+		other := createTestUser(t, db)
+		resp := api.Put("/api/users/"+userID, auth, map[string]interface{}{
+			"email": strings.ToUpper(other.Email),
+		})
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"code":"`+controllers.CodeEmailTaken+`"`)
+	})
+
+	// Test updating someone else's account
+	t.Run("Other user forbidden", func(t *testing.T) {
+		// Create a context with authentication
+		ctx := context.Background()
+
+		// Generate a random UUID that doesn't belong to the caller
+		otherID := uuid.New().String()
+
+		// Make a request to update another user
+		resp := api.PutCtx(ctx, "/api/users/"+otherID, auth, map[string]interface{}{
+			"email": "updated@example.com",
+		})
+
+		// Check response status code - should be forbidden
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	// Test missing credentials
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp := api.Put("/api/users/"+userID, map[string]interface{}{
+			"email": "updated@example.com",
+		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestGetUser(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	user := createTestUser(t, db)
+	auth := authHeader(t, db, user)
+
+	t.Run("Get me", func(t *testing.T) {
+		resp := api.Get("/api/me", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var responseBody struct {
+			ID    string `json:"id"`
+			Email string `json:"email"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+		assert.Equal(t, user.ResourceID.String(), responseBody.ID)
+		assert.Equal(t, user.Email, responseBody.Email)
+	})
+
+	t.Run("Get own account", func(t *testing.T) {
+		resp := api.Get("/api/users/"+user.ResourceID.String(), auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("Other user forbidden", func(t *testing.T) {
+		other := createTestUser(t, db)
+		resp := api.Get("/api/users/"+other.ResourceID.String(), auth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		resp := api.Get("/api/me")
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
+	})
+}
+
+func TestProfile(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	user := createTestUser(t, db)
+	auth := authHeader(t, db, user)
+	path := "/api/users/" + user.ResourceID.String() + "/profile"
+
+	type profile struct {
+		DisplayName  string `json:"displayName"`
+		Timezone     string `json:"timezone"`
+		Locale       string `json:"locale"`
+		WeekStart    string `json:"weekStart"`
+		WorkingHours []struct {
+			Day   string `json:"day"`
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"workingHours"`
+	}
+
+	t.Run("Defaults", func(t *testing.T) {
+		resp := api.Get(path, auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var body profile
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "UTC", body.Timezone)
+		assert.Equal(t, "en", body.Locale)
+		assert.Equal(t, "monday", body.WeekStart)
+		if assert.Len(t, body.WorkingHours, 5) {
+			assert.Equal(t, "09:00", body.WorkingHours[0].Start)
+			assert.Equal(t, "17:00", body.WorkingHours[0].End)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		resp := api.Put(path, auth, map[string]any{
+			"displayName": "  Ada Lovelace ",
+			"timezone":    "America/New_York",
+			"locale":      "nl-nl",
+			"weekStart":   "sunday",
+			"workingHours": []map[string]string{
+				{"day": "wednesday", "start": "13:00", "end": "18:00"},
+				{"day": "monday", "start": "08:30", "end": "12:00"},
+			},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var body profile
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "Ada Lovelace", body.DisplayName)
+		assert.Equal(t, "nl-NL", body.Locale)
+		if assert.Len(t, body.WorkingHours, 2) {
+			assert.Equal(t, "monday", body.WorkingHours[0].Day)
+		}
+
+		// Omitted fields are kept
+		resp = api.Put(path, auth, map[string]any{"locale": "en-GB"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		resp = api.Get(path, auth)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+		assert.Equal(t, "America/New_York", body.Timezone)
+		assert.Equal(t, "en-GB", body.Locale)
+		assert.Equal(t, "sunday", body.WeekStart)
+		assert.Len(t, body.WorkingHours, 2)
+	})
+
+	t.Run("Invalid preferences", func(t *testing.T) {
+		for name, body := range map[string]map[string]any{
+			"Unknown timezone": {"timezone": "Europe/Atlantis"},
+			"Invalid locale":   {"locale": "not a locale"},
+			"Unknown weekday":  {"weekStart": "funday"},
+			"Overlapping hours": {"workingHours": []map[string]string{
+				{"day": "monday", "start": "09:00", "end": "12:00"},
+				{"day": "monday", "start": "11:00", "end": "17:00"},
+			}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := api.Put(path, auth, body)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+			})
+		}
+	})
+
+	t.Run("Other user forbidden", func(t *testing.T) {
+		other := createTestUser(t, db)
+		resp := api.Get("/api/users/"+other.ResourceID.String()+"/profile", auth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Invite defaults", func(t *testing.T) {
+		now := time.Now()
+		assert.NoError(t, db.Model(&user).Update("email_verified_at", now).Error)
+
+		resp := api.Post("/api/agenda-invites", auth, map[string]any{
+			"Description": "Coffee", "ExpiresAt": now, "NotBefore": now, "NotAfter": now,
+			"PaddingBefore": "0s", "PaddingAfter": "0s", "SlotSizes": []string{"30m"},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var invite struct {
+			ResourceID   string `json:"ResourceID"`
+			Timezone     string `json:"Timezone"`
+			WorkingHours []any  `json:"WorkingHours"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &invite))
+		assert.Equal(t, "America/New_York", invite.Timezone)
+		assert.Len(t, invite.WorkingHours, 2)
+
+		// The invite follows the profile until it sets its own preferences
+		var stored models.AgendaInvite
+		assert.NoError(t, db.Where("resource_id = ?", invite.ResourceID).First(&stored).Error)
+		assert.Empty(t, stored.Timezone)
+		assert.Nil(t, stored.WorkingHours)
+
+		resp = api.Put("/api/agenda-invites/"+invite.ResourceID, auth, map[string]any{
+			"Timezone": "Asia/Tokyo", "WorkingHours": []map[string]string{{"day": "friday", "start": "10:00", "end": "12:00"}},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &invite))
+		assert.Equal(t, "Asia/Tokyo", invite.Timezone)
+		assert.Len(t, invite.WorkingHours, 1)
+		assert.NoError(t, db.Where("resource_id = ?", invite.ResourceID).First(&stored).Error)
+		assert.Equal(t, "Asia/Tokyo", stored.Timezone)
+		assert.Len(t, stored.WorkingHours, 1)
+
+		// Updates are validated like new invites
+		for name, body := range map[string]map[string]any{
+			"Unknown timezone": {"Timezone": "Europe/Atlantis"},
+			"Overlapping hours": {"WorkingHours": []map[string]string{
+				{"day": "monday", "start": "09:00", "end": "12:00"},
+				{"day": "monday", "start": "11:00", "end": "17:00"},
+			}},
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := api.Put("/api/agenda-invites/"+invite.ResourceID, auth, body)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+				resp = api.Post("/api/agenda-invites", auth, body)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+			})
+		}
+		assert.NoError(t, db.Where("resource_id = ?", invite.ResourceID).First(&stored).Error)
+		assert.Equal(t, "Asia/Tokyo", stored.Timezone)
+	})
+
+	t.Run("Public view in the owner's timezone", func(t *testing.T) {
+		source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/cal.ics", Type: "proton", UserID: user.ID}
+		assert.NoError(t, db.Create(&source).Error)
+		start := time.Now().Add(time.Hour).Truncate(time.Second)
+		assert.NoError(t, db.Create(&models.AgendaItem{
+			ResourceID: uuid.New(), StartTime: start, EndTime: start.Add(time.Hour),
+			Description: "Busy", AgendaSourceID: source.ID, UserID: user.ID,
+		}).Error)
+		invite := models.AgendaInvite{
+			ResourceID: uuid.New(), UserID: user.ID, SlotSizes: models.Durations{},
+			AgendaSources: []models.AgendaSource{source},
+		}
+		assert.NoError(t, db.Omit("AgendaSources.*").Create(&invite).Error)
+
+		resp := api.Get("/api/view-agenda-invite/" + invite.ResourceID.String())
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var items []struct {
+			StartTime time.Time `json:"StartTime"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &items))
+		if assert.Len(t, items, 1) {
+			ny, _ := time.LoadLocation("America/New_York")
+			_, offset := start.In(ny).Zone()
+			_, itemOffset := items[0].StartTime.Zone()
+			assert.Equal(t, offset, itemOffset)
+			assert.True(t, start.Equal(items[0].StartTime))
+		}
+
+		// The view shows at most six weeks at once
+		from := time.Now().UTC().Truncate(time.Second)
+		query := "?DateFrom=" + url.QueryEscape(from.Format(time.RFC3339)) + "&DateTo="
+		resp = api.Get("/api/view-agenda-invite/" + invite.ResourceID.String() + query + url.QueryEscape(from.AddDate(0, 0, 41).Format(time.RFC3339)))
+		assert.Equal(t, http.StatusOK, resp.Code)
+		resp = api.Get("/api/view-agenda-invite/" + invite.ResourceID.String() + query + url.QueryEscape(from.AddDate(0, 0, 43).Format(time.RFC3339)))
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Contains(t, resp.Body.String(), "query.DateTo")
+	})
+
+	t.Run("Public view of whole days across a DST change", func(t *testing.T) {
+		ny, _ := time.LoadLocation("America/New_York")
+		source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/cal.ics", Type: "proton", UserID: user.ID}
+		assert.NoError(t, db.Create(&source).Error)
+		// DST ends on November 3, so the week from November 1 is an hour longer
+		holiday := time.Date(2024, 11, 4, 0, 0, 0, 0, ny)
+		late := time.Date(2024, 11, 7, 23, 30, 0, 0, ny)
+		assert.NoError(t, db.Create(&[]models.AgendaItem{
+			{ResourceID: uuid.New(), StartTime: holiday, EndTime: holiday.AddDate(0, 0, 1), AllDay: true, Description: "Holiday", AgendaSourceID: source.ID, UserID: user.ID},
+			{ResourceID: uuid.New(), StartTime: late, EndTime: late.Add(20 * time.Minute), Timezone: "America/New_York", Description: "Late call", AgendaSourceID: source.ID, UserID: user.ID},
+		}).Error)
+		invite := models.AgendaInvite{
+			ResourceID: uuid.New(), UserID: user.ID, SlotSizes: models.Durations{},
+			AgendaSources: []models.AgendaSource{source},
+		}
+		assert.NoError(t, db.Omit("AgendaSources.*").Create(&invite).Error)
+
+		resp := api.Get("/api/view-agenda-invite/" + invite.ResourceID.String() + "?DateFrom=2024-11-01T00:00:00-04:00")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var items []struct {
+			StartTime   time.Time `json:"StartTime"`
+			AllDay      bool      `json:"AllDay"`
+			Description string    `json:"Description"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &items))
+		if assert.Len(t, items, 2) {
+			assert.True(t, items[0].AllDay)
+			assert.True(t, holiday.Equal(items[0].StartTime))
+			assert.False(t, items[1].AllDay)
+			assert.Equal(t, "Late call", items[1].Description)
+		}
+	})
+
+	t.Run("Public view honors the visibility", func(t *testing.T) {
+		day := time.Date(2024, 12, 2, 9, 0, 0, 0, time.UTC)
+		var sources []models.AgendaSource
+		for i, visibility := range []string{models.VisibilityBusy, models.VisibilityTitle, models.VisibilityFull} {
+			source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/cal.ics", Type: "proton", UserID: user.ID, Visibility: visibility}
+			assert.NoError(t, db.Create(&source).Error)
+			start := day.Add(time.Duration(i) * time.Hour)
+			assert.NoError(t, db.Create(&models.AgendaItem{
+				ResourceID: uuid.New(), StartTime: start, EndTime: start.Add(time.Hour),
+				Description: "Meeting " + visibility, Location: "Room 1", Notes: "Private notes",
+				AgendaSourceID: source.ID, UserID: user.ID,
+			}).Error)
+			sources = append(sources, source)
+		}
+		type itemView struct {
+			Visibility  string `json:"Visibility"`
+			Description string `json:"Description"`
+			Location    string `json:"Location"`
+			Notes       string `json:"Notes"`
+		}
+		view := func(invite *models.AgendaInvite) []itemView {
+			invite.ResourceID, invite.UserID, invite.SlotSizes, invite.AgendaSources = uuid.New(), user.ID, models.Durations{}, sources
+			assert.NoError(t, db.Omit("AgendaSources.*").Create(invite).Error)
+			resp := api.Get("/api/view-agenda-invite/" + invite.ResourceID.String() + "?DateFrom=2024-12-02T00:00:00Z")
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.NotContains(t, resp.Body.String(), "Meeting busy")
+			var items []itemView
+			assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &items))
+			return items
+		}
+
+		// Each source shows its items as far as its own visibility allows
+		items := view(&models.AgendaInvite{})
+		assert.Equal(t, []itemView{
+			{Visibility: "busy"},
+			{Visibility: "title", Description: "Meeting title"},
+			{Visibility: "full", Description: "Meeting full", Location: "Room 1", Notes: "Private notes"},
+		}, items)
+
+		// The invite's visibility overrides the sources'
+		items = view(&models.AgendaInvite{Visibility: models.VisibilityBusy})
+		assert.Equal(t, []itemView{{Visibility: "busy"}, {Visibility: "busy"}, {Visibility: "busy"}}, items)
+	})
+}
+
+func TestDeleteUser(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+
+	email := "delete-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+	tokens := login(t, api, email, "password123")
+	auth := "Authorization: Bearer " + tokens.AccessToken
+
+	var user models.User
+	err = db.Where("email = ?", email).First(&user).Error
+	assert.NoError(t, err)
+
+	// Give the user some data that has to go with the account
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/calendar", Type: "proton", UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+	item := models.AgendaItem{ResourceID: uuid.New(), StartTime: time.Now(), EndTime: time.Now().Add(time.Hour), AgendaSourceID: source.ID, UserID: user.ID}
+	assert.NoError(t, db.Create(&item).Error)
+	invite := models.AgendaInvite{ResourceID: uuid.New(), UserID: user.ID, AgendaSources: []models.AgendaSource{source}}
+	assert.NoError(t, db.Create(&invite).Error)
+
+	t.Run("Other user forbidden", func(t *testing.T) {
+		otherAuth := authHeader(t, db, createTestUser(t, db))
+		resp := api.Delete("/api/users/"+user.ResourceID.String(), otherAuth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Delete own account", func(t *testing.T) {
+		resp := api.Delete("/api/users/"+user.ResourceID.String(), auth)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		// Owned data is gone, not just soft deleted
+		var count int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Unscoped().Model(&models.AgendaSource{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Unscoped().Model(&models.AgendaItem{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Unscoped().Model(&models.AgendaInvite{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+		db.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+
+		// Credentials no longer work
+		meResp := api.Get("/api/me", auth)
+		assert.Equal(t, http.StatusUnauthorized, meResp.Code)
+		refreshResp := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": tokens.RefreshToken,
+		})
+		assert.Equal(t, http.StatusUnauthorized, refreshResp.Code)
+	})
+}
+
+func TestExportImportUser(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	owner := createTestUser(t, db)
+	auth := authHeader(t, db, owner)
+
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://calendar.example.com/share/secret-token", Type: "proton", UserID: owner.ID, Visibility: models.VisibilityBusy, SyncInterval: time.Hour}
+	assert.NoError(t, db.Create(&source).Error)
+	item := models.AgendaItem{ResourceID: uuid.New(), StartTime: time.Now(), EndTime: time.Now().Add(time.Hour), Description: "Standup", Location: "Room 1", AgendaSourceID: source.ID, UserID: owner.ID}
+	assert.NoError(t, db.Create(&item).Error)
+	agenda := models.ProceduralAgenda{ResourceID: uuid.New(), Descriptor: "weekdays 9-17", Description: "Office hours"}
+	assert.NoError(t, db.Create(&agenda).Error)
+	invite := models.AgendaInvite{
+		ResourceID:        uuid.New(),
+		UserID:            owner.ID,
+		Description:       "Meet me",
+		Visibility:        models.VisibilityTitle,
+		PaddingBefore:     15 * time.Minute,
+		SlotSizes:         models.Durations{30 * time.Minute},
+		AgendaSources:     []models.AgendaSource{source},
+		ProceduralAgendas: []models.ProceduralAgenda{agenda},
+	}
+	assert.NoError(t, db.Create(&invite).Error)
+
+	var archive, redacted map[string]interface{}
+	t.Run("Export redacts secrets", func(t *testing.T) {
+		resp := api.Get("/api/users/"+owner.ResourceID.String()+"/export", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &redacted))
+		assert.Contains(t, resp.Header().Get("Content-Disposition"), "attachment")
+		assert.NotContains(t, resp.Body.String(), "secret-token")
+
+		var body controllers.AccountArchive
+		err := json.Unmarshal(resp.Body.Bytes(), &body)
+		assert.NoError(t, err)
+		assert.Equal(t, owner.Email, body.Profile.Email)
+		if assert.Len(t, body.AgendaSources, 1) {
+			assert.Equal(t, "https://calendar.example.com/", body.AgendaSources[0].URL)
+			assert.True(t, body.AgendaSources[0].URLRedacted)
+			assert.Equal(t, models.VisibilityBusy, body.AgendaSources[0].Visibility)
+			assert.Equal(t, 60, body.AgendaSources[0].SyncIntervalMinutes)
+		}
+		if assert.Len(t, body.AgendaItems, 1) {
+			assert.Equal(t, "Room 1", body.AgendaItems[0].Location)
+		}
+		assert.Len(t, body.ProceduralAgendas, 1)
+		if assert.Len(t, body.AgendaInvites, 1) {
+			assert.Equal(t, "15m0s", body.AgendaInvites[0].PaddingBefore)
+			assert.Equal(t, models.VisibilityTitle, body.AgendaInvites[0].Visibility)
+			assert.Equal(t, []string{source.ResourceID.String()}, body.AgendaInvites[0].AgendaSourceIDs)
+		}
+	})
+
+	t.Run("Export with secrets", func(t *testing.T) {
+		resp := api.Get("/api/users/"+owner.ResourceID.String()+"/export?includeSecrets=true", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), "secret-token")
+		err := json.Unmarshal(resp.Body.Bytes(), &archive)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Other user forbidden", func(t *testing.T) {
+		otherAuth := authHeader(t, db, createTestUser(t, db))
+		resp := api.Get("/api/users/"+owner.ResourceID.String()+"/export", otherAuth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Import into a fresh account", func(t *testing.T) {
+		target := createTestUser(t, db)
+		targetAuth := authHeader(t, db, target)
+
+		resp := api.Post("/api/users/"+target.ResourceID.String()+"/import", targetAuth, archive)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var counts struct {
+			AgendaSources     int `json:"agendaSources"`
+			AgendaItems       int `json:"agendaItems"`
+			AgendaInvites     int `json:"agendaInvites"`
+			ProceduralAgendas int `json:"proceduralAgendas"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &counts)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, counts.AgendaSources)
+		assert.Equal(t, 1, counts.AgendaItems)
+		assert.Equal(t, 1, counts.AgendaInvites)
+		assert.Equal(t, 1, counts.ProceduralAgendas)
+
+		// Records are copies with new IDs, related to each other
+		var imported models.AgendaInvite
+		err = db.Preload("AgendaSources").Preload("ProceduralAgendas").Where("user_id = ?", target.ID).First(&imported).Error
+		assert.NoError(t, err)
+		assert.NotEqual(t, invite.ResourceID, imported.ResourceID)
+		assert.Equal(t, 15*time.Minute, imported.PaddingBefore)
+		assert.Equal(t, models.VisibilityTitle, imported.Visibility)
+		if assert.Len(t, imported.AgendaSources, 1) {
+			assert.NotEqual(t, source.ResourceID, imported.AgendaSources[0].ResourceID)
+			assert.Equal(t, source.Url, imported.AgendaSources[0].Url)
+			assert.Equal(t, models.VisibilityBusy, imported.AgendaSources[0].Visibility)
+			assert.Equal(t, time.Hour, imported.AgendaSources[0].SyncInterval)
+
+			var importedItem models.AgendaItem
+			err = db.Where("user_id = ?", target.ID).First(&importedItem).Error
+			assert.NoError(t, err)
+			assert.Equal(t, imported.AgendaSources[0].ID, importedItem.AgendaSourceID)
+			assert.NotEqual(t, item.ResourceID, importedItem.ResourceID)
+			assert.Equal(t, "Room 1", importedItem.Location)
+		}
+		if assert.Len(t, imported.ProceduralAgendas, 1) {
+			assert.NotEqual(t, agenda.ResourceID, imported.ProceduralAgendas[0].ResourceID)
+		}
+
+		// Importing twice would duplicate everything
+		again := api.Post("/api/users/"+target.ResourceID.String()+"/import", targetAuth, archive)
+		assert.Equal(t, http.StatusConflict, again.Code)
+	})
+
+	t.Run("Import of a redacted archive", func(t *testing.T) {
+		target := createTestUser(t, db)
+		targetAuth := authHeader(t, db, target)
+		resp := api.Post("/api/users/"+target.ResourceID.String()+"/import", targetAuth, redacted)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// The source waits for its real URL instead of syncing the placeholder
+		var imported models.AgendaSource
+		assert.NoError(t, db.Where("user_id = ?", target.ID).First(&imported).Error)
+		assert.True(t, imported.URLRedacted)
+		path := "/api/agenda-sources/" + imported.ResourceID.String()
+		resp = api.Get(path, targetAuth)
+		assert.Contains(t, resp.Body.String(), `"urlRedacted":true`)
+		resp = api.Post(path+"/sync", targetAuth, map[string]interface{}{})
+		assert.Equal(t, http.StatusConflict, resp.Code)
+		assert.Contains(t, resp.Body.String(), controllers.CodeURLRedacted)
+
+		// Sending the placeholder back doesn't count as setting the URL
+		resp = api.Put(path, targetAuth, map[string]interface{}{"url": "https://calendar.example.com/"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"urlRedacted":true`)
+
+		resp = api.Put(path, targetAuth, map[string]interface{}{"url": "https://calendar.example.com/share/new-token"})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "urlRedacted")
+		assert.NoError(t, db.First(&imported, imported.ID).Error)
+		assert.False(t, imported.URLRedacted)
+	})
+
+	t.Run("Credentials only with secrets", func(t *testing.T) {
+		caldavOwner := createTestUser(t, db)
+		caldavAuth := authHeader(t, db, caldavOwner)
+		caldav := models.AgendaSource{ResourceID: uuid.New(), Url: "https://cloud.example.com/remote.php/dav", Type: "caldav", UserID: caldavOwner.ID, Username: "alice", Password: "app-password"}
+		assert.NoError(t, db.Create(&caldav).Error)
+
+		resp := api.Get("/api/users/"+caldavOwner.ResourceID.String()+"/export", caldavAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "alice")
+		assert.NotContains(t, resp.Body.String(), "app-password")
+
+		resp = api.Get("/api/users/"+caldavOwner.ResourceID.String()+"/export?includeSecrets=true", caldavAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var full map[string]interface{}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &full))
+		sources := full["agendaSources"].([]interface{})
+		if assert.Len(t, sources, 1) {
+			assert.Equal(t, "alice", sources[0].(map[string]interface{})["username"])
+			assert.Equal(t, "app-password", sources[0].(map[string]interface{})["password"])
+		}
+
+		target := createTestUser(t, db)
+		resp = api.Post("/api/users/"+target.ResourceID.String()+"/import", authHeader(t, db, target), full)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var imported models.AgendaSource
+		assert.NoError(t, db.Where("user_id = ?", target.ID).First(&imported).Error)
+		assert.Equal(t, "alice", imported.Username)
+		assert.Equal(t, "app-password", imported.Password)
+	})
+
+	t.Run("Import with dangling relations", func(t *testing.T) {
+		target := createTestUser(t, db)
+		resp := api.Post("/api/users/"+target.ResourceID.String()+"/import", authHeader(t, db, target), map[string]interface{}{
+			"version":       controllers.ArchiveVersion,
+			"exportedAt":    time.Now(),
+			"profile":       map[string]interface{}{"email": target.Email, "emailVerified": false, "createdAt": time.Now()},
+			"agendaSources": []interface{}{},
+			"agendaItems": []interface{}{map[string]interface{}{
+				"id":             uuid.NewString(),
+				"agendaSourceId": uuid.NewString(),
+				"startTime":      time.Now(),
+				"endTime":        time.Now(),
+				"description":    "Orphan",
+			}},
+			"agendaInvites":     []interface{}{},
+			"proceduralAgendas": []interface{}{},
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+		var count int64
+		db.Model(&models.AgendaItem{}).Where("user_id = ?", target.ID).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Import with invalid sources", func(t *testing.T) {
+		target := createTestUser(t, db)
+		targetAuth := authHeader(t, db, target)
+		importSource := func(entry map[string]interface{}) *httptest.ResponseRecorder {
+			entry["createdAt"] = time.Now()
+			return api.Post("/api/users/"+target.ResourceID.String()+"/import", targetAuth, map[string]interface{}{
+				"version":           controllers.ArchiveVersion,
+				"exportedAt":        time.Now(),
+				"profile":           map[string]interface{}{"email": target.Email, "emailVerified": false, "createdAt": time.Now()},
+				"agendaSources":     []interface{}{entry},
+				"agendaItems":       []interface{}{},
+				"agendaInvites":     []interface{}{},
+				"proceduralAgendas": []interface{}{},
+			})
+		}
+
+		id := uuid.NewString()
+		resp := importSource(map[string]interface{}{"id": id, "url": "http://169.254.169.254/latest/meta-data", "type": "ics"})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Contains(t, resp.Body.String(), "Agenda source "+id)
+		assert.Contains(t, resp.Body.String(), "body.agendaSources[0].url")
+
+		// Redacted URLs are checked too when they name a host
+		resp = importSource(map[string]interface{}{"id": id, "url": "http://127.0.0.2/", "urlRedacted": true, "type": "ics"})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+		resp = importSource(map[string]interface{}{"id": id, "url": "https://example.com/calendar.ics", "type": "unknown"})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Contains(t, resp.Body.String(), "body.agendaSources[0].type")
+
+		resp = importSource(map[string]interface{}{"id": id, "url": "https://example.com/calendar.ics", "type": "ics", "syncIntervalMinutes": 1})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Contains(t, resp.Body.String(), "body.agendaSources[0].syncIntervalMinutes")
+
+		var count int64
+		db.Model(&models.AgendaSource{}).Where("user_id = ?", target.ID).Count(&count)
+		assert.Zero(t, count)
+	})
+	t.Run("Import with invalid invites", func(t *testing.T) {
+		target := createTestUser(t, db)
+		targetAuth := authHeader(t, db, target)
+		importInvite := func(entry map[string]interface{}) *httptest.ResponseRecorder {
+			invite := map[string]interface{}{
+				"id":                  uuid.NewString(),
+				"description":         "Meet me",
+				"expiresAt":           time.Time{},
+				"notBefore":           time.Time{},
+				"notAfter":            time.Time{},
+				"paddingBefore":       "0s",
+				"paddingAfter":        "0s",
+				"slotSizes":           []string{"30m"},
+				"agendaSourceIds":     []string{},
+				"proceduralAgendaIds": []string{},
+			}
+			for key, value := range entry {
+				invite[key] = value
+			}
+			return api.Post("/api/users/"+target.ResourceID.String()+"/import", targetAuth, map[string]interface{}{
+				"version":           controllers.ArchiveVersion,
+				"exportedAt":        time.Now(),
+				"profile":           map[string]interface{}{"email": target.Email, "emailVerified": false, "createdAt": time.Now()},
+				"agendaSources":     []interface{}{},
+				"agendaItems":       []interface{}{},
+				"agendaInvites":     []interface{}{invite},
+				"proceduralAgendas": []interface{}{},
+			})
+		}
+
+		resp := importInvite(map[string]interface{}{})
+		assert.Equal(t, http.StatusOK, resp.Code, resp.Body.String())
+
+		// The same checks as for new invites
+		for name, entry := range map[string]map[string]interface{}{
+			"paddingBefore": {"paddingBefore": "-15m"},
+			"paddingAfter":  {"paddingAfter": "1y"},
+			"slotSizes[0]":  {"slotSizes": []string{"0s"}},
+			"notAfter":      {"notBefore": time.Now(), "notAfter": time.Now().Add(-time.Hour)},
+			"timezone":      {"timezone": "Mars/Olympus"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := importInvite(entry)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+				assert.Contains(t, resp.Body.String(), "body.agendaInvites[0]."+name)
+			})
+		}
+
+		var count int64
+		db.Model(&models.AgendaInvite{}).Where("user_id = ?", target.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+}
+
+func TestAdmin(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	admin := createTestAdmin(t, db)
+	adminAuth := authHeader(t, db, admin)
+
+	email := "managed-" + uuid.NewString() + "@example.com"
+	createResp := api.Post("/api/register", map[string]interface{}{
+		"email":    email,
+		"password": "password123",
+	})
+	assert.Equal(t, http.StatusOK, createResp.Code)
+	var user models.User
+	err = db.Where("email = ?", email).First(&user).Error
+	assert.NoError(t, err)
+	assert.Equal(t, models.RoleUser, user.Role)
+	userAuth := authHeader(t, db, user)
+
+	t.Run("Users can't administrate", func(t *testing.T) {
+		resp := api.Get("/api/admin/users", userAuth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+
+		resp = api.Post("/api/admin/users/"+admin.ResourceID.String()+"/disable", userAuth)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Admins can access any account", func(t *testing.T) {
+		resp := api.Get("/api/users/"+user.ResourceID.String(), adminAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+	})
+
+	t.Run("Search users", func(t *testing.T) {
+		resp := api.Get("/api/admin/users?q="+url.QueryEscape(strings.ToUpper(email[:20]))+"&role=user", adminAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var responseBody struct {
+			Data []struct {
+				ID    string `json:"id"`
+				Email string `json:"email"`
+				Role  string `json:"role"`
+			} `json:"data"`
+			Pagination struct {
+				TotalItems int `json:"totalItems"`
+			} `json:"pagination"`
+		}
+		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, responseBody.Pagination.TotalItems)
+		if assert.Len(t, responseBody.Data, 1) {
+			assert.Equal(t, email, responseBody.Data[0].Email)
+			assert.Equal(t, "user", responseBody.Data[0].Role)
+		}
+
+		// Wildcards are matched literally
+		resp = api.Get("/api/admin/users?q="+url.QueryEscape("%"), adminAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		err = json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		assert.NoError(t, err)
+		assert.Zero(t, responseBody.Pagination.TotalItems)
+	})
+
+	t.Run("Disable and re-enable", func(t *testing.T) {
+		invite := models.AgendaInvite{ResourceID: uuid.New(), UserID: user.ID}
+		assert.NoError(t, db.Create(&invite).Error)
+		tokens := login(t, api, email, "password123")
+
+		resp := api.Post("/api/admin/users/"+user.ResourceID.String()+"/disable", adminAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), "disabledAt")
+
+		// Every way of authenticating fails
+		meResp := api.Get("/api/me", userAuth)
+		assert.Equal(t, http.StatusUnauthorized, meResp.Code)
+		loginResp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusUnauthorized, loginResp.Code)
+		refreshResp := api.Post("/api/token/refresh", map[string]interface{}{
+			"refreshToken": tokens.RefreshToken,
+		})
+		assert.Equal(t, http.StatusUnauthorized, refreshResp.Code)
+
+		// Invites are gone from the public view
+		viewResp := api.Get("/api/view-agenda-invite/" + invite.ResourceID.String())
+		assert.Equal(t, http.StatusGone, viewResp.Code)
+
+		resp = api.Post("/api/admin/users/"+user.ResourceID.String()+"/enable", adminAuth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// Disabling revoked the sessions, new ones work again
+		meResp = api.Get("/api/me", userAuth)
+		assert.Equal(t, http.StatusUnauthorized, meResp.Code)
+		meResp = api.Get("/api/me", authHeader(t, db, user))
+		assert.Equal(t, http.StatusOK, meResp.Code)
+		viewResp = api.Get("/api/view-agenda-invite/" + invite.ResourceID.String())
+		assert.Equal(t, http.StatusOK, viewResp.Code)
+	})
+
+	t.Run("Admins can't lock themselves out", func(t *testing.T) {
+		resp := api.Post("/api/admin/users/"+admin.ResourceID.String()+"/disable", adminAuth)
+		assert.Equal(t, http.StatusConflict, resp.Code)
+
+		resp = api.Put("/api/admin/users/"+admin.ResourceID.String()+"/role", adminAuth, map[string]interface{}{
+			"role": "user",
+		})
+		assert.Equal(t, http.StatusConflict, resp.Code)
+	})
+
+	t.Run("Change role", func(t *testing.T) {
+		resp := api.Put("/api/admin/users/"+user.ResourceID.String()+"/role", adminAuth, map[string]interface{}{
+			"role": "admin",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		listResp := api.Get("/api/admin/users", userAuth)
+		assert.Equal(t, http.StatusOK, listResp.Code)
+	})
+
+	t.Run("Force delete", func(t *testing.T) {
+		resp := api.Delete("/api/admin/users/"+user.ResourceID.String(), adminAuth)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+
+		var count int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Zero(t, count)
+
+		resp = api.Delete("/api/admin/users/"+user.ResourceID.String(), adminAuth)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+// oidcLogin runs a complete OIDC login as the stub's current identity
+func oidcLogin(t *testing.T, api humatest.TestAPI) *httptest.ResponseRecorder {
+	startResp := api.Post("/api/oidc/stub/start")
+	if startResp.Code != http.StatusOK {
+		t.Fatalf("Starting the OIDC login failed with status %d: %s", startResp.Code, startResp.Body.String())
+	}
+	var flow struct {
+		AuthorizationURL string `json:"authorizationUrl"`
+		FlowToken        string `json:"flowToken"`
+	}
+	if err := json.Unmarshal(startResp.Body.Bytes(), &flow); err != nil {
+		t.Fatalf("Failed to decode OIDC start response: %v", err)
+	}
 
-		// Verify response fields
-		assert.NotEmpty(t, responseBody.ID)
-		assert.Equal(t, "test@example.com", responseBody.Email)
-		assert.False(t, responseBody.CreatedAt.IsZero())
-		assert.False(t, responseBody.UpdatedAt.IsZero())
+	code, state, err := testOidcServer.Authorize(flow.AuthorizationURL)
+	if err != nil {
+		t.Fatalf("Stub provider rejected the authorization request: %v", err)
+	}
+	return api.Post("/api/oidc/stub/callback", map[string]interface{}{
+		"code":      code,
+		"state":     state,
+		"flowToken": flow.FlowToken,
 	})
 }
 
-func TestUpdateUser(t *testing.T) {
+func TestOidcLogin(t *testing.T) {
 	// Setup test database
 	db, err := setupTestDB()
 	if err != nil {
@@ -129,71 +2067,108 @@ func TestUpdateUser(t *testing.T) {
 	// Setup API
 	api := setupAPI(t, db)
 
-	// First, create a user through the API
-	createResp := api.Post("/api/register", map[string]interface{}{
-		"email":    "update-test@example.com",
-		"password": "password123",
+	t.Run("List providers", func(t *testing.T) {
+		resp := api.Get("/api/oidc/providers")
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"name":"stub"`)
 	})
 
-	// Check that user was created successfully
-	assert.Equal(t, http.StatusOK, createResp.Code)
+	t.Run("New identity creates a user", func(t *testing.T) {
+		email := "sso-" + uuid.NewString() + "@example.com"
+		testOidcServer.Identity = oidctest.Identity{Subject: uuid.NewString(), Email: email, EmailVerified: true}
 
-	// Parse the response to get the user ID
-	var createResponseBody struct {
-		ID        string    `json:"id"`
-		Email     string    `json:"email"`
-		CreatedAt time.Time `json:"createdAt"`
-		UpdatedAt time.Time `json:"updatedAt"`
-	}
-	err = json.Unmarshal(createResp.Body.Bytes(), &createResponseBody)
-	assert.NoError(t, err)
-	userID := createResponseBody.ID
+		resp := oidcLogin(t, api)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var tokens tokenResponse
+		err := json.Unmarshal(resp.Body.Bytes(), &tokens)
+		assert.NoError(t, err)
 
-	// Test successful user update
-	t.Run("Successful user update", func(t *testing.T) {
-		// Create a context with authentication
-		ctx := context.Background()
+		meResp := api.Get("/api/me", "Authorization: Bearer "+tokens.AccessToken)
+		assert.Equal(t, http.StatusOK, meResp.Code)
+		assert.Contains(t, meResp.Body.String(), email)
+		assert.Contains(t, meResp.Body.String(), `"emailVerified":true`)
 
-		// Make a request to update a user
-		resp := api.PutCtx(ctx, "/api/users/"+userID, map[string]interface{}{
-			"email":    "updated@example.com",
-			"password": "newpassword123",
-		})
+		// Logging in again with a changed email finds the user by subject
+		testOidcServer.Identity.Email = "renamed-" + email
+		resp = oidcLogin(t, api)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		assert.Equal(t, int64(1), count)
+		db.Model(&models.User{}).Where("email = ?", "renamed-"+email).Count(&count)
+		assert.Zero(t, count)
+	})
 
-		// Check response status code
+	t.Run("Verified email links an existing user", func(t *testing.T) {
+		existing := createTestUser(t, db)
+		now := time.Now()
+		assert.NoError(t, db.Model(&existing).Update("email_verified_at", now).Error)
+		testOidcServer.Identity = oidctest.Identity{Subject: uuid.NewString(), Email: strings.ToUpper(existing.Email), EmailVerified: true}
+
+		resp := oidcLogin(t, api)
 		assert.Equal(t, http.StatusOK, resp.Code)
 
-		// Parse response body
-		var responseBody struct {
-			ID        string    `json:"id"`
-			Email     string    `json:"email"`
-			CreatedAt time.Time `json:"createdAt"`
-			UpdatedAt time.Time `json:"updatedAt"`
-		}
-		err := json.Unmarshal(resp.Body.Bytes(), &responseBody)
+		var identity models.OidcIdentity
+		err := db.Where("subject = ?", testOidcServer.Identity.Subject).First(&identity).Error
 		assert.NoError(t, err)
+		assert.Equal(t, existing.ID, identity.UserID)
+	})
 
-		// Verify response fields
-		assert.Equal(t, userID, responseBody.ID)
-		assert.Equal(t, "updated@example.com", responseBody.Email)
-		assert.False(t, responseBody.CreatedAt.IsZero())
-		assert.False(t, responseBody.UpdatedAt.IsZero())
+	t.Run("Unverified local account is taken over", func(t *testing.T) {
+		email := "squatted-" + uuid.NewString() + "@example.com"
+		createResp := api.Post("/api/register", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusOK, createResp.Code)
+		squatter := login(t, api, email, "password123")
+
+		testOidcServer.Identity = oidctest.Identity{Subject: uuid.NewString(), Email: email, EmailVerified: true}
+		resp := oidcLogin(t, api)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		// The password and sessions of whoever registered the address are gone
+		listResp := api.Get("/api/me", "Authorization: Bearer "+squatter.AccessToken)
+		assert.Equal(t, http.StatusUnauthorized, listResp.Code)
+		loginResp := api.Post("/api/login", map[string]interface{}{
+			"email":    email,
+			"password": "password123",
+		})
+		assert.Equal(t, http.StatusUnauthorized, loginResp.Code)
 	})
 
-	// Test user not found
-	t.Run("User not found", func(t *testing.T) {
-		// Create a context with authentication
-		ctx := context.Background()
+	t.Run("Unverified email is refused", func(t *testing.T) {
+		testOidcServer.Identity = oidctest.Identity{Subject: uuid.NewString(), Email: "unverified-" + uuid.NewString() + "@example.com"}
+		resp := oidcLogin(t, api)
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
 
-		// Generate a random UUID that doesn't exist in the database
-		nonExistentID := uuid.New().String()
+	t.Run("Tampered state", func(t *testing.T) {
+		startResp := api.Post("/api/oidc/stub/start")
+		assert.Equal(t, http.StatusOK, startResp.Code)
+		var flow struct {
+			AuthorizationURL string `json:"authorizationUrl"`
+			FlowToken        string `json:"flowToken"`
+		}
+		err := json.Unmarshal(startResp.Body.Bytes(), &flow)
+		assert.NoError(t, err)
+		code, _, err := testOidcServer.Authorize(flow.AuthorizationURL)
+		assert.NoError(t, err)
 
-		// Make a request to update a non-existent user
-		resp := api.PutCtx(ctx, "/api/users/"+nonExistentID, map[string]interface{}{
-			"email": "updated@example.com",
+		resp := api.Post("/api/oidc/stub/callback", map[string]interface{}{
+			"code":      code,
+			"state":     "forged",
+			"flowToken": flow.FlowToken,
 		})
+		assert.Equal(t, http.StatusUnauthorized, resp.Code)
 
-		// Check response status code - should be not found
+		// The flow token is no access token
+		meResp := api.Get("/api/me", "Authorization: Bearer "+flow.FlowToken)
+		assert.Equal(t, http.StatusUnauthorized, meResp.Code)
+	})
+
+	t.Run("Unknown provider", func(t *testing.T) {
+		resp := api.Post("/api/oidc/nope/start")
 		assert.Equal(t, http.StatusNotFound, resp.Code)
 	})
 }
@@ -207,6 +2182,8 @@ func TestAgendaSourceCRUD(t *testing.T) {
 
 	// Setup API
 	api := setupAPI(t, db)
+	owner := createTestUser(t, db)
+	auth := authHeader(t, db, owner)
 
 	// Test creating an agenda source
 	t.Run("Create agenda source", func(t *testing.T) {
@@ -214,7 +2191,7 @@ func TestAgendaSourceCRUD(t *testing.T) {
 		ctx := context.Background()
 
 		// Make a request to create an agenda source
-		resp := api.PostCtx(ctx, "/api/agenda-sources", map[string]interface{}{
+		resp := api.PostCtx(ctx, "/api/agenda-sources", auth, map[string]interface{}{
 			"url":  "https://example.com/calendar",
 			"type": "proton",
 		})
@@ -236,22 +2213,49 @@ func TestAgendaSourceCRUD(t *testing.T) {
 
 		// Verify response fields
 		assert.NotEmpty(t, responseBody.ID)
-		assert.Equal(t, "https://example.com/calendar", responseBody.URL)
+		assert.Equal(t, "https://example.com/", responseBody.URL)
 		assert.Equal(t, "proton", responseBody.Type)
-		assert.NotEmpty(t, responseBody.UserID)
+		assert.Equal(t, owner.ResourceID.String(), responseBody.UserID)
 		assert.False(t, responseBody.CreatedAt.IsZero())
 		assert.False(t, responseBody.UpdatedAt.IsZero())
 
 		// Store the ID for later tests
 		agendaSourceID := responseBody.ID
 
+		// Test that other users can't see or touch the agenda source
+		t.Run("Other user", func(t *testing.T) {
+			otherAuth := authHeader(t, db, createTestUser(t, db))
+
+			getResp := api.Get("/api/agenda-sources/"+agendaSourceID, otherAuth)
+			assert.Equal(t, http.StatusNotFound, getResp.Code)
+
+			putResp := api.Put("/api/agenda-sources/"+agendaSourceID, otherAuth, map[string]interface{}{
+				"url": "https://attacker.example.com/calendar",
+			})
+			assert.Equal(t, http.StatusNotFound, putResp.Code)
+
+			deleteResp := api.Delete("/api/agenda-sources/"+agendaSourceID, otherAuth)
+			assert.Equal(t, http.StatusNotFound, deleteResp.Code)
+
+			listResp := api.Get("/api/agenda-sources", otherAuth)
+			assert.Equal(t, http.StatusOK, listResp.Code)
+			var listBody struct {
+				Data []struct {
+					ID string `json:"id"`
+				} `json:"data"`
+			}
+			err := json.Unmarshal(listResp.Body.Bytes(), &listBody)
+			assert.NoError(t, err)
+			assert.Empty(t, listBody.Data)
+		})
+
 		// Test getting the agenda source
 		t.Run("Get agenda source", func(t *testing.T) {
 			// Create a context with authentication
 			ctx := context.Background()
 
 			// Make a request to get the agenda source
-			resp := api.GetCtx(ctx, "/api/agenda-sources/"+agendaSourceID)
+			resp := api.GetCtx(ctx, "/api/agenda-sources/"+agendaSourceID, auth)
 
 			// Check response status code
 			assert.Equal(t, http.StatusOK, resp.Code)
@@ -270,8 +2274,9 @@ func TestAgendaSourceCRUD(t *testing.T) {
 
 			// Verify response fields
 			assert.Equal(t, agendaSourceID, responseBody.ID)
-			assert.Equal(t, "https://example.com/calendar", responseBody.URL)
+			assert.Equal(t, "https://example.com/", responseBody.URL)
 			assert.Equal(t, "proton", responseBody.Type)
+			assert.Equal(t, owner.ResourceID.String(), responseBody.UserID)
 		})
 
 		// Test updating the agenda source
@@ -280,7 +2285,7 @@ func TestAgendaSourceCRUD(t *testing.T) {
 			ctx := context.Background()
 
 			// Make a request to update the agenda source
-			resp := api.PutCtx(ctx, "/api/agenda-sources/"+agendaSourceID, map[string]interface{}{
+			resp := api.PutCtx(ctx, "/api/agenda-sources/"+agendaSourceID, auth, map[string]interface{}{
 				"url": "https://updated-example.com/calendar",
 			})
 
@@ -301,7 +2306,7 @@ func TestAgendaSourceCRUD(t *testing.T) {
 
 			// Verify response fields
 			assert.Equal(t, agendaSourceID, responseBody.ID)
-			assert.Equal(t, "https://updated-example.com/calendar", responseBody.URL)
+			assert.Equal(t, "https://updated-example.com/", responseBody.URL)
 			assert.Equal(t, "proton", responseBody.Type)
 		})
 
@@ -311,7 +2316,7 @@ func TestAgendaSourceCRUD(t *testing.T) {
 			ctx := context.Background()
 
 			// Make a request to get all agenda sources
-			resp := api.GetCtx(ctx, "/api/agenda-sources")
+			resp := api.GetCtx(ctx, "/api/agenda-sources", auth)
 
 			// Check response status code
 			assert.Equal(t, http.StatusOK, resp.Code)
@@ -337,17 +2342,17 @@ func TestAgendaSourceCRUD(t *testing.T) {
 			assert.NoError(t, err)
 
 			// Verify response fields
-			assert.GreaterOrEqual(t, len(responseBody.Data), 1)
+			assert.Len(t, responseBody.Data, 1)
 			assert.Equal(t, 1, responseBody.Pagination.Page)
 			assert.Equal(t, 20, responseBody.Pagination.PageSize)
-			assert.GreaterOrEqual(t, responseBody.Pagination.TotalItems, 1)
-			assert.GreaterOrEqual(t, responseBody.Pagination.TotalPages, 1)
+			assert.Equal(t, 1, responseBody.Pagination.TotalItems)
+			assert.Equal(t, 1, responseBody.Pagination.TotalPages)
 
 			// Find our agenda source in the list
 			found := false
 			for _, source := range responseBody.Data {
 				if source.ID == agendaSourceID {
-					assert.Equal(t, "https://updated-example.com/calendar", source.URL)
+					assert.Equal(t, "https://updated-example.com/", source.URL)
 					assert.Equal(t, "proton", source.Type)
 					found = true
 					break
@@ -362,13 +2367,13 @@ func TestAgendaSourceCRUD(t *testing.T) {
 			ctx := context.Background()
 
 			// Make a request to delete the agenda source
-			resp := api.DeleteCtx(ctx, "/api/agenda-sources/"+agendaSourceID)
+			resp := api.DeleteCtx(ctx, "/api/agenda-sources/"+agendaSourceID, auth)
 
 			// Check response status code - should be 204 No Content
 			assert.Equal(t, http.StatusNoContent, resp.Code)
 
 			// Try to get the deleted agenda source
-			getResp := api.GetCtx(ctx, "/api/agenda-sources/"+agendaSourceID)
+			getResp := api.GetCtx(ctx, "/api/agenda-sources/"+agendaSourceID, auth)
 
 			// Check response status code - should be 404 Not Found
 			assert.Equal(t, http.StatusNotFound, getResp.Code)
@@ -384,9 +2389,581 @@ func TestAgendaSourceCRUD(t *testing.T) {
 		nonExistentID := uuid.New().String()
 
 		// Make a request to get a non-existent agenda source
-		resp := api.GetCtx(ctx, "/api/agenda-sources/"+nonExistentID)
+		resp := api.GetCtx(ctx, "/api/agenda-sources/"+nonExistentID, auth)
 
 		// Check response status code - should be not found
 		assert.Equal(t, http.StatusNotFound, resp.Code)
 	})
+
+	t.Run("Agenda source types", func(t *testing.T) {
+		resp := api.Get("/api/agenda-source-types", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var types []struct {
+			Type        string   `json:"type"`
+			Remote      bool     `json:"remote"`
+			Schemes     []string `json:"schemes"`
+			Credentials bool     `json:"credentials"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &types))
+		if assert.Len(t, types, 3) {
+			assert.Equal(t, "caldav", types[0].Type)
+			assert.True(t, types[0].Credentials)
+			assert.Equal(t, "ics", types[1].Type)
+			assert.Equal(t, "proton", types[2].Type)
+			assert.True(t, types[2].Remote)
+			assert.False(t, types[2].Credentials)
+			assert.Equal(t, []string{"https", "webcal"}, types[2].Schemes)
+		}
+
+		// The schemas list the same types
+		schema := api.OpenAPI().Components.Schemas.Map()["CreateAgendaSourceInputBody"]
+		assert.Equal(t, []any{"caldav", "ics", "proton"}, schema.Properties["type"].Enum)
+	})
+
+	t.Run("Unsupported agenda source type", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  "https://example.com/calendar",
+			"type": "file",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	})
+
+	t.Run("Invalid agenda source URL", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  "http://example.com/calendar",
+			"type": "proton",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+		assert.Contains(t, resp.Body.String(), "body.url")
+
+		// Other feeds can be downloaded over plain HTTP
+		resp = api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  "http://example.com/calendar",
+			"type": "ics",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+
+		var source struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+
+		// Switching the type checks the URL again
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"type": "proton",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	})
+
+	t.Run("Agenda source credentials", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":      "https://cloud.example.com/remote.php/dav",
+			"type":     "caldav",
+			"username": "alice",
+			"password": "app-password",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"username":"alice"`)
+		assert.NotContains(t, resp.Body.String(), "app-password")
+
+		var source struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+		var stored models.AgendaSource
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, "app-password", stored.Password)
+		var raw struct{ Username, Password string }
+		assert.NoError(t, db.Table("agenda_sources").Select("username", "password").Where("resource_id = ?", source.ID).Scan(&raw).Error)
+		assert.True(t, secrets.Sealed(raw.Username))
+		assert.NotContains(t, raw.Password, "app-password")
+
+		// Credentials stay with their host
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url": "https://CLOUD.example.com/dav",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"username":"alice"`)
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url": "https://other.example.com/dav",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "username")
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Empty(t, stored.Password)
+
+		// Unless new ones come with the new host
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url":      "https://cloud.example.com/remote.php/dav",
+			"username": "alice",
+			"password": "app-password",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"username":"alice"`)
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, "app-password", stored.Password)
+
+		// Calendar links don't log in
+		resp = api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":      "https://calendar.proton.me/api/calendar/v1/url/abc/calendar.ics",
+			"type":     "proton",
+			"username": "alice",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+		// Switching to such a type forgets the credentials
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"type": "ics",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "username")
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Empty(t, stored.Password)
+	})
+
+	t.Run("Agenda source calendars after a URL change", func(t *testing.T) {
+		source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://cloud.example.com/remote.php/dav", Type: "caldav", UserID: owner.ID}
+		assert.NoError(t, db.Create(&source).Error)
+		collection := models.CalendarCollection{AgendaSourceID: source.ID, Href: "/remote.php/dav/calendars/alice/personal/", SyncToken: "token-1"}
+		assert.NoError(t, db.Create(&collection).Error)
+		assert.NoError(t, db.Create(&models.CalendarObject{CalendarCollectionID: collection.ID, Href: collection.Href + "a.ics", ETag: "1"}).Error)
+		countCalendars := func() (collections, objects int64) {
+			db.Unscoped().Model(&models.CalendarCollection{}).Where("agenda_source_id = ?", source.ID).Count(&collections)
+			db.Unscoped().Model(&models.CalendarObject{}).Where("calendar_collection_id = ?", collection.ID).Count(&objects)
+			return collections, objects
+		}
+
+		// Other changes keep the calendars
+		resp := api.Put("/api/agenda-sources/"+source.ResourceID.String(), auth, map[string]interface{}{
+			"syncIntervalMinutes": 30,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		collections, objects := countCalendars()
+		assert.Equal(t, int64(1), collections)
+		assert.Equal(t, int64(1), objects)
+
+		resp = api.Put("/api/agenda-sources/"+source.ResourceID.String(), auth, map[string]interface{}{
+			"url": "https://other.example.com/dav",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		collections, objects = countCalendars()
+		assert.Zero(t, collections)
+		assert.Zero(t, objects)
+	})
+
+	t.Run("Agenda source sync interval", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":                 "https://example.com/hourly.ics",
+			"type":                "ics",
+			"syncIntervalMinutes": 2,
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+
+		resp = api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":                 "https://example.com/hourly.ics",
+			"type":                "ics",
+			"syncIntervalMinutes": 60,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var source struct {
+			ID                  string `json:"id"`
+			SyncIntervalMinutes int    `json:"syncIntervalMinutes"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+		assert.Equal(t, 60, source.SyncIntervalMinutes)
+
+		// Changing the interval makes the source due right away
+		next := time.Now().Add(time.Hour)
+		assert.NoError(t, db.Model(&models.AgendaSource{}).Where("resource_id = ?", source.ID).Update("next_sync_at", next).Error)
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"syncIntervalMinutes": 0,
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"syncIntervalMinutes":0`)
+		var stored models.AgendaSource
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, time.Duration(0), stored.SyncInterval)
+		assert.Nil(t, stored.NextSyncAt)
+	})
+
+	t.Run("Agenda source visibility", func(t *testing.T) {
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  "https://example.com/personal.ics",
+			"type": "ics",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var source struct {
+			ID         string `json:"id"`
+			Visibility string `json:"visibility"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+		assert.Equal(t, models.VisibilityTitle, source.Visibility)
+
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"visibility": "busy",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"visibility":"busy"`)
+		var stored models.AgendaSource
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, models.VisibilityBusy, stored.Visibility)
+
+		// Other updates keep it
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"syncIntervalMinutes": 60,
+		})
+		assert.Contains(t, resp.Body.String(), `"visibility":"busy"`)
+
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"visibility": "everything",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	})
+
+	t.Run("Agenda source URL encryption", func(t *testing.T) {
+		shareLink := "https://calendar.proton.me/api/calendar/v1/url/abc/calendar.ics?CacheKey=def&PassphraseKey=ghi"
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  shareLink,
+			"type": "proton",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "PassphraseKey")
+		var source struct {
+			ID  string `json:"id"`
+			URL string `json:"url"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+		assert.Equal(t, "https://calendar.proton.me/", source.URL)
+
+		// The database only holds the encrypted URL
+		var raw struct{ Url string }
+		assert.NoError(t, db.Table("agenda_sources").Select("url").Where("resource_id = ?", source.ID).Scan(&raw).Error)
+		assert.True(t, secrets.Sealed(raw.Url))
+		assert.NotContains(t, raw.Url, "PassphraseKey")
+		var stored models.AgendaSource
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, shareLink, stored.Url)
+
+		for _, path := range []string{"/api/agenda-sources", "/api/agenda-sources/" + source.ID} {
+			resp = api.Get(path, auth)
+			assert.Equal(t, http.StatusOK, resp.Code)
+			assert.NotContains(t, resp.Body.String(), "PassphraseKey", path)
+		}
+
+		// The owner can reveal it
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/reveal-url", auth, map[string]interface{}{})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var revealed struct {
+			URL string `json:"url"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &revealed))
+		assert.Equal(t, shareLink, revealed.URL)
+
+		// Sending the redacted URL back keeps the real one
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url":  source.URL,
+			"type": "proton",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, db.Where("resource_id = ?", source.ID).First(&stored).Error)
+		assert.Equal(t, shareLink, stored.Url)
+
+		// Other users and personal access tokens can't reveal it
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/reveal-url", authHeader(t, db, createTestUser(t, db)), map[string]interface{}{})
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		resp = api.Post("/api/tokens", auth, map[string]interface{}{
+			"name":   "sources",
+			"scopes": []string{"sources:read", "sources:write"},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var token struct {
+			Token string `json:"token"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &token))
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/reveal-url", "Authorization: Bearer "+token.Token, map[string]interface{}{})
+		assert.Equal(t, http.StatusForbidden, resp.Code)
+	})
+
+	t.Run("Agenda source private URL", func(t *testing.T) {
+		for _, url := range []string{
+			"http://169.254.169.254/latest/meta-data/",
+			"http://10.0.0.1/calendar.ics",
+			"http://localhost:8080/calendar.ics",
+			"http://metadata.google.internal/computeMetadata/v1/",
+			"https://[::ffff:127.0.0.2]/calendar.ics",
+			"webcal://192.168.1.10/calendar.ics?token=secret",
+		} {
+			resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+				"url":  url,
+				"type": "ics",
+			})
+			assert.Equal(t, http.StatusUnprocessableEntity, resp.Code, url)
+			assert.Contains(t, resp.Body.String(), "private or reserved", url)
+			// The error doesn't echo the secret parts of the URL
+			assert.NotContains(t, resp.Body.String(), "calendar.ics", url)
+		}
+
+		// Neither can an existing source be moved there
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":      "https://cloud.example.com/remote.php/dav",
+			"type":     "caldav",
+			"username": "alice",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var source struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url": "http://[fd00::1]/remote.php/dav",
+		})
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+	})
+
+	t.Run("Agenda source sync", func(t *testing.T) {
+		feed := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:-//Test//EN\r\n" +
+			"BEGIN:VEVENT\r\nUID:sync-1@example.com\r\nDTSTAMP:20240101T000000Z\r\n" +
+			"DTSTART:" + time.Now().Add(24*time.Hour).UTC().Format("20060102T150405Z") + "\r\n" +
+			"DURATION:PT1H\r\nSUMMARY:Planning\r\nEND:VEVENT\r\nEND:VCALENDAR\r\n"
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/calendar.ics" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(feed))
+		}))
+		defer server.Close()
+
+		resp := api.Post("/api/agenda-sources", auth, map[string]interface{}{
+			"url":  server.URL + "/calendar.ics",
+			"type": "ics",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "lastSyncStatus")
+		var source struct {
+			ID string `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &source))
+
+		type syncRun struct {
+			ID         string `json:"id"`
+			Trigger    string `json:"trigger"`
+			Status     string `json:"status"`
+			Error      string `json:"error"`
+			DurationMs int64  `json:"durationMs"`
+			Added      int    `json:"added"`
+			Updated    int    `json:"updated"`
+			Removed    int    `json:"removed"`
+		}
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/sync", auth, map[string]interface{}{})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var run syncRun
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &run))
+		assert.Equal(t, "manual", run.Trigger)
+		assert.Equal(t, "success", run.Status)
+		assert.Equal(t, 1, run.Added)
+
+		// The source tells how its last sync went
+		resp = api.Get("/api/agenda-sources/"+source.ID, auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var status struct {
+			LastSyncedAt   *time.Time `json:"lastSyncedAt"`
+			LastSyncStatus string     `json:"lastSyncStatus"`
+			LastError      string     `json:"lastError"`
+			ItemCount      int        `json:"itemCount"`
+			NextSyncAt     *time.Time `json:"nextSyncAt"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &status))
+		assert.NotNil(t, status.LastSyncedAt)
+		assert.Equal(t, "success", status.LastSyncStatus)
+		assert.Empty(t, status.LastError)
+		assert.Equal(t, 1, status.ItemCount)
+		if assert.NotNil(t, status.NextSyncAt) {
+			assert.True(t, status.NextSyncAt.After(time.Now()))
+		}
+
+		// A feed that's gone is a failed sync, not a failed request
+		resp = api.Put("/api/agenda-sources/"+source.ID, auth, map[string]interface{}{
+			"url": server.URL + "/gone.ics",
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/sync", auth, map[string]interface{}{})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &run))
+		assert.Equal(t, "failed", run.Status)
+		assert.Contains(t, run.Error, "404")
+
+		resp = api.Get("/api/agenda-sources/"+source.ID, auth)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &status))
+		assert.Equal(t, "failed", status.LastSyncStatus)
+		assert.Equal(t, run.Error, status.LastError)
+		assert.Equal(t, 1, status.ItemCount)
+
+		// The history lists the syncs, newest first
+		resp = api.Get("/api/agenda-sources/"+source.ID+"/syncs?pageSize=1", auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var history struct {
+			Data       []syncRun `json:"data"`
+			Pagination struct {
+				TotalItems int `json:"totalItems"`
+				TotalPages int `json:"totalPages"`
+			} `json:"pagination"`
+		}
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &history))
+		assert.Equal(t, 2, history.Pagination.TotalItems)
+		assert.Equal(t, 2, history.Pagination.TotalPages)
+		if assert.Len(t, history.Data, 1) {
+			assert.Equal(t, run.ID, history.Data[0].ID)
+		}
+
+		// Other users can't sync the source or see its history
+		otherAuth := authHeader(t, db, createTestUser(t, db))
+		resp = api.Post("/api/agenda-sources/"+source.ID+"/sync", otherAuth, map[string]interface{}{})
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		resp = api.Get("/api/agenda-sources/"+source.ID+"/syncs", otherAuth)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+}
+
+func TestAgendaInviteCRUD(t *testing.T) {
+	// Setup test database
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	// Setup API
+	api := setupAPI(t, db)
+	user := createTestUser(t, db)
+	assert.NoError(t, db.Model(&user).Update("email_verified_at", time.Now()).Error)
+	auth := authHeader(t, db, user)
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/cal.ics", Type: "ics", UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+
+	type inviteResponse struct {
+		ResourceID    string   `json:"ResourceID"`
+		Description   string   `json:"Description"`
+		PaddingBefore string   `json:"PaddingBefore"`
+		SlotSizes     []string `json:"SlotSizes"`
+		Visibility    string   `json:"Visibility"`
+		AgendaSources []struct {
+			ID string `json:"id"`
+		} `json:"AgendaSources"`
+	}
+
+	var invite inviteResponse
+	t.Run("Create agenda invite", func(t *testing.T) {
+		resp := api.Post("/api/agenda-invites", auth, map[string]interface{}{
+			"Description":     "Office hours",
+			"PaddingBefore":   "15m",
+			"SlotSizes":       []string{"30m", "1h"},
+			"Visibility":      "busy",
+			"AgendaSourceIDs": []string{source.ResourceID.String()},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &invite))
+		assert.Equal(t, "Office hours", invite.Description)
+		assert.Equal(t, "15m0s", invite.PaddingBefore)
+		assert.Equal(t, []string{"30m0s", "1h0m0s"}, invite.SlotSizes)
+		assert.Equal(t, models.VisibilityBusy, invite.Visibility)
+		if assert.Len(t, invite.AgendaSources, 1) {
+			assert.Equal(t, source.ResourceID.String(), invite.AgendaSources[0].ID)
+		}
+
+		var stored models.AgendaInvite
+		assert.NoError(t, db.Preload("AgendaSources").Where("resource_id = ?", invite.ResourceID).First(&stored).Error)
+		assert.Equal(t, user.ID, stored.UserID)
+		assert.Equal(t, 15*time.Minute, stored.PaddingBefore)
+		assert.Equal(t, models.VisibilityBusy, stored.Visibility)
+		assert.Len(t, stored.AgendaSources, 1)
+	})
+
+	t.Run("Get agenda invite", func(t *testing.T) {
+		resp := api.Get("/api/agenda-invites/"+invite.ResourceID, auth)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var got inviteResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &got))
+		assert.Equal(t, invite, got)
+
+		resp = api.Get("/api/agenda-invites/"+invite.ResourceID, authHeader(t, db, createTestUser(t, db)))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("Public view honors the invite's visibility", func(t *testing.T) {
+		start := time.Now().Add(time.Hour).Truncate(time.Second)
+		assert.NoError(t, db.Create(&models.AgendaItem{
+			ResourceID: uuid.New(), StartTime: start, EndTime: start.Add(time.Hour),
+			Description: "Dentist", AgendaSourceID: source.ID, UserID: user.ID,
+		}).Error)
+
+		resp := api.Get("/api/view-agenda-invite/" + invite.ResourceID)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.NotContains(t, resp.Body.String(), "Dentist")
+		assert.Contains(t, resp.Body.String(), `"Visibility":"busy"`)
+	})
+
+	t.Run("Update agenda invite", func(t *testing.T) {
+		resp := api.Put("/api/agenda-invites/"+invite.ResourceID, auth, map[string]interface{}{
+			"Description": "Coffee",
+			"SlotSizes":   []string{"15m"},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		var updated inviteResponse
+		assert.NoError(t, json.Unmarshal(resp.Body.Bytes(), &updated))
+		assert.Equal(t, "Coffee", updated.Description)
+		assert.Equal(t, "0s", updated.PaddingBefore)
+		assert.Empty(t, updated.Visibility)
+		assert.Empty(t, updated.AgendaSources)
+
+		var stored models.AgendaInvite
+		assert.NoError(t, db.Preload("AgendaSources").Where("resource_id = ?", invite.ResourceID).First(&stored).Error)
+		assert.Equal(t, "Coffee", stored.Description)
+		assert.Empty(t, stored.Visibility)
+		assert.Empty(t, stored.AgendaSources)
+
+		// Without sources nothing is shown, without an override the sources'
+		// own visibility applies
+		resp = api.Get("/api/view-agenda-invite/" + invite.ResourceID)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "[]", strings.TrimSpace(resp.Body.String()))
+		resp = api.Put("/api/agenda-invites/"+invite.ResourceID, auth, map[string]interface{}{
+			"AgendaSourceIDs": []string{source.ResourceID.String()},
+		})
+		assert.Equal(t, http.StatusOK, resp.Code)
+		resp = api.Get("/api/view-agenda-invite/" + invite.ResourceID)
+		assert.Contains(t, resp.Body.String(), "Dentist")
+	})
+
+	t.Run("Invalid agenda invites", func(t *testing.T) {
+		other := models.AgendaSource{ResourceID: uuid.New(), Url: "https://example.com/other.ics", Type: "ics", UserID: createTestUser(t, db).ID}
+		assert.NoError(t, db.Create(&other).Error)
+		for name, body := range map[string]map[string]interface{}{
+			"Other user's source": {"AgendaSourceIDs": []string{other.ResourceID.String()}},
+			"Invalid source ID":   {"AgendaSourceIDs": []string{"not-a-uuid"}},
+			"Invalid padding":     {"PaddingBefore": "soon"},
+			"Empty slot size":     {"SlotSizes": []string{"0s"}},
+			"Unknown visibility":  {"Visibility": "everything"},
+			"Reversed range":      {"NotBefore": time.Now(), "NotAfter": time.Now().Add(-time.Hour)},
+		} {
+			t.Run(name, func(t *testing.T) {
+				resp := api.Post("/api/agenda-invites", auth, body)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+				resp = api.Put("/api/agenda-invites/"+invite.ResourceID, auth, body)
+				assert.Equal(t, http.StatusUnprocessableEntity, resp.Code)
+			})
+		}
+	})
+
+	t.Run("Delete agenda invite", func(t *testing.T) {
+		resp := api.Delete("/api/agenda-invites/"+invite.ResourceID, authHeader(t, db, createTestUser(t, db)))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+
+		resp = api.Delete("/api/agenda-invites/"+invite.ResourceID, auth)
+		assert.Equal(t, http.StatusNoContent, resp.Code)
+		resp = api.Get("/api/agenda-invites/"+invite.ResourceID, auth)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		resp = api.Get("/api/view-agenda-invite/" + invite.ResourceID)
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
 }
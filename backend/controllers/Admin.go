@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"log"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"gorm.io/gorm"
+)
+
+// GetUsersInput represents the input for listing users as an admin
+type GetUsersInput struct {
+	Query    string `query:"q" maxLength:"254" doc:"Only include users whose email address contains this text"`
+	Role     string `query:"role" enum:"user,admin" doc:"Only include users with this role"`
+	Status   string `query:"status" enum:"active,disabled" doc:"Only include active or disabled users"`
+	Page     int    `query:"page" minimum:"1" default:"1" doc:"The page number to retrieve (1-based)."`
+	PageSize int    `query:"pageSize" minimum:"1" maximum:"100" default:"20" doc:"The number of items to include per page."`
+}
+
+// GetUsersOutput represents the output for listing users as an admin
+type GetUsersOutput struct {
+	Body struct {
+		Data       []User     `json:"data"`
+		Pagination Pagination `json:"pagination"`
+	}
+}
+
+// AdminUserInput represents the input for an admin operation on a user
+type AdminUserInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+}
+
+// AdminUserOutput represents the output for an admin operation on a user
+type AdminUserOutput struct {
+	Body User
+}
+
+// SetUserRoleInput represents the input for changing a user's role
+type SetUserRoleInput struct {
+	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+	Body struct {
+		Role string `json:"role" enum:"user,admin" example:"admin" doc:"The new role of the user"`
+	}
+}
+
+// RequireAdmin returns the authenticated user, or a 403 error unless they are an admin
+func RequireAdmin(ctx context.Context) (*models.User, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !user.IsAdmin() {
+		return nil, huma.Error403Forbidden("This operation is restricted to admins")
+	}
+	return user, nil
+}
+
+// AdminController handles user administration
+type AdminController struct {
+	DB *gorm.DB
+}
+
+// GetUsers lists and searches users with pagination
+func (ac *AdminController) GetUsers(ctx context.Context, input *GetUsersInput) (*GetUsersOutput, error) {
+	if _, err := RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	query := ac.DB.WithContext(ctx).Model(&models.User{})
+	if input.Query != "" {
+		query = query.Where("email ILIKE ?", "%"+escapeLike(input.Query)+"%")
+	}
+	if input.Role != "" {
+		query = query.Where("role = ?", input.Role)
+	}
+	switch input.Status {
+	case "active":
+		query = query.Where("disabled_at IS NULL")
+	case "disabled":
+		query = query.Where("disabled_at IS NOT NULL")
+	}
+
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	var users []models.User
+	offset := (input.Page - 1) * input.PageSize
+	if err := query.Order("id").Offset(offset).Limit(input.PageSize).Find(&users).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	resp := &GetUsersOutput{}
+	resp.Body.Data = make([]User, len(users))
+	for i, user := range users {
+		resp.Body.Data[i] = newUser(&user)
+	}
+	resp.Body.Pagination = NewPagination(input.Page, input.PageSize, count)
+	return resp, nil
+}
+
+// DisableUser disables an account and revokes its sessions.
+// Personal access tokens are kept but stop working while the account is disabled.
+func (ac *AdminController) DisableUser(ctx context.Context, input *AdminUserInput) (*AdminUserOutput, error) {
+	admin, err := RequireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if admin.ResourceID.String() == input.ID {
+		return nil, huma.Error409Conflict("You can't disable your own account")
+	}
+
+	var user models.User
+	err = ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+			return err
+		}
+		if user.Disabled() {
+			return nil
+		}
+		now := time.Now()
+		user.DisabledAt = &now
+		if err := tx.Model(&user).Update("disabled_at", now).Error; err != nil {
+			return err
+		}
+		return RevokeSessions(tx, user.ID, nil)
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &AdminUserOutput{Body: newUser(&user)}, nil
+}
+
+// EnableUser re-enables a disabled account
+func (ac *AdminController) EnableUser(ctx context.Context, input *AdminUserInput) (*AdminUserOutput, error) {
+	if _, err := RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := ac.DB.WithContext(ctx).Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	if user.Disabled() {
+		user.DisabledAt = nil
+		if err := ac.DB.WithContext(ctx).Model(&user).Update("disabled_at", nil).Error; err != nil {
+			return nil, ErrorGormToHuma(err)
+		}
+	}
+	return &AdminUserOutput{Body: newUser(&user)}, nil
+}
+
+// SetUserRole grants or removes admin rights
+func (ac *AdminController) SetUserRole(ctx context.Context, input *SetUserRoleInput) (*AdminUserOutput, error) {
+	admin, err := RequireAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if admin.ResourceID.String() == input.ID && input.Body.Role != models.RoleAdmin {
+		return nil, huma.Error409Conflict("You can't remove your own admin role")
+	}
+
+	var user models.User
+	if err := ac.DB.WithContext(ctx).Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	user.Role = input.Body.Role
+	if err := ac.DB.WithContext(ctx).Model(&user).Update("role", user.Role).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &AdminUserOutput{Body: newUser(&user)}, nil
+}
+
+// ForceDeleteUser permanently deletes any account, like delete-user does for your own
+func (ac *AdminController) ForceDeleteUser(ctx context.Context, input *AdminUserInput) (*struct{}, error) {
+	if _, err := RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	err := ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+			return err
+		}
+		return deleteUser(tx, &user)
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// PromoteAdmins gives the admin role to the existing users with the given
+// email addresses, to bootstrap administration of an instance
+func PromoteAdmins(db *gorm.DB, emails []string) error {
+	for _, email := range emails {
+		result := db.Model(&models.User{}).Where("lower(email) = lower(?)", email).Update("role", models.RoleAdmin)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			log.Printf("No user with email %s to promote to admin", email)
+		}
+	}
+	return nil
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	var out []rune
+	for _, r := range s {
+		if r == '%' || r == '_' || r == '\\' {
+			out = append(out, '\\')
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
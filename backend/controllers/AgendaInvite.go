@@ -0,0 +1,439 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// defaultViewDays is how many days ahead the public view looks when no end date is given
+const defaultViewDays = 7
+
+// maxViewDays is the longest range the public view shows at once, which keeps
+// anonymous requests from loading a whole agenda
+const maxViewDays = 6 * defaultViewDays
+
+// AgendaItemView represents a view of an agenda item without sensitive user
+// data. How much of the item it shows depends on the visibility of its source
+// or the invite.
+type AgendaItemView struct {
+	StartTime   time.Time `json:"StartTime" format:"date-time" doc:"The start time, with the offset of the invite's timezone"`
+	EndTime     time.Time `json:"EndTime" format:"date-time" doc:"The end time, with the offset of the invite's timezone"`
+	AllDay      bool      `json:"AllDay" doc:"Whether the item takes whole days, from midnight to midnight in the owner's timezone"`
+	Visibility  string    `json:"Visibility" enum:"busy,title,full" doc:"How much of the item is shown: 'busy' only when it takes place, 'title' also its title, 'full' also its location and notes"`
+	Description string    `json:"Description" doc:"The title of the item, empty when only busy time is shown"`
+	Location    string    `json:"Location,omitempty" doc:"The location of the item, with full visibility only"`
+	Notes       string    `json:"Notes,omitempty" doc:"Further details of the item, with full visibility only"`
+}
+
+// ViewAgendaInviteInput represents the input for viewing an agenda invite
+type ViewAgendaInviteInput struct {
+	ID       string    `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
+	DateFrom time.Time `query:"DateFrom,omitempty" format:"date-time" doc:"The start date and time for filtering agenda items"`
+	DateTo   time.Time `query:"DateTo,omitempty" format:"date-time" doc:"The end date and time for filtering agenda items"`
+}
+
+// ViewAgendaInviteOutput represents the output for viewing an agenda invite
+type ViewAgendaInviteOutput struct {
+	Body []AgendaItemView
+}
+
+// AgendaInvite represents an invitation to view a user's agenda in the API
+type AgendaInvite struct {
+	ResourceID    string          `json:"ResourceID" format:"uuid" doc:"The unique identifier of the agenda invite"`
+	UserID        string          `json:"UserID" format:"uuid" doc:"The ID of the user associated with the invite"`
+	Description   string          `json:"Description"`
+	ExpiresAt     time.Time       `json:"ExpiresAt" format:"date-time"`
+	NotBefore     time.Time       `json:"NotBefore" format:"date-time"`
+	NotAfter      time.Time       `json:"NotAfter" format:"date-time"`
+	PaddingBefore string          `json:"PaddingBefore" doc:"Duration before the event"`
+	PaddingAfter  string          `json:"PaddingAfter" doc:"Duration after the event"`
+	SlotSizes     []string        `json:"SlotSizes" doc:"Array of slot sizes as durations"`
+	Timezone      string          `json:"Timezone" example:"Europe/Amsterdam" doc:"The IANA timezone the invite is shown in, the owner's unless the invite sets its own"`
+	WorkingHours  []WorkingPeriod `json:"WorkingHours" doc:"The working hours offered by the invite, the owner's unless the invite sets its own"`
+	Visibility    string          `json:"Visibility,omitempty" enum:"busy,title,full" doc:"How much of the agenda items the invite shows, for all of its agenda sources. Omitted when the visibility of each source applies."`
+	AgendaSources []AgendaSource  `json:"AgendaSources"`
+}
+
+// AgendaInviteSettings holds the settings of an invite when creating or
+// replacing it. Omitted durations and dates are zero, which doesn't limit the
+// invite.
+type AgendaInviteSettings struct {
+	Description     string          `json:"Description,omitempty" maxLength:"500" example:"Office hours"`
+	ExpiresAt       time.Time       `json:"ExpiresAt,omitempty" format:"date-time" doc:"When the invite stops working"`
+	NotBefore       time.Time       `json:"NotBefore,omitempty" format:"date-time" doc:"The start of the part of the agenda the invite shows"`
+	NotAfter        time.Time       `json:"NotAfter,omitempty" format:"date-time" doc:"The end of the part of the agenda the invite shows"`
+	PaddingBefore   string          `json:"PaddingBefore,omitempty" example:"15m" doc:"Duration before the event"`
+	PaddingAfter    string          `json:"PaddingAfter,omitempty" example:"15m" doc:"Duration after the event"`
+	SlotSizes       []string        `json:"SlotSizes,omitempty" maxItems:"20" example:"[\"30m\",\"1h\"]" doc:"Array of slot sizes as durations"`
+	Timezone        string          `json:"Timezone,omitempty" maxLength:"64" example:"Europe/Amsterdam" doc:"The IANA timezone the invite is shown in, the owner's when omitted"`
+	WorkingHours    []WorkingPeriod `json:"WorkingHours,omitempty" maxItems:"50" doc:"The working hours offered by the invite, the owner's when omitted"`
+	Visibility      string          `json:"Visibility,omitempty" enum:"busy,title,full" doc:"How much of the agenda items the invite shows, for all of its agenda sources. Defaults to the visibility of each source."`
+	AgendaSourceIDs []string        `json:"AgendaSourceIDs,omitempty" maxItems:"100" doc:"The IDs of the caller's agenda sources the invite shows"`
+}
+
+// CreateAgendaInviteInput represents the input for creating an agenda invite
+type CreateAgendaInviteInput struct {
+	Body AgendaInviteSettings
+}
+
+// GetAgendaInviteInput represents the input for getting an agenda invite
+type GetAgendaInviteInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
+}
+
+// UpdateAgendaInviteInput represents the input for updating an agenda invite
+type UpdateAgendaInviteInput struct {
+	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
+	Body AgendaInviteSettings
+}
+
+// AgendaInviteOutput represents the output of the agenda invite operations
+type AgendaInviteOutput struct {
+	Body AgendaInvite
+}
+
+// DeleteAgendaInviteInput represents the input for deleting an agenda invite
+type DeleteAgendaInviteInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda invite"`
+}
+
+// newAgendaInvite converts a stored invite into its API representation, with
+// the owner's preferences for the ones it doesn't set
+func newAgendaInvite(invite *models.AgendaInvite, owner *models.User) AgendaInvite {
+	out := AgendaInvite{
+		ResourceID:    invite.ResourceID.String(),
+		UserID:        owner.ResourceID.String(),
+		Description:   invite.Description,
+		ExpiresAt:     invite.ExpiresAt,
+		NotBefore:     invite.NotBefore,
+		NotAfter:      invite.NotAfter,
+		PaddingBefore: invite.PaddingBefore.String(),
+		PaddingAfter:  invite.PaddingAfter.String(),
+		SlotSizes:     durationStrings(invite.SlotSizes),
+		Timezone:      inviteLocation(invite, owner).String(),
+		WorkingHours:  newWorkingHours(owner.EffectiveWorkingHours()),
+		Visibility:    invite.Visibility,
+		AgendaSources: make([]AgendaSource, len(invite.AgendaSources)),
+	}
+	if invite.WorkingHours != nil {
+		out.WorkingHours = newWorkingHours(invite.WorkingHours)
+	}
+	for i, source := range invite.AgendaSources {
+		out.AgendaSources[i] = newAgendaSource(&source, owner)
+	}
+	return out
+}
+
+// inviteError reports an invalid invite setting
+func inviteError(location, message string, value any) error {
+	return huma.Error422UnprocessableEntity("Invalid agenda invite", &huma.ErrorDetail{
+		Location: location,
+		Message:  message,
+		Value:    value,
+	})
+}
+
+// parseInviteDuration parses a padding or slot size. Slot sizes can't be zero.
+func parseInviteDuration(location, value string, slot bool) (time.Duration, error) {
+	if value == "" && !slot {
+		return 0, nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 || (slot && duration == 0) {
+		return 0, inviteError(location, "Expected a positive duration like 30m", value)
+	}
+	return duration, nil
+}
+
+// apply validates the settings and sets them on the invite, replacing the
+// previous ones. Agenda sources must be the owner's.
+func (s *AgendaInviteSettings) apply(ctx context.Context, db *gorm.DB, invite *models.AgendaInvite, owner *models.User, location string) error {
+	if err := s.applyPreferences(invite, location); err != nil {
+		return err
+	}
+
+	invite.AgendaSources = make([]models.AgendaSource, 0, len(s.AgendaSourceIDs))
+	for i, id := range s.AgendaSourceIDs {
+		idLocation := fmt.Sprintf("%s.AgendaSourceIDs[%d]", location, i)
+		resourceID, err := ParseResourceID(idLocation, id)
+		if err != nil {
+			return err
+		}
+		// Other users' sources are reported as unknown
+		var source models.AgendaSource
+		err = db.WithContext(ctx).Where("resource_id = ? AND user_id = ?", resourceID, owner.ID).First(&source).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return inviteError(idLocation, "Unknown agenda source", id)
+		}
+		if err != nil {
+			return err
+		}
+		invite.AgendaSources = append(invite.AgendaSources, source)
+	}
+	return nil
+}
+
+// applyPreferences validates all settings but the agenda sources and sets them
+// on the invite, which is also how archived invites are checked. Timezone and
+// working hours are left empty when omitted, so the invite follows the
+// owner's profile.
+func (s *AgendaInviteSettings) applyPreferences(invite *models.AgendaInvite, location string) error {
+	var err error
+	invite.Description = s.Description
+	invite.ExpiresAt, invite.NotBefore, invite.NotAfter = s.ExpiresAt, s.NotBefore, s.NotAfter
+	if !invite.NotBefore.IsZero() && !invite.NotAfter.IsZero() && invite.NotAfter.Before(invite.NotBefore) {
+		return inviteError(location+".NotAfter", "NotAfter can't be before NotBefore", s.NotAfter)
+	}
+	if invite.PaddingBefore, err = parseInviteDuration(location+".PaddingBefore", s.PaddingBefore, false); err != nil {
+		return err
+	}
+	if invite.PaddingAfter, err = parseInviteDuration(location+".PaddingAfter", s.PaddingAfter, false); err != nil {
+		return err
+	}
+	invite.SlotSizes = make(models.Durations, len(s.SlotSizes))
+	for i, size := range s.SlotSizes {
+		if invite.SlotSizes[i], err = parseInviteDuration(fmt.Sprintf("%s.SlotSizes[%d]", location, i), size, true); err != nil {
+			return err
+		}
+	}
+
+	invite.Timezone, invite.WorkingHours = "", nil
+	if s.Timezone != "" {
+		if invite.Timezone, err = parseTimezone(location+".Timezone", s.Timezone); err != nil {
+			return err
+		}
+	}
+	if s.WorkingHours != nil {
+		if invite.WorkingHours, err = parseWorkingHours(location+".WorkingHours", s.WorkingHours); err != nil {
+			return err
+		}
+	}
+	if s.Visibility != "" && !models.ValidVisibility(s.Visibility) {
+		return inviteError(location+".Visibility", "Unknown visibility", s.Visibility)
+	}
+	invite.Visibility = s.Visibility
+	return nil
+}
+
+// inviteLocation returns the timezone an invite is shown in, which is the
+// owner's unless the invite sets its own
+func inviteLocation(invite *models.AgendaInvite, owner *models.User) *time.Location {
+	if invite.Timezone != "" {
+		if loc, err := time.LoadLocation(invite.Timezone); err == nil {
+			return loc
+		}
+	}
+	return owner.Location()
+}
+
+// newAgendaItemView shows an agenda item as far as its visibility allows, in
+// the invite's timezone
+func newAgendaItemView(item *models.AgendaItem, visibility string, loc *time.Location) AgendaItemView {
+	view := AgendaItemView{
+		StartTime:  item.StartTime.In(loc),
+		EndTime:    item.EndTime.In(loc),
+		AllDay:     item.AllDay,
+		Visibility: models.VisibilityBusy,
+	}
+	switch visibility {
+	case models.VisibilityFull:
+		view.Location, view.Notes = item.Location, item.Notes
+		fallthrough
+	case models.VisibilityTitle:
+		view.Visibility, view.Description = visibility, item.Description
+	}
+	return view
+}
+
+// AgendaInviteController handles operations on agenda invites
+type AgendaInviteController struct {
+	DB *gorm.DB
+}
+
+// findInvite loads one of the caller's invites with its agenda sources. Other
+// users' invites are reported as missing.
+func (ac *AgendaInviteController) findInvite(ctx context.Context, id string, user *models.User) (*models.AgendaInvite, error) {
+	resourceID, err := ParseResourceID("path.id", id)
+	if err != nil {
+		return nil, err
+	}
+	var invite models.AgendaInvite
+	if err := ac.DB.WithContext(ctx).Preload("AgendaSources").Where("resource_id = ? AND user_id = ?", resourceID, user.ID).First(&invite).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &invite, nil
+}
+
+// CreateAgendaInvite creates a new invite to the caller's agenda
+func (ac *AgendaInviteController) CreateAgendaInvite(ctx context.Context, input *CreateAgendaInviteInput) (*AgendaInviteOutput, error) {
+	// Only verified users may publish their agenda
+	if err := RequireVerifiedEmail(ctx); err != nil {
+		return nil, err
+	}
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	invite := models.AgendaInvite{ResourceID: uuid.New(), UserID: user.ID}
+	if err := input.Body.apply(ctx, ac.DB, &invite, user, "body"); err != nil {
+		return nil, err
+	}
+	// The sources exist already, only link them
+	if err := ac.DB.WithContext(ctx).Omit("AgendaSources.*").Create(&invite).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &AgendaInviteOutput{Body: newAgendaInvite(&invite, user)}, nil
+}
+
+// GetAgendaInvite retrieves one of the caller's invites
+func (ac *AgendaInviteController) GetAgendaInvite(ctx context.Context, input *GetAgendaInviteInput) (*AgendaInviteOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invite, err := ac.findInvite(ctx, input.ID, user)
+	if err != nil {
+		return nil, err
+	}
+	return &AgendaInviteOutput{Body: newAgendaInvite(invite, user)}, nil
+}
+
+// UpdateAgendaInvite replaces the settings of one of the caller's invites
+func (ac *AgendaInviteController) UpdateAgendaInvite(ctx context.Context, input *UpdateAgendaInviteInput) (*AgendaInviteOutput, error) {
+	// Only verified users may publish their agenda
+	if err := RequireVerifiedEmail(ctx); err != nil {
+		return nil, err
+	}
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invite, err := ac.findInvite(ctx, input.ID, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := input.Body.apply(ctx, ac.DB, invite, user, "body"); err != nil {
+		return nil, err
+	}
+	err = ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("AgendaSources").Save(invite).Error; err != nil {
+			return err
+		}
+		return tx.Model(invite).Omit("AgendaSources.*").Association("AgendaSources").Replace(invite.AgendaSources)
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &AgendaInviteOutput{Body: newAgendaInvite(invite, user)}, nil
+}
+
+// DeleteAgendaInvite deletes one of the caller's invites, which takes it out
+// of the public view
+func (ac *AgendaInviteController) DeleteAgendaInvite(ctx context.Context, input *DeleteAgendaInviteInput) (*struct{}, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	invite, err := ac.findInvite(ctx, input.ID, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := ac.DB.WithContext(ctx).Delete(invite).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	// Return empty response for 204 No Content
+	return &struct{}{}, nil
+}
+
+// findPublicInvite loads an invite for the public view. Invites of disabled
+// accounts and expired invites are reported as gone.
+func (ac *AgendaInviteController) findPublicInvite(ctx context.Context, id string) (*models.AgendaInvite, *models.User, error) {
+	var invite models.AgendaInvite
+	if err := ac.DB.WithContext(ctx).Preload("AgendaSources").Where("resource_id = ?", id).First(&invite).Error; err != nil {
+		return nil, nil, err
+	}
+	var owner models.User
+	if err := ac.DB.WithContext(ctx).First(&owner, invite.UserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, huma.Error410Gone("This invite is no longer available")
+		}
+		return nil, nil, err
+	}
+	if owner.Disabled() {
+		return nil, nil, huma.Error410Gone("This invite is no longer available")
+	}
+	if !invite.ExpiresAt.IsZero() && invite.ExpiresAt.Before(time.Now()) {
+		return nil, nil, huma.Error410Gone("This invite has expired")
+	}
+	return &invite, &owner, nil
+}
+
+// ViewAgendaInvite lists the agenda items an invite shows within a date range
+func (ac *AgendaInviteController) ViewAgendaInvite(ctx context.Context, input *ViewAgendaInviteInput) (*ViewAgendaInviteOutput, error) {
+	invite, owner, err := ac.findPublicInvite(ctx, input.ID)
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	loc := inviteLocation(invite, owner)
+
+	from, to := input.DateFrom, input.DateTo
+	if from.IsZero() {
+		// Start at the beginning of the day where the owner is
+		now := time.Now().In(loc)
+		from = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	}
+	if to.IsZero() {
+		// Whole days, also when a DST change makes one of them shorter or longer
+		to = from.In(loc).AddDate(0, 0, defaultViewDays)
+	}
+	// The invite limits which part of the agenda can be seen
+	if !invite.NotBefore.IsZero() && from.Before(invite.NotBefore) {
+		from = invite.NotBefore
+	}
+	if !invite.NotAfter.IsZero() && to.After(invite.NotAfter) {
+		to = invite.NotAfter
+	}
+
+	if to.After(from.In(loc).AddDate(0, 0, maxViewDays)) {
+		return nil, huma.Error422UnprocessableEntity("The date range is too long", &huma.ErrorDetail{
+			Location: "query.DateTo",
+			Message:  fmt.Sprintf("The date range can be at most %d days", maxViewDays),
+			Value:    input.DateTo,
+		})
+	}
+
+	resp := &ViewAgendaInviteOutput{Body: []AgendaItemView{}}
+	if len(invite.AgendaSources) == 0 || !from.Before(to) {
+		return resp, nil
+	}
+
+	sourceIDs := make([]uint, len(invite.AgendaSources))
+	visibility := make(map[uint]string, len(invite.AgendaSources))
+	for i, source := range invite.AgendaSources {
+		sourceIDs[i] = source.ID
+		visibility[source.ID] = invite.SourceVisibility(&source)
+	}
+	var items []models.AgendaItem
+	err = ac.DB.WithContext(ctx).
+		Where("user_id = ? AND agenda_source_id IN ? AND start_time < ? AND end_time > ?", invite.UserID, sourceIDs, to, from).
+		Order("start_time").
+		Find(&items).Error
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	for _, item := range items {
+		resp.Body = append(resp.Body, newAgendaItemView(&item, visibility[item.AgendaSourceID], loc))
+	}
+	return resp, nil
+}
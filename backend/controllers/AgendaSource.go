@@ -2,21 +2,57 @@ package controllers
 
 import (
 	"awesomeProject/models"
+	"awesomeProject/sources"
 	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
 	"time"
 
+	"github.com/danielgtaylor/huma/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// minSyncInterval is the shortest time between syncs a source can ask for
+const minSyncInterval = 5 * time.Minute
+
+// sourceTypeSchemas are the schemas whose type property lists the agenda
+// source types, see DocumentSourceTypes
+var sourceTypeSchemas = []string{"AgendaSource", "ArchiveAgendaSource", "CreateAgendaSourceInputBody", "UpdateAgendaSourceInputBody"}
+
 // AgendaSource represents an agenda source in the API
 type AgendaSource struct {
-	ID        string    `json:"id" format:"uuid" example:"c29ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the agenda source"`
-	URL       string    `json:"url" format:"uri" example:"https://example.com/calendar" doc:"The URL of the agenda source"`
-	Type      string    `json:"type" enum:"proton" example:"proton" doc:"The type of the agenda source"`
-	UserID    string    `json:"userId" format:"uuid" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The ID of the user who owns the agenda source"`
-	CreatedAt time.Time `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the agenda source was created"`
-	UpdatedAt time.Time `json:"updatedAt" format:"date-time" example:"2023-12-02T15:00:00Z" doc:"The last time the agenda source was updated"`
+	ID                  string    `json:"id" format:"uuid" example:"c29ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the agenda source"`
+	URL                 string    `json:"url" example:"https://calendar.proton.me/" doc:"The URL of the agenda source, redacted to its scheme and host as the rest usually grants access to the calendar. The owner can reveal it with reveal-agenda-source-url."`
+	URLRedacted         bool      `json:"urlRedacted,omitempty" doc:"Whether the agenda source was imported without its real URL. It isn't synced until its URL is updated."`
+	Type                string    `json:"type" example:"proton" doc:"The type of the agenda source"`
+	Username            string    `json:"username,omitempty" example:"alice" doc:"The username the agenda source logs in with"`
+	SyncIntervalMinutes int       `json:"syncIntervalMinutes" example:"60" doc:"Minutes between syncs of the agenda source, 0 for the server's default"`
+	Visibility          string    `json:"visibility" enum:"busy,title,full" example:"title" doc:"How much of the agenda source's items invites show: 'busy' only when they take place, 'title' also their titles, 'full' also their locations and notes. Invites can override it."`
+	UserID              string    `json:"userId" format:"uuid" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The ID of the user who owns the agenda source"`
+	CreatedAt           time.Time `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the agenda source was created"`
+	UpdatedAt           time.Time `json:"updatedAt" format:"date-time" example:"2023-12-02T15:00:00Z" doc:"The last time the agenda source was updated"`
+
+	LastSyncedAt   *time.Time `json:"lastSyncedAt,omitempty" format:"date-time" example:"2023-12-02T15:30:00Z" doc:"When the last sync of the agenda source finished, whether or not it worked. Omitted before the first sync."`
+	LastSyncStatus string     `json:"lastSyncStatus,omitempty" enum:"success,not_modified,failed" example:"success" doc:"The outcome of the last sync: 'not_modified' when the calendar didn't change since the one before"`
+	LastError      string     `json:"lastError,omitempty" example:"calendar feed returned 404 Not Found" doc:"Why the last sync failed"`
+	ItemCount      int        `json:"itemCount" example:"42" doc:"The number of agenda items of the agenda source after its last sync that worked"`
+	NextSyncAt     *time.Time `json:"nextSyncAt,omitempty" format:"date-time" example:"2023-12-02T15:45:00Z" doc:"When the agenda source is synced next. Omitted when it's due right away."`
+}
+
+// AgendaSourceSync represents a sync of an agenda source in the API
+type AgendaSourceSync struct {
+	ID         string    `json:"id" format:"uuid" example:"d29ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the sync"`
+	Trigger    string    `json:"trigger" enum:"scheduled,manual" example:"scheduled" doc:"Whether the sync was scheduled or asked for"`
+	StartedAt  time.Time `json:"startedAt" format:"date-time" example:"2023-12-02T15:30:00Z" doc:"When the sync started"`
+	DurationMs int64     `json:"durationMs" example:"350" doc:"How long the sync took, in milliseconds"`
+	Status     string    `json:"status" enum:"success,not_modified,failed" example:"success" doc:"The outcome of the sync: 'not_modified' when the calendar didn't change since the last one"`
+	Error      string    `json:"error,omitempty" example:"calendar feed returned 404 Not Found" doc:"Why the sync failed"`
+	Added      int       `json:"added" example:"3" doc:"The number of agenda items the sync added"`
+	Updated    int       `json:"updated" example:"1" doc:"The number of agenda items the sync updated"`
+	Removed    int       `json:"removed" example:"0" doc:"The number of agenda items the sync removed"`
 }
 
 // GetAgendaSourcesInput represents the input for getting agenda sources
@@ -37,8 +73,13 @@ type GetAgendaSourcesOutput struct {
 // CreateAgendaSourceInput represents the input for creating an agenda source
 type CreateAgendaSourceInput struct {
 	Body struct {
-		URL  string `json:"url" format:"uri" example:"https://example.com/calendar" doc:"The URL of the agenda source"`
-		Type string `json:"type" enum:"proton" example:"proton" doc:"The type of the agenda source"`
+		URL      string `json:"url" format:"uri" example:"https://example.com/calendar" doc:"The URL of the agenda source"`
+		Type     string `json:"type" example:"proton" doc:"The type of the agenda source"`
+		Username string `json:"username,omitempty" maxLength:"255" example:"alice" doc:"The username to log in with, for types that support credentials"`
+		Password string `json:"password,omitempty" maxLength:"255" writeOnly:"true" doc:"The password to log in with, for types that support credentials. It is never returned."`
+
+		SyncIntervalMinutes int    `json:"syncIntervalMinutes,omitempty" minimum:"0" maximum:"10080" example:"60" doc:"Minutes between syncs, at least 5; the server's default when 0 or omitted"`
+		Visibility          string `json:"visibility,omitempty" enum:"busy,title,full" default:"title" doc:"How much of the items invites show: 'busy' only when they take place, 'title' also their titles, 'full' also their locations and notes"`
 	}
 }
 
@@ -61,8 +102,13 @@ type GetAgendaSourceOutput struct {
 type UpdateAgendaSourceInput struct {
 	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda source"`
 	Body struct {
-		URL  string `json:"url,omitempty" format:"uri" example:"https://newexample.com/calendar" doc:"The URL of the agenda source"`
-		Type string `json:"type,omitempty" enum:"proton" example:"proton" doc:"The type of the agenda source"`
+		URL      string `json:"url,omitempty" format:"uri" example:"https://newexample.com/calendar" doc:"The URL of the agenda source"`
+		Type     string `json:"type,omitempty" example:"proton" doc:"The type of the agenda source"`
+		Username string `json:"username,omitempty" maxLength:"255" example:"alice" doc:"The username to log in with, for types that support credentials. The credentials are forgotten when the URL moves to another host or the type changes."`
+		Password string `json:"password,omitempty" maxLength:"255" writeOnly:"true" doc:"The password to log in with, for types that support credentials. It is never returned."`
+
+		SyncIntervalMinutes *int   `json:"syncIntervalMinutes,omitempty" minimum:"0" maximum:"10080" example:"60" doc:"Minutes between syncs, at least 5; 0 for the server's default"`
+		Visibility          string `json:"visibility,omitempty" enum:"busy,title,full" doc:"How much of the items invites show: 'busy' only when they take place, 'title' also their titles, 'full' also their locations and notes"`
 	}
 }
 
@@ -76,18 +122,199 @@ type DeleteAgendaSourceInput struct {
 	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda source"`
 }
 
+// SyncAgendaSourceInput represents the input for syncing an agenda source
+type SyncAgendaSourceInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda source"`
+}
+
+// SyncAgendaSourceOutput represents the output for syncing an agenda source
+type SyncAgendaSourceOutput struct {
+	Body AgendaSourceSync
+}
+
+// GetAgendaSourceSyncsInput represents the input for getting the sync history of an agenda source
+type GetAgendaSourceSyncsInput struct {
+	ID       string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda source"`
+	Page     int    `query:"page" minimum:"1" default:"1" doc:"The page number to retrieve (1-based)."`
+	PageSize int    `query:"pageSize" minimum:"1" maximum:"100" default:"20" doc:"The number of items to include per page."`
+}
+
+// GetAgendaSourceSyncsOutput represents the output for getting the sync history of an agenda source
+type GetAgendaSourceSyncsOutput struct {
+	Body struct {
+		Data       []AgendaSourceSync `json:"data"`
+		Pagination Pagination         `json:"pagination"`
+	}
+}
+
+// RevealAgendaSourceURLInput represents the input for revealing the URL of an agenda source
+type RevealAgendaSourceURLInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the agenda source"`
+}
+
+// RevealAgendaSourceURLOutput represents the output for revealing the URL of an agenda source
+type RevealAgendaSourceURLOutput struct {
+	Body struct {
+		URL string `json:"url" format:"uri" example:"https://calendar.proton.me/api/calendar/v1/url/abc/calendar.ics?CacheKey=def&PassphraseKey=ghi" doc:"The full URL of the agenda source"`
+	}
+}
+
+// AgendaSourceType describes a supported type of agenda sources
+type AgendaSourceType struct {
+	Type        string   `json:"type" example:"proton" doc:"The type, as used by agenda sources"`
+	Remote      bool     `json:"remote" doc:"Whether calendars of the type are downloaded over the network"`
+	Schemes     []string `json:"schemes" example:"[\"https\",\"webcal\"]" doc:"The URL schemes sources of the type accept"`
+	Credentials bool     `json:"credentials" doc:"Whether sources of the type can log in with a username and password"`
+}
+
+// ListAgendaSourceTypesOutput represents the output for listing the agenda source types
+type ListAgendaSourceTypesOutput struct {
+	Body []AgendaSourceType
+}
+
+// newAgendaSource converts a stored agenda source into its API representation
+func newAgendaSource(source *models.AgendaSource, owner *models.User) AgendaSource {
+	return AgendaSource{
+		ID:                  source.ResourceID.String(),
+		URL:                 redactURL(source.Url),
+		URLRedacted:         source.URLRedacted,
+		Type:                source.Type,
+		Username:            source.Username,
+		SyncIntervalMinutes: int(source.SyncInterval / time.Minute),
+		Visibility:          source.ItemVisibility(),
+		UserID:              owner.ResourceID.String(),
+		CreatedAt:           source.CreatedAt,
+		UpdatedAt:           source.UpdatedAt,
+		LastSyncedAt:        source.LastSyncedAt,
+		LastSyncStatus:      source.LastSyncStatus,
+		LastError:           source.LastError,
+		ItemCount:           source.ItemCount,
+		NextSyncAt:          source.NextSyncAt,
+	}
+}
+
+// newAgendaSourceSync converts a stored sync into its API representation
+func newAgendaSourceSync(run *models.SyncRun) AgendaSourceSync {
+	return AgendaSourceSync{
+		ID:         run.ResourceID.String(),
+		Trigger:    run.Trigger,
+		StartedAt:  run.StartedAt,
+		DurationMs: run.Duration.Milliseconds(),
+		Status:     run.Status,
+		Error:      run.Error,
+		Added:      run.Added,
+		Updated:    run.Updated,
+		Removed:    run.Removed,
+	}
+}
+
 // AgendaSourceController handles operations on agenda sources
 type AgendaSourceController struct {
 	DB *gorm.DB
+	// Providers are the supported agenda source types
+	Providers *sources.Registry
+	// Scheduler runs the syncs asked for with SyncAgendaSource
+	Scheduler *sources.Scheduler
 }
 
-// GetAgendaSources retrieves a list of agenda sources with pagination
+// DocumentSourceTypes lists the supported agenda source types as the allowed
+// values of the type properties in the API's schemas, which also makes
+// requests with other types fail validation. It must be called after the
+// operations are registered.
+func DocumentSourceTypes(api huma.API, providers *sources.Registry) {
+	types := providers.Types()
+	enum := make([]any, len(types))
+	for i, sourceType := range types {
+		enum[i] = sourceType
+	}
+	schemas := api.OpenAPI().Components.Schemas.Map()
+	for _, name := range sourceTypeSchemas {
+		if schema, ok := schemas[name]; ok && schema.Properties["type"] != nil {
+			schema.Properties["type"].Enum = enum
+			schema.Properties["type"].PrecomputeMessages()
+		}
+	}
+}
+
+// sameHost reports whether two URLs point at the same host and port
+func sameHost(a, b string) bool {
+	parsedA, err := url.Parse(a)
+	if err != nil {
+		return false
+	}
+	parsedB, err := url.Parse(b)
+	if err != nil {
+		return false
+	}
+	return parsedA.Host != "" && strings.EqualFold(parsedA.Host, parsedB.Host)
+}
+
+// validateSource checks that a source's type is supported and its URL and
+// credentials can be used with the type. The URL is left alone unless checkURL
+// is set.
+func validateSource(providers *sources.Registry, source *models.AgendaSource, checkURL bool) error {
+	sourceType, url := source.Type, source.Url
+	provider, ok := providers.Get(sourceType)
+	if !ok {
+		return huma.Error422UnprocessableEntity("Unsupported agenda source type", &huma.ErrorDetail{
+			Location: "body.type",
+			Message:  "Unsupported agenda source type",
+			Value:    sourceType,
+		})
+	}
+	if checkURL {
+		if err := provider.ValidateURL(url); err != nil {
+			// Like responses, errors don't echo the secret parts of the URL
+			return huma.Error422UnprocessableEntity("Invalid agenda source URL", &huma.ErrorDetail{
+				Location: "body.url",
+				Message:  err.Error(),
+				Value:    redactURL(url),
+			})
+		}
+	}
+	if source.SyncInterval != 0 && source.SyncInterval < minSyncInterval {
+		return huma.Error422UnprocessableEntity("The sync interval is too short", &huma.ErrorDetail{
+			Location: "body.syncIntervalMinutes",
+			Message:  "The sync interval must be at least 5 minutes",
+			Value:    int(source.SyncInterval / time.Minute),
+		})
+	}
+	if (source.Username != "" || source.Password != "") && !provider.Capabilities().Credentials {
+		return huma.Error422UnprocessableEntity("Agenda sources of this type can't log in", &huma.ErrorDetail{
+			Location: "body.username",
+			Message:  "Agenda sources of this type can't log in",
+		})
+	}
+	return nil
+}
+
+// ListAgendaSourceTypes lists the supported agenda source types
+func (asc *AgendaSourceController) ListAgendaSourceTypes(ctx context.Context, input *struct{}) (*ListAgendaSourceTypesOutput, error) {
+	types := asc.Providers.Types()
+	resp := &ListAgendaSourceTypesOutput{Body: make([]AgendaSourceType, len(types))}
+	for i, sourceType := range types {
+		provider, _ := asc.Providers.Get(sourceType)
+		capabilities := provider.Capabilities()
+		resp.Body[i] = AgendaSourceType{
+			Type:        sourceType,
+			Remote:      capabilities.Remote,
+			Schemes:     capabilities.Schemes,
+			Credentials: capabilities.Credentials,
+		}
+	}
+	return resp, nil
+}
+
+// GetAgendaSources retrieves the caller's agenda sources with pagination
 func (asc *AgendaSourceController) GetAgendaSources(ctx context.Context, input *GetAgendaSourcesInput) (*GetAgendaSourcesOutput, error) {
 	var agendaSources []models.AgendaSource
 	var count int64
 
-	// Get user ID from context (assuming it's set by authentication middleware)
-	// For now, we'll just query all sources
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	owned := asc.DB.WithContext(ctx).Where("user_id = ?", user.ID)
 
 	// Set up pagination
 	offset := (input.Page - 1) * input.PageSize
@@ -99,12 +326,12 @@ func (asc *AgendaSourceController) GetAgendaSources(ctx context.Context, input *
 	}
 
 	// Count total items
-	if err := asc.DB.Model(&models.AgendaSource{}).Count(&count).Error; err != nil {
+	if err := owned.Model(&models.AgendaSource{}).Count(&count).Error; err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
 	// Get paginated items
-	if err := asc.DB.Order(order).Offset(offset).Limit(input.PageSize).Find(&agendaSources).Error; err != nil {
+	if err := owned.Order(order).Offset(offset).Limit(input.PageSize).Find(&agendaSources).Error; err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
@@ -114,154 +341,238 @@ func (asc *AgendaSourceController) GetAgendaSources(ctx context.Context, input *
 
 	// Convert model to API response
 	for i, source := range agendaSources {
-		resp.Body.Data[i] = AgendaSource{
-			ID:        source.ResourceID.String(),
-			URL:       source.Url,
-			Type:      source.Type,
-			UserID:    uuid.UUID{}.String(), // This should be replaced with actual user ID
-			CreatedAt: source.CreatedAt,
-			UpdatedAt: source.UpdatedAt,
-		}
+		resp.Body.Data[i] = newAgendaSource(&source, user)
 	}
 
 	// Set pagination info
-	totalPages := int(count) / input.PageSize
-	if int(count)%input.PageSize > 0 {
-		totalPages++
-	}
-
-	resp.Body.Pagination = Pagination{
-		Page:       input.Page,
-		PageSize:   input.PageSize,
-		TotalItems: int(count),
-		TotalPages: totalPages,
-	}
+	resp.Body.Pagination = NewPagination(input.Page, input.PageSize, count)
 
 	return resp, nil
 }
 
 // CreateAgendaSource creates a new agenda source
 func (asc *AgendaSourceController) CreateAgendaSource(ctx context.Context, input *CreateAgendaSourceInput) (*CreateAgendaSourceOutput, error) {
-	// Create a new agenda source
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create a new agenda source owned by the caller
 	agendaSource := models.AgendaSource{
 		ResourceID: uuid.New(),
 		Url:        input.Body.URL,
 		Type:       input.Body.Type,
-		// UserID should be set from authenticated user
-		// For now, we'll just set it to 1
-		UserID: 1,
+		UserID:     user.ID,
+		Username:   input.Body.Username,
+		Password:   input.Body.Password,
+
+		SyncInterval: time.Duration(input.Body.SyncIntervalMinutes) * time.Minute,
+		Visibility:   input.Body.Visibility,
+	}
+	if err := validateSource(asc.Providers, &agendaSource, true); err != nil {
+		return nil, err
 	}
 
 	// Save to database
-	if err := asc.DB.Create(&agendaSource).Error; err != nil {
+	if err := asc.DB.WithContext(ctx).Create(&agendaSource).Error; err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
 	// Prepare response
 	resp := &CreateAgendaSourceOutput{}
-	resp.Body = AgendaSource{
-		ID:        agendaSource.ResourceID.String(),
-		URL:       agendaSource.Url,
-		Type:      agendaSource.Type,
-		UserID:    uuid.UUID{}.String(), // This should be replaced with actual user ID
-		CreatedAt: agendaSource.CreatedAt,
-		UpdatedAt: agendaSource.UpdatedAt,
-	}
+	resp.Body = newAgendaSource(&agendaSource, user)
 
 	return resp, nil
 }
 
-// GetAgendaSource retrieves a single agenda source by ID
-func (asc *AgendaSourceController) GetAgendaSource(ctx context.Context, input *GetAgendaSourceInput) (*GetAgendaSourceOutput, error) {
+// findSource loads one of the caller's agenda sources, and the caller. Other
+// users' sources are reported as missing.
+func (asc *AgendaSourceController) findSource(ctx context.Context, id string) (*models.AgendaSource, *models.User, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	resourceID, err := ParseResourceID("path.id", id)
+	if err != nil {
+		return nil, nil, err
+	}
 	var agendaSource models.AgendaSource
+	if err := asc.DB.WithContext(ctx).Where("resource_id = ? AND user_id = ?", resourceID, user.ID).First(&agendaSource).Error; err != nil {
+		return nil, nil, ErrorGormToHuma(err)
+	}
+	return &agendaSource, user, nil
+}
 
-	// Parse UUID from string
-	resourceID, err := uuid.Parse(input.ID)
+// GetAgendaSource retrieves a single agenda source by ID
+func (asc *AgendaSourceController) GetAgendaSource(ctx context.Context, input *GetAgendaSourceInput) (*GetAgendaSourceOutput, error) {
+	agendaSource, user, err := asc.findSource(ctx, input.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find the agenda source
-	if err := asc.DB.Where("resource_id = ?", resourceID).First(&agendaSource).Error; err != nil {
-		return nil, ErrorGormToHuma(err)
-	}
-
 	// Prepare response
 	resp := &GetAgendaSourceOutput{}
-	resp.Body = AgendaSource{
-		ID:        agendaSource.ResourceID.String(),
-		URL:       agendaSource.Url,
-		Type:      agendaSource.Type,
-		UserID:    uuid.UUID{}.String(), // This should be replaced with actual user ID
-		CreatedAt: agendaSource.CreatedAt,
-		UpdatedAt: agendaSource.UpdatedAt,
-	}
+	resp.Body = newAgendaSource(agendaSource, user)
 
 	return resp, nil
 }
 
 // UpdateAgendaSource updates an existing agenda source
 func (asc *AgendaSourceController) UpdateAgendaSource(ctx context.Context, input *UpdateAgendaSourceInput) (*UpdateAgendaSourceOutput, error) {
-	var agendaSource models.AgendaSource
-
-	// Parse UUID from string
-	resourceID, err := uuid.Parse(input.ID)
+	agendaSource, user, err := asc.findSource(ctx, input.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find the agenda source
-	if err := asc.DB.Where("resource_id = ?", resourceID).First(&agendaSource).Error; err != nil {
-		return nil, ErrorGormToHuma(err)
-	}
-
 	// Update fields if provided
-	if input.Body.URL != "" {
+	previous := *agendaSource
+	// The redacted URL of the responses stands for the current one
+	if input.Body.URL != "" && input.Body.URL != redactURL(agendaSource.Url) {
+		// Credentials are only sent to the host they were given for
+		if !sameHost(agendaSource.Url, input.Body.URL) {
+			agendaSource.Username, agendaSource.Password = "", ""
+		}
 		agendaSource.Url = input.Body.URL
+		agendaSource.URLRedacted = false
 	}
-	if input.Body.Type != "" {
+	if input.Body.Type != "" && input.Body.Type != agendaSource.Type {
+		// Credentials are only kept for the type they were given for
 		agendaSource.Type = input.Body.Type
+		agendaSource.Username, agendaSource.Password = "", ""
+	}
+	if input.Body.Username != "" {
+		agendaSource.Username = input.Body.Username
+	}
+	if input.Body.Password != "" {
+		agendaSource.Password = input.Body.Password
+	}
+	if input.Body.SyncIntervalMinutes != nil {
+		agendaSource.SyncInterval = time.Duration(*input.Body.SyncIntervalMinutes) * time.Minute
+	}
+	if input.Body.Visibility != "" {
+		agendaSource.Visibility = input.Body.Visibility
+	}
+	calendarChanged := agendaSource.Url != previous.Url || agendaSource.Type != previous.Type
+	if calendarChanged {
+		// The calendar changed, sync it right away
+		agendaSource.ETag, agendaSource.LastModified = "", ""
+		agendaSource.NextSyncAt, agendaSource.SyncFailures = nil, 0
+	} else if agendaSource.SyncInterval != previous.SyncInterval {
+		agendaSource.NextSyncAt = nil
+	}
+	if err := validateSource(asc.Providers, agendaSource, true); err != nil {
+		return nil, err
 	}
 
 	// Save changes
-	if err := asc.DB.Save(&agendaSource).Error; err != nil {
+	err = asc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(agendaSource).Error; err != nil {
+			return err
+		}
+		if !calendarChanged {
+			return nil
+		}
+		// The calendars kept for the previous server don't belong to the new
+		// one, their objects go with them
+		return tx.Unscoped().Where("agenda_source_id = ?", agendaSource.ID).Delete(&models.CalendarCollection{}).Error
+	})
+	if err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
 	// Prepare response
 	resp := &UpdateAgendaSourceOutput{}
-	resp.Body = AgendaSource{
-		ID:        agendaSource.ResourceID.String(),
-		URL:       agendaSource.Url,
-		Type:      agendaSource.Type,
-		UserID:    uuid.UUID{}.String(), // This should be replaced with actual user ID
-		CreatedAt: agendaSource.CreatedAt,
-		UpdatedAt: agendaSource.UpdatedAt,
-	}
+	resp.Body = newAgendaSource(agendaSource, user)
 
 	return resp, nil
 }
 
 // DeleteAgendaSource deletes an agenda source by ID
 func (asc *AgendaSourceController) DeleteAgendaSource(ctx context.Context, input *DeleteAgendaSourceInput) (*struct{}, error) {
-	var agendaSource models.AgendaSource
+	agendaSource, _, err := asc.findSource(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Delete the agenda source
+	if err := asc.DB.WithContext(ctx).Delete(agendaSource).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	// Return empty response for 204 No Content
+	return &struct{}{}, nil
+}
 
-	// Parse UUID from string
-	resourceID, err := uuid.Parse(input.ID)
+// SyncAgendaSource syncs an agenda source right away and returns how that
+// went, including when the calendar couldn't be synced
+func (asc *AgendaSourceController) SyncAgendaSource(ctx context.Context, input *SyncAgendaSourceInput) (*SyncAgendaSourceOutput, error) {
+	agendaSource, _, err := asc.findSource(ctx, input.ID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Find the agenda source
-	if err := asc.DB.Where("resource_id = ?", resourceID).First(&agendaSource).Error; err != nil {
+	if agendaSource.URLRedacted {
+		return nil, newProblem(http.StatusConflict, CodeURLRedacted, "The agenda source needs its real URL before it can be synced")
+	}
+	run, err := asc.Scheduler.Sync(ctx, agendaSource, models.SyncTriggerManual)
+	if errors.Is(err, sources.ErrSyncRunning) {
+		return nil, newProblem(http.StatusConflict, CodeSyncRunning, "The agenda source is already being synced")
+	}
+	if err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
-	// Delete the agenda source
-	if err := asc.DB.Delete(&agendaSource).Error; err != nil {
+	// Prepare response
+	resp := &SyncAgendaSourceOutput{}
+	resp.Body = newAgendaSourceSync(run)
+
+	return resp, nil
+}
+
+// GetAgendaSourceSyncs retrieves the latest syncs of an agenda source with
+// pagination, newest first
+func (asc *AgendaSourceController) GetAgendaSourceSyncs(ctx context.Context, input *GetAgendaSourceSyncsInput) (*GetAgendaSourceSyncsOutput, error) {
+	var runs []models.SyncRun
+	var count int64
+
+	agendaSource, _, err := asc.findSource(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+	history := asc.DB.WithContext(ctx).Where("agenda_source_id = ?", agendaSource.ID)
+
+	// Count total items
+	if err := history.Model(&models.SyncRun{}).Count(&count).Error; err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
-	// Return empty response for 204 No Content
-	return &struct{}{}, nil
+	// Get paginated items
+	offset := (input.Page - 1) * input.PageSize
+	if err := history.Order("started_at DESC, id DESC").Offset(offset).Limit(input.PageSize).Find(&runs).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	// Prepare response
+	resp := &GetAgendaSourceSyncsOutput{}
+	resp.Body.Data = make([]AgendaSourceSync, len(runs))
+	for i := range runs {
+		resp.Body.Data[i] = newAgendaSourceSync(&runs[i])
+	}
+	resp.Body.Pagination = NewPagination(input.Page, input.PageSize, count)
+
+	return resp, nil
+}
+
+// RevealAgendaSourceURL returns the full URL of an agenda source
+func (asc *AgendaSourceController) RevealAgendaSourceURL(ctx context.Context, input *RevealAgendaSourceURLInput) (*RevealAgendaSourceURLOutput, error) {
+	agendaSource, _, err := asc.findSource(ctx, input.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Prepare response
+	resp := &RevealAgendaSourceURLOutput{}
+	resp.Body.URL = agendaSource.Url
+
+	return resp, nil
 }
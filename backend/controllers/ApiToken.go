@@ -0,0 +1,196 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ApiTokenPrefix marks bearer tokens that are personal access tokens rather than JWTs
+const ApiTokenPrefix = "pat_"
+
+// Scopes that can be granted to personal access tokens.
+// Operations list the scopes they need in their BearerAuth security requirement.
+const (
+	ScopeSourcesRead  = "sources:read"
+	ScopeSourcesWrite = "sources:write"
+	ScopeItemsRead    = "items:read"
+	ScopeItemsWrite   = "items:write"
+	ScopeInvitesRead  = "invites:read"
+	ScopeInvitesWrite = "invites:write"
+)
+
+// ApiToken represents a personal access token in the API
+type ApiToken struct {
+	ID         string     `json:"id" format:"uuid" example:"a1b2c3d4-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the token"`
+	Name       string     `json:"name" example:"nightly sync" doc:"A name to recognise the token by"`
+	Prefix     string     `json:"prefix" example:"pat_Xy12ab" doc:"The first characters of the token"`
+	Scopes     []string   `json:"scopes" example:"[\"items:write\"]" doc:"The operations the token may perform"`
+	ExpiresAt  *time.Time `json:"expiresAt,omitempty" format:"date-time" example:"2024-12-01T12:00:00Z" doc:"The time when the token stops working, if any"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty" format:"date-time" example:"2023-12-02T03:00:00Z" doc:"The last time the token was used"`
+	CreatedAt  time.Time  `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the token was created"`
+}
+
+// CreateApiTokenInput represents the input for creating a personal access token
+type CreateApiTokenInput struct {
+	Body struct {
+		Name      string     `json:"name" minLength:"1" maxLength:"100" example:"nightly sync" doc:"A name to recognise the token by"`
+		Scopes    []string   `json:"scopes" minItems:"1" uniqueItems:"true" enum:"sources:read,sources:write,items:read,items:write,invites:read,invites:write" example:"[\"items:write\"]" doc:"The operations the token may perform"`
+		ExpiresAt *time.Time `json:"expiresAt,omitempty" format:"date-time" example:"2024-12-01T12:00:00Z" doc:"Optional time when the token stops working"`
+	}
+}
+
+// CreateApiTokenOutput represents the output for creating a personal access token
+type CreateApiTokenOutput struct {
+	Body struct {
+		ApiToken
+		Token string `json:"token" example:"pat_Xy12abQ9..." doc:"The token itself. It is only shown once."`
+	}
+}
+
+// GetApiTokensInput represents the input for listing personal access tokens
+type GetApiTokensInput struct {
+	Page     int `query:"page" minimum:"1" default:"1" doc:"The page number to retrieve (1-based)."`
+	PageSize int `query:"pageSize" minimum:"1" maximum:"100" default:"20" doc:"The number of items to include per page."`
+}
+
+// GetApiTokensOutput represents the output for listing personal access tokens
+type GetApiTokensOutput struct {
+	Body struct {
+		Data       []ApiToken `json:"data"`
+		Pagination Pagination `json:"pagination"`
+	}
+}
+
+// DeleteApiTokenInput represents the input for revoking a personal access token
+type DeleteApiTokenInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the token"`
+}
+
+// newApiToken converts a stored token into its API representation
+func newApiToken(token *models.ApiToken) ApiToken {
+	scopes := []string(token.Scopes)
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return ApiToken{
+		ID:         token.ResourceID.String(),
+		Name:       token.Name,
+		Prefix:     token.Prefix,
+		Scopes:     scopes,
+		ExpiresAt:  token.ExpiresAt,
+		LastUsedAt: token.LastUsedAt,
+		CreatedAt:  token.CreatedAt,
+	}
+}
+
+// ApiTokenController handles operations on personal access tokens
+type ApiTokenController struct {
+	DB *gorm.DB
+}
+
+// CreateApiToken creates a personal access token and returns it once
+func (tc *ApiTokenController) CreateApiToken(ctx context.Context, input *CreateApiTokenInput) (*CreateApiTokenOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if input.Body.ExpiresAt != nil && !input.Body.ExpiresAt.After(time.Now()) {
+		return nil, huma.Error422UnprocessableEntity("expiresAt must be in the future")
+	}
+
+	secret, _, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	plain := ApiTokenPrefix + secret
+
+	apiToken := models.ApiToken{
+		ResourceID: uuid.New(),
+		UserID:     user.ID,
+		Name:       input.Body.Name,
+		TokenHash:  hashToken(plain),
+		Prefix:     plain[:len(ApiTokenPrefix)+6],
+		Scopes:     input.Body.Scopes,
+		ExpiresAt:  input.Body.ExpiresAt,
+	}
+	if err := tc.DB.WithContext(ctx).Create(&apiToken).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	resp := &CreateApiTokenOutput{}
+	resp.Body.ApiToken = newApiToken(&apiToken)
+	resp.Body.Token = plain
+	return resp, nil
+}
+
+// GetApiTokens lists the caller's personal access tokens
+func (tc *ApiTokenController) GetApiTokens(ctx context.Context, input *GetApiTokensInput) (*GetApiTokensOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiTokens []models.ApiToken
+	var count int64
+	owned := tc.DB.WithContext(ctx).Where("user_id = ?", user.ID)
+
+	if err := owned.Model(&models.ApiToken{}).Count(&count).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	offset := (input.Page - 1) * input.PageSize
+	if err := owned.Order("created_at DESC").Offset(offset).Limit(input.PageSize).Find(&apiTokens).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	resp := &GetApiTokensOutput{}
+	resp.Body.Data = make([]ApiToken, len(apiTokens))
+	for i, apiToken := range apiTokens {
+		resp.Body.Data[i] = newApiToken(&apiToken)
+	}
+	resp.Body.Pagination = NewPagination(input.Page, input.PageSize, count)
+	return resp, nil
+}
+
+// DeleteApiToken revokes one of the caller's personal access tokens
+func (tc *ApiTokenController) DeleteApiToken(ctx context.Context, input *DeleteApiTokenInput) (*struct{}, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiToken models.ApiToken
+	if err := tc.DB.WithContext(ctx).Where("resource_id = ? AND user_id = ?", input.ID, user.ID).First(&apiToken).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	if err := tc.DB.WithContext(ctx).Delete(&apiToken).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// authenticateApiToken resolves a personal access token to its token row and owner
+func authenticateApiToken(ctx context.Context, db *gorm.DB, plain string) (*models.ApiToken, *models.User, error) {
+	var apiToken models.ApiToken
+	if err := db.WithContext(ctx).Where("token_hash = ?", hashToken(plain)).First(&apiToken).Error; err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	if apiToken.Expired(now) {
+		return nil, nil, gorm.ErrRecordNotFound
+	}
+
+	var user models.User
+	if err := db.WithContext(ctx).First(&user, apiToken.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	// Recording usage must not fail the request
+	db.WithContext(ctx).Model(&apiToken).UpdateColumn("last_used_at", now)
+	apiToken.LastUsedAt = &now
+	return &apiToken, &user, nil
+}
@@ -0,0 +1,491 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ArchiveVersion is the format version of account archives written by export-user
+const ArchiveVersion = 1
+
+// AccountArchive is a self-contained copy of a user's data. IDs inside the
+// archive only relate its records to each other; an import assigns new ones.
+type AccountArchive struct {
+	Version           int                       `json:"version" example:"1" doc:"The archive format version"`
+	ExportedAt        time.Time                 `json:"exportedAt" format:"date-time" example:"2024-01-01T12:00:00Z" doc:"The time when the archive was created"`
+	Profile           ArchiveProfile            `json:"profile"`
+	AgendaSources     []ArchiveAgendaSource     `json:"agendaSources"`
+	AgendaItems       []ArchiveAgendaItem       `json:"agendaItems"`
+	AgendaInvites     []ArchiveAgendaInvite     `json:"agendaInvites"`
+	ProceduralAgendas []ArchiveProceduralAgenda `json:"proceduralAgendas"`
+}
+
+// ArchiveProfile holds the account details in an archive
+type ArchiveProfile struct {
+	Email         string    `json:"email" format:"email" example:"user@example.com" doc:"The user's email address"`
+	EmailVerified bool      `json:"emailVerified" example:"true" doc:"Whether the user had confirmed their email address"`
+	CreatedAt     time.Time `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the user was created"`
+	Preferences   *Profile  `json:"preferences,omitempty" doc:"The user's preferences, restored on import when present"`
+}
+
+// ArchiveAgendaSource is an agenda source in an archive
+type ArchiveAgendaSource struct {
+	ID          string    `json:"id" format:"uuid" doc:"The identifier of the agenda source within the archive"`
+	URL         string    `json:"url" format:"uri" example:"https://example.com/calendar" doc:"The URL of the agenda source, without its secret parts if redacted"`
+	URLRedacted bool      `json:"urlRedacted,omitempty" doc:"Whether the URL was redacted on export and has to be replaced after an import"`
+	Type        string    `json:"type" example:"proton" doc:"The type of the agenda source"`
+	Visibility  string    `json:"visibility,omitempty" enum:"busy,title,full" example:"title" doc:"How much of the agenda source's items invites show, 'title' when omitted"`
+	CreatedAt   time.Time `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the agenda source was created"`
+
+	SyncIntervalMinutes int    `json:"syncIntervalMinutes,omitempty" minimum:"0" maximum:"10080" example:"60" doc:"Minutes between syncs, at least 5; the server's default when 0 or omitted"`
+	Username            string `json:"username,omitempty" maxLength:"255" example:"alice" doc:"The username the agenda source logs in with, only exported with the secrets"`
+	Password            string `json:"password,omitempty" maxLength:"255" doc:"The password the agenda source logs in with, only exported with the secrets"`
+}
+
+// ArchiveAgendaItem is an agenda item in an archive
+type ArchiveAgendaItem struct {
+	ID             string     `json:"id" format:"uuid" doc:"The identifier of the agenda item within the archive"`
+	AgendaSourceID string     `json:"agendaSourceId" format:"uuid" doc:"The archive identifier of the agenda source the item came from"`
+	ExternalID     string     `json:"externalId,omitempty" doc:"The ID of the event occurrence in the source's calendar, for items synced from it"`
+	ExternalUID    string     `json:"externalUid,omitempty" doc:"The UID of the event the item is an occurrence of"`
+	Sequence       int        `json:"sequence,omitempty" doc:"The revision of the event when it was synced"`
+	LastModified   *time.Time `json:"lastModified,omitempty" format:"date-time" doc:"When the event was last modified"`
+	StartTime      time.Time  `json:"startTime" format:"date-time"`
+	EndTime        time.Time  `json:"endTime" format:"date-time"`
+	AllDay         bool       `json:"allDay,omitempty" doc:"Whether the item takes whole days"`
+	Timezone       string     `json:"timezone,omitempty" doc:"The IANA timezone the event was scheduled in"`
+	Description    string     `json:"description"`
+	Location       string     `json:"location,omitempty"`
+	Notes          string     `json:"notes,omitempty"`
+}
+
+// ArchiveAgendaInvite is an agenda invite in an archive
+type ArchiveAgendaInvite struct {
+	ID                  string          `json:"id" format:"uuid" doc:"The identifier of the agenda invite within the archive"`
+	Description         string          `json:"description"`
+	ExpiresAt           time.Time       `json:"expiresAt" format:"date-time"`
+	NotBefore           time.Time       `json:"notBefore" format:"date-time"`
+	NotAfter            time.Time       `json:"notAfter" format:"date-time"`
+	PaddingBefore       string          `json:"paddingBefore" example:"15m" doc:"Duration before the event"`
+	PaddingAfter        string          `json:"paddingAfter" example:"15m" doc:"Duration after the event"`
+	SlotSizes           []string        `json:"slotSizes" example:"[\"30m\",\"1h\"]" doc:"Slot sizes as durations"`
+	Timezone            string          `json:"timezone,omitempty" example:"Europe/Amsterdam" doc:"The timezone of the invite, if it overrides the owner's"`
+	WorkingHours        []WorkingPeriod `json:"workingHours,omitempty" doc:"The working hours of the invite, if they override the owner's"`
+	Visibility          string          `json:"visibility,omitempty" enum:"busy,title,full" doc:"The visibility of the invite, if it overrides the one of its agenda sources"`
+	AgendaSourceIDs     []string        `json:"agendaSourceIds" doc:"Archive identifiers of the agenda sources shown by the invite"`
+	ProceduralAgendaIDs []string        `json:"proceduralAgendaIds" doc:"Archive identifiers of the procedural agendas shown by the invite"`
+}
+
+// ArchiveProceduralAgenda is a procedural agenda used by one of the archived invites
+type ArchiveProceduralAgenda struct {
+	ID          string `json:"id" format:"uuid" doc:"The identifier of the procedural agenda within the archive"`
+	Descriptor  string `json:"descriptor"`
+	Description string `json:"description"`
+}
+
+// ExportUserInput represents the input for exporting an account
+type ExportUserInput struct {
+	ID             string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+	IncludeSecrets bool   `query:"includeSecrets" doc:"Include agenda source URLs in full, and their credentials. They usually grant access to the calendar, so URLs are redacted and credentials left out by default."`
+}
+
+// ExportUserOutput represents the output for exporting an account
+type ExportUserOutput struct {
+	ContentDisposition string `header:"Content-Disposition"`
+	Body               AccountArchive
+}
+
+// ImportUserInput represents the input for importing an account archive
+type ImportUserInput struct {
+	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user to import into"`
+	Body AccountArchive
+}
+
+// ImportUserOutput represents the output for importing an account archive
+type ImportUserOutput struct {
+	Body struct {
+		AgendaSources     int `json:"agendaSources" example:"2" doc:"The number of imported agenda sources"`
+		AgendaItems       int `json:"agendaItems" example:"120" doc:"The number of imported agenda items"`
+		AgendaInvites     int `json:"agendaInvites" example:"3" doc:"The number of imported agenda invites"`
+		ProceduralAgendas int `json:"proceduralAgendas" example:"1" doc:"The number of imported procedural agendas"`
+	}
+}
+
+// redactURL keeps only the scheme and host of a source URL, as the path and
+// query of calendar share links usually carry the access secret. URLs
+// without a host keep only their scheme.
+func redactURL(raw string) string {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme == "" {
+		return ""
+	}
+	if parsed.Host == "" {
+		return parsed.Scheme + ":"
+	}
+	return (&url.URL{Scheme: parsed.Scheme, Host: parsed.Host, Path: "/"}).String()
+}
+
+// archiveEntryError points the details of a validation error at an entry of
+// an archive, and names the entry in its message
+func archiveEntryError(err error, path, name string) error {
+	var model *huma.ErrorModel
+	if !errors.As(err, &model) {
+		return err
+	}
+	model.Detail = name + ": " + model.Detail
+	for _, detail := range model.Errors {
+		// Archive fields are in camel case, unlike those of invites
+		if field, ok := strings.CutPrefix(detail.Location, "body."); ok && field != "" {
+			detail.Location = "body." + path + "." + strings.ToLower(field[:1]) + field[1:]
+		}
+	}
+	return model
+}
+
+// durationStrings formats durations the way the API accepts them
+func durationStrings(durations []time.Duration) []string {
+	out := make([]string, len(durations))
+	for i, d := range durations {
+		out[i] = d.String()
+	}
+	return out
+}
+
+// ExportUser returns an archive of everything stored for a user
+func (uc *UserController) ExportUser(ctx context.Context, input *ExportUserInput) (*ExportUserOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
+	db := uc.DB.WithContext(ctx)
+	var user models.User
+	if err := db.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	var sources []models.AgendaSource
+	if err := db.Where("user_id = ?", user.ID).Order("id").Find(&sources).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	var items []models.AgendaItem
+	if err := db.Where("user_id = ?", user.ID).Order("start_time, id").Find(&items).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	var invites []models.AgendaInvite
+	if err := db.Preload("AgendaSources").Preload("ProceduralAgendas").Where("user_id = ?", user.ID).Order("id").Find(&invites).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	preferences := newProfile(&user)
+	archive := AccountArchive{
+		Version:    ArchiveVersion,
+		ExportedAt: time.Now().UTC(),
+		Profile: ArchiveProfile{
+			Email:         user.Email,
+			EmailVerified: user.EmailVerifiedAt != nil,
+			CreatedAt:     user.CreatedAt,
+			Preferences:   &preferences,
+		},
+		AgendaSources:     make([]ArchiveAgendaSource, 0, len(sources)),
+		AgendaItems:       make([]ArchiveAgendaItem, 0, len(items)),
+		AgendaInvites:     make([]ArchiveAgendaInvite, 0, len(invites)),
+		ProceduralAgendas: []ArchiveProceduralAgenda{},
+	}
+
+	sourceIDs := make(map[uint]string, len(sources))
+	for _, source := range sources {
+		sourceIDs[source.ID] = source.ResourceID.String()
+		entry := ArchiveAgendaSource{
+			ID:         source.ResourceID.String(),
+			URL:        source.Url,
+			Type:       source.Type,
+			Visibility: source.ItemVisibility(),
+			CreatedAt:  source.CreatedAt,
+
+			SyncIntervalMinutes: int(source.SyncInterval / time.Minute),
+		}
+		// Sources imported from a redacted archive stay redacted
+		entry.URLRedacted = source.URLRedacted
+		if input.IncludeSecrets {
+			entry.Username, entry.Password = source.Username, source.Password
+		} else {
+			entry.URL = redactURL(source.Url)
+			entry.URLRedacted = true
+		}
+		archive.AgendaSources = append(archive.AgendaSources, entry)
+	}
+
+	// Items of deleted sources are no longer shown anywhere and are left out
+	for _, item := range items {
+		sourceID, ok := sourceIDs[item.AgendaSourceID]
+		if !ok {
+			continue
+		}
+		archive.AgendaItems = append(archive.AgendaItems, ArchiveAgendaItem{
+			ID:             item.ResourceID.String(),
+			AgendaSourceID: sourceID,
+			ExternalID:     item.ExternalID,
+			ExternalUID:    item.ExternalUID,
+			Sequence:       item.Sequence,
+			LastModified:   item.LastModified,
+			StartTime:      item.StartTime,
+			EndTime:        item.EndTime,
+			AllDay:         item.AllDay,
+			Timezone:       item.Timezone,
+			Description:    item.Description,
+			Location:       item.Location,
+			Notes:          item.Notes,
+		})
+	}
+
+	procedural := map[uint]bool{}
+	for _, invite := range invites {
+		entry := ArchiveAgendaInvite{
+			ID:                  invite.ResourceID.String(),
+			Description:         invite.Description,
+			ExpiresAt:           invite.ExpiresAt,
+			NotBefore:           invite.NotBefore,
+			NotAfter:            invite.NotAfter,
+			PaddingBefore:       invite.PaddingBefore.String(),
+			PaddingAfter:        invite.PaddingAfter.String(),
+			SlotSizes:           durationStrings(invite.SlotSizes),
+			Timezone:            invite.Timezone,
+			Visibility:          invite.Visibility,
+			AgendaSourceIDs:     []string{},
+			ProceduralAgendaIDs: []string{},
+		}
+		if invite.WorkingHours != nil {
+			entry.WorkingHours = newWorkingHours(invite.WorkingHours)
+		}
+		for _, source := range invite.AgendaSources {
+			if id, ok := sourceIDs[source.ID]; ok {
+				entry.AgendaSourceIDs = append(entry.AgendaSourceIDs, id)
+			}
+		}
+		for _, agenda := range invite.ProceduralAgendas {
+			entry.ProceduralAgendaIDs = append(entry.ProceduralAgendaIDs, agenda.ResourceID.String())
+			if !procedural[agenda.ID] {
+				procedural[agenda.ID] = true
+				archive.ProceduralAgendas = append(archive.ProceduralAgendas, ArchiveProceduralAgenda{
+					ID:          agenda.ResourceID.String(),
+					Descriptor:  agenda.Descriptor,
+					Description: agenda.Description,
+				})
+			}
+		}
+		archive.AgendaInvites = append(archive.AgendaInvites, entry)
+	}
+
+	resp := &ExportUserOutput{Body: archive}
+	resp.ContentDisposition = fmt.Sprintf("attachment; filename=\"proton-agenda-%s.json\"", user.ResourceID)
+	return resp, nil
+}
+
+// ImportUser restores an archive into an account that has no agenda data yet.
+// Every record gets a new ResourceID and relations are remapped accordingly.
+func (uc *UserController) ImportUser(ctx context.Context, input *ImportUserInput) (*ImportUserOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+	archive := &input.Body
+	if archive.Version != ArchiveVersion {
+		return nil, huma.Error422UnprocessableEntity(fmt.Sprintf("Unsupported archive version %d", archive.Version))
+	}
+
+	resp := &ImportUserOutput{}
+	err = uc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+			return err
+		}
+
+		var existing int64
+		if err := tx.Model(&models.AgendaSource{}).Where("user_id = ?", user.ID).Count(&existing).Error; err != nil {
+			return err
+		}
+		if existing == 0 {
+			if err := tx.Model(&models.AgendaInvite{}).Where("user_id = ?", user.ID).Count(&existing).Error; err != nil {
+				return err
+			}
+		}
+		if existing > 0 {
+			return huma.Error409Conflict("Archives can only be imported into an account without agenda sources or invites")
+		}
+
+		if prefs := archive.Profile.Preferences; prefs != nil {
+			update := ProfileUpdate{
+				DisplayName:  &prefs.DisplayName,
+				Timezone:     prefs.Timezone,
+				Locale:       prefs.Locale,
+				WeekStart:    prefs.WeekStart,
+				WorkingHours: prefs.WorkingHours,
+			}
+			updates, err := update.apply(&user, "body.profile.preferences")
+			if err != nil {
+				return err
+			}
+			if err := tx.Model(&user).Updates(updates).Error; err != nil {
+				return err
+			}
+		}
+
+		sources := make(map[string]*models.AgendaSource, len(archive.AgendaSources))
+		for i, entry := range archive.AgendaSources {
+			if _, ok := sources[entry.ID]; ok {
+				return huma.Error422UnprocessableEntity(fmt.Sprintf("Duplicate agenda source %s", entry.ID))
+			}
+			source := &models.AgendaSource{
+				ResourceID: uuid.New(),
+				Url:        entry.URL,
+				Type:       entry.Type,
+				UserID:     user.ID,
+				Visibility: entry.Visibility,
+				Username:   entry.Username,
+				Password:   entry.Password,
+
+				SyncInterval: time.Duration(entry.SyncIntervalMinutes) * time.Minute,
+				// Sources are only synced once the owner sets their real URL
+				URLRedacted: entry.URLRedacted,
+			}
+			// Sources are checked like new ones. Redacted URLs without a host,
+			// like those of files, can't reach anything and are replaced after
+			// the import.
+			checkURL := true
+			if parsed, err := url.Parse(entry.URL); entry.URLRedacted && err == nil && parsed.Host == "" && parsed.Opaque == "" && parsed.Path == "" {
+				checkURL = false
+			}
+			if err := validateSource(uc.Providers, source, checkURL); err != nil {
+				return archiveEntryError(err, fmt.Sprintf("agendaSources[%d]", i), "Agenda source "+entry.ID)
+			}
+			if err := tx.Create(source).Error; err != nil {
+				return err
+			}
+			sources[entry.ID] = source
+		}
+
+		items := make([]models.AgendaItem, len(archive.AgendaItems))
+		for i, entry := range archive.AgendaItems {
+			source, ok := sources[entry.AgendaSourceID]
+			if !ok {
+				return huma.Error422UnprocessableEntity(fmt.Sprintf("Agenda item %s refers to unknown agenda source %s", entry.ID, entry.AgendaSourceID))
+			}
+			items[i] = models.AgendaItem{
+				ResourceID:     uuid.New(),
+				StartTime:      entry.StartTime,
+				EndTime:        entry.EndTime,
+				AllDay:         entry.AllDay,
+				Timezone:       entry.Timezone,
+				Description:    entry.Description,
+				Location:       entry.Location,
+				Notes:          entry.Notes,
+				AgendaSourceID: source.ID,
+				ExternalID:     entry.ExternalID,
+				ExternalUID:    entry.ExternalUID,
+				Sequence:       entry.Sequence,
+				LastModified:   entry.LastModified,
+				UserID:         user.ID,
+			}
+		}
+		if len(items) > 0 {
+			if err := tx.CreateInBatches(items, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		agendas := make(map[string]*models.ProceduralAgenda, len(archive.ProceduralAgendas))
+		for _, entry := range archive.ProceduralAgendas {
+			if _, ok := agendas[entry.ID]; ok {
+				return huma.Error422UnprocessableEntity(fmt.Sprintf("Duplicate procedural agenda %s", entry.ID))
+			}
+			agenda := &models.ProceduralAgenda{
+				ResourceID:  uuid.New(),
+				Descriptor:  entry.Descriptor,
+				Description: entry.Description,
+			}
+			if err := tx.Create(agenda).Error; err != nil {
+				return err
+			}
+			agendas[entry.ID] = agenda
+		}
+
+		for i, entry := range archive.AgendaInvites {
+			invite, err := importInvite(&entry, fmt.Sprintf("agendaInvites[%d]", i), sources, agendas)
+			if err != nil {
+				return err
+			}
+			invite.UserID = user.ID
+			// The related records exist already, only the join rows are needed
+			if err := tx.Omit("AgendaSources.*", "ProceduralAgendas.*").Create(invite).Error; err != nil {
+				return err
+			}
+		}
+
+		resp.Body.AgendaSources = len(sources)
+		resp.Body.AgendaItems = len(items)
+		resp.Body.AgendaInvites = len(archive.AgendaInvites)
+		resp.Body.ProceduralAgendas = len(agendas)
+		return nil
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return resp, nil
+}
+
+// importInvite converts an archived invite, resolving its relations to the
+// records created by the import
+func importInvite(entry *ArchiveAgendaInvite, path string, sources map[string]*models.AgendaSource, agendas map[string]*models.ProceduralAgenda) (*models.AgendaInvite, error) {
+	invalid := func(format string, args ...interface{}) error {
+		return huma.Error422UnprocessableEntity(fmt.Sprintf("Agenda invite %s: ", entry.ID) + fmt.Sprintf(format, args...))
+	}
+
+	// Archived invites are checked like new ones
+	settings := AgendaInviteSettings{
+		Description:   entry.Description,
+		ExpiresAt:     entry.ExpiresAt,
+		NotBefore:     entry.NotBefore,
+		NotAfter:      entry.NotAfter,
+		PaddingBefore: entry.PaddingBefore,
+		PaddingAfter:  entry.PaddingAfter,
+		SlotSizes:     entry.SlotSizes,
+		Timezone:      entry.Timezone,
+		WorkingHours:  entry.WorkingHours,
+		Visibility:    entry.Visibility,
+	}
+	invite := &models.AgendaInvite{ResourceID: uuid.New()}
+	if err := settings.applyPreferences(invite, "body"); err != nil {
+		return nil, archiveEntryError(err, path, "Agenda invite "+entry.ID)
+	}
+
+	for _, id := range entry.AgendaSourceIDs {
+		source, ok := sources[id]
+		if !ok {
+			return nil, invalid("unknown agenda source %s", id)
+		}
+		invite.AgendaSources = append(invite.AgendaSources, *source)
+	}
+	for _, id := range entry.ProceduralAgendaIDs {
+		agenda, ok := agendas[id]
+		if !ok {
+			return nil, invalid("unknown procedural agenda %s", id)
+		}
+		invite.ProceduralAgendas = append(invite.ProceduralAgendas, *agenda)
+	}
+	return invite, nil
+}
@@ -0,0 +1,293 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BearerAuthScheme is the name of the security scheme protecting operations
+const BearerAuthScheme = "BearerAuth"
+
+type contextKey string
+
+const (
+	userContextKey    contextKey = "user"
+	sessionContextKey contextKey = "session"
+)
+
+// AuthConfig holds the settings used to issue and verify access tokens
+type AuthConfig struct {
+	// SigningKey signs newly issued tokens and is always accepted for verification
+	SigningKey []byte
+	// VerificationKeys are additional keys accepted for verification, e.g. during key rotation
+	VerificationKeys [][]byte
+	Issuer           string
+	Audience         string
+	AccessTokenTTL   time.Duration
+	RefreshTokenTTL  time.Duration
+}
+
+// AccessClaims are the JWT claims carried by an access token
+type AccessClaims struct {
+	jwt.RegisteredClaims
+	// SessionID ties the token to a models.Session so revoking the session
+	// revokes the token. Access tokens without one are rejected.
+	SessionID string `json:"sid,omitempty"`
+}
+
+// IssueSessionAccessToken creates a signed access token bound to a session
+func (c *AuthConfig) IssueSessionAccessToken(user *models.User, session *models.Session) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(c.AccessTokenTTL)
+	claims := AccessClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    c.Issuer,
+			Subject:   user.ResourceID.String(),
+			Audience:  jwt.ClaimStrings{c.Audience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			ID:        uuid.New().String(),
+		},
+		SessionID: session.ResourceID.String(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.SigningKey)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return token, expiresAt, nil
+}
+
+// ParseAccessToken verifies the signature and registered claims of an access token
+func (c *AuthConfig) ParseAccessToken(tokenString string) (*AccessClaims, error) {
+	claims := &PurposeClaims{}
+	if err := c.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	// Single-purpose tokens are signed with the same key but grant no access
+	if claims.Purpose != "" {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return &claims.AccessClaims, nil
+}
+
+// PurposeClaims are the JWT claims of single-purpose tokens such as email verification links.
+// They are never accepted as access tokens.
+type PurposeClaims struct {
+	AccessClaims
+	Purpose string `json:"purpose"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IssuePurposeToken creates a signed token for the user that is only valid for the given purpose
+func (c *AuthConfig) IssuePurposeToken(purpose string, user *models.User, ttl time.Duration) (string, error) {
+	token, _, err := c.issuePurposeToken(purpose, user, ttl)
+	return token, err
+}
+
+// issuePurposeToken is IssuePurposeToken, also returning the token's ID for
+// tokens that are tracked to be used once
+func (c *AuthConfig) issuePurposeToken(purpose string, user *models.User, ttl time.Duration) (string, string, error) {
+	now := time.Now()
+	claims := PurposeClaims{
+		AccessClaims: AccessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    c.Issuer,
+				Subject:   user.ResourceID.String(),
+				Audience:  jwt.ClaimStrings{c.Audience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+				ID:        uuid.New().String(),
+			},
+		},
+		Purpose: purpose,
+		Email:   user.Email,
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(c.SigningKey)
+	return token, claims.ID, err
+}
+
+// ParsePurposeToken verifies a token issued by IssuePurposeToken for the given purpose
+func (c *AuthConfig) ParsePurposeToken(purpose, tokenString string) (*PurposeClaims, error) {
+	claims := &PurposeClaims{}
+	if err := c.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, jwt.ErrTokenInvalidClaims
+	}
+	return claims, nil
+}
+
+// parse verifies a token with the signing key and each verification key in turn
+func (c *AuthConfig) parse(tokenString string, claims jwt.Claims) error {
+	keys := append([][]byte{c.SigningKey}, c.VerificationKeys...)
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if c.Issuer != "" {
+		options = append(options, jwt.WithIssuer(c.Issuer))
+	}
+	if c.Audience != "" {
+		options = append(options, jwt.WithAudience(c.Audience))
+	}
+
+	var lastErr error
+	for _, key := range keys {
+		_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			return key, nil
+		}, options...)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		// Only a signature mismatch is worth retrying with the next key
+		if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			break
+		}
+	}
+	return lastErr
+}
+
+// UserFromContext returns the authenticated user stored by the auth middleware
+func UserFromContext(ctx context.Context) (*models.User, bool) {
+	user, ok := ctx.Value(userContextKey).(*models.User)
+	return user, ok
+}
+
+// SessionFromContext returns the session the request's access token is bound to, if any
+func SessionFromContext(ctx context.Context) (*models.Session, bool) {
+	session, ok := ctx.Value(sessionContextKey).(*models.Session)
+	return session, ok
+}
+
+// CurrentUser returns the authenticated user or a 401 error if there is none
+func CurrentUser(ctx context.Context) (*models.User, error) {
+	user, ok := UserFromContext(ctx)
+	if !ok {
+		return nil, huma.Error401Unauthorized("Authentication required")
+	}
+	return user, nil
+}
+
+// bearerAuthScopes reports whether the operation lists the BearerAuth scheme
+// and which scopes a personal access token needs to call it
+func bearerAuthScopes(op *huma.Operation) ([]string, bool) {
+	if op == nil {
+		return nil, false
+	}
+	for _, requirement := range op.Security {
+		if scopes, ok := requirement[BearerAuthScheme]; ok {
+			return scopes, true
+		}
+	}
+	return nil, false
+}
+
+// bearerToken extracts the token from an `Authorization: Bearer <token>` header
+func bearerToken(header string) (string, bool) {
+	scheme, token, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// NewAuthMiddleware returns a huma middleware enforcing the BearerAuth scheme.
+// Operations without the scheme pass through untouched; the others require a
+// valid access token or personal access token and get the authenticated
+// models.User in their context. Personal access tokens must hold every scope
+// listed in the operation's requirement, and can't call operations listing none.
+func NewAuthMiddleware(api huma.API, db *gorm.DB, config *AuthConfig) func(ctx huma.Context, next func(huma.Context)) {
+	return func(ctx huma.Context, next func(huma.Context)) {
+		scopes, required := bearerAuthScopes(ctx.Operation())
+		if !required {
+			next(ctx)
+			return
+		}
+
+		unauthorized := func(msg string) {
+			ctx.SetHeader("WWW-Authenticate", `Bearer realm="api"`)
+			_ = huma.WriteErr(api, ctx, http.StatusUnauthorized, msg)
+		}
+
+		token, ok := bearerToken(ctx.Header("Authorization"))
+		if !ok {
+			unauthorized("Missing bearer token")
+			return
+		}
+
+		if strings.HasPrefix(token, ApiTokenPrefix) {
+			apiToken, user, err := authenticateApiToken(ctx.Context(), db, token)
+			if err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					unauthorized("Invalid bearer token")
+					return
+				}
+				_ = huma.WriteErr(api, ctx, http.StatusInternalServerError, "Failed to load token", err)
+				return
+			}
+			if user.Disabled() {
+				unauthorized("Account is disabled")
+				return
+			}
+			if len(scopes) == 0 || !apiToken.Scopes.Has(scopes...) {
+				_ = huma.WriteErr(api, ctx, http.StatusForbidden, "The token lacks the required scopes: "+strings.Join(scopes, ", "))
+				return
+			}
+			next(huma.WithValue(ctx, userContextKey, user))
+			return
+		}
+
+		claims, err := config.ParseAccessToken(token)
+		// Every access token is bound to a session, so that it can be revoked
+		if err != nil || claims.SessionID == "" {
+			unauthorized("Invalid bearer token")
+			return
+		}
+
+		resourceID, err := uuid.Parse(claims.Subject)
+		if err != nil {
+			unauthorized("Invalid bearer token")
+			return
+		}
+
+		var user models.User
+		if err := db.WithContext(ctx.Context()).Where("resource_id = ?", resourceID).First(&user).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				unauthorized("Invalid bearer token")
+				return
+			}
+			_ = huma.WriteErr(api, ctx, http.StatusInternalServerError, "Failed to load user", err)
+			return
+		}
+
+		if user.Disabled() {
+			unauthorized("Account is disabled")
+			return
+		}
+		ctx = huma.WithValue(ctx, userContextKey, &user)
+
+		// Tokens die with their session, e.g. after logout
+		var session models.Session
+		err = db.WithContext(ctx.Context()).Where("resource_id = ? AND user_id = ?", claims.SessionID, user.ID).First(&session).Error
+		if err != nil || !session.Active(time.Now()) {
+			unauthorized("Session has been revoked")
+			return
+		}
+		ctx = huma.WithValue(ctx, sessionContextKey, &session)
+
+		next(ctx)
+	}
+}
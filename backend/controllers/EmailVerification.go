@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"awesomeProject/mailer"
+	"awesomeProject/models"
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+)
+
+// PurposeVerifyEmail is the purpose of tokens embedded in email verification links
+const PurposeVerifyEmail = "verify-email"
+
+// VerifyEmailInput represents the input for confirming an email verification link
+type VerifyEmailInput struct {
+	Token string `query:"token" required:"true" doc:"The token from the verification link"`
+}
+
+// VerifyEmailOutput represents the output for confirming an email verification link
+type VerifyEmailOutput struct {
+	Body User
+}
+
+// ResendVerificationInput represents the input for resending the verification link
+type ResendVerificationInput struct{}
+
+// EmailVerifier sends signed email verification links
+type EmailVerifier struct {
+	Config *AuthConfig
+	Mailer mailer.Mailer
+	// PublicURL is the externally reachable base URL of the API, used to build links
+	PublicURL string
+	TTL       time.Duration
+}
+
+// SendVerification emails the user a link confirming their current address
+func (v *EmailVerifier) SendVerification(ctx context.Context, user *models.User) error {
+	token, err := v.Config.IssuePurposeToken(PurposeVerifyEmail, user, v.TTL)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/verify-email?token=%s", strings.TrimRight(v.PublicURL, "/"), url.QueryEscape(token))
+	return v.Mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Confirm your email address",
+		Body: "Please confirm your email address by opening the link below:\n\n" +
+			link + "\n\n" +
+			fmt.Sprintf("The link expires in %s. If you didn't create an account, you can ignore this email.\n", v.TTL),
+	})
+}
+
+// RequireVerifiedEmail returns a 403 error unless the authenticated user has verified their email
+func RequireVerifiedEmail(ctx context.Context) error {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerifiedAt == nil {
+		return huma.Error403Forbidden("Please verify your email address first")
+	}
+	return nil
+}
+
+// VerifyEmail confirms an email verification link
+func (uc *UserController) VerifyEmail(ctx context.Context, input *VerifyEmailInput) (*VerifyEmailOutput, error) {
+	invalid := huma.Error400BadRequest("Invalid or expired verification link")
+
+	claims, err := uc.Verifier.Config.ParsePurposeToken(PurposeVerifyEmail, input.Token)
+	if err != nil {
+		return nil, invalid
+	}
+
+	var user models.User
+	if err := uc.DB.WithContext(ctx).Where("resource_id = ?", claims.Subject).First(&user).Error; err != nil {
+		return nil, invalid
+	}
+	// The link only confirms the address it was sent to
+	if !strings.EqualFold(user.Email, claims.Email) {
+		return nil, invalid
+	}
+
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := uc.DB.WithContext(ctx).Model(&user).Update("email_verified_at", now).Error; err != nil {
+			return nil, ErrorGormToHuma(err)
+		}
+	}
+
+	return &VerifyEmailOutput{Body: newUser(&user)}, nil
+}
+
+// ResendVerification sends a new verification link to the authenticated user
+func (uc *UserController) ResendVerification(ctx context.Context, input *ResendVerificationInput) (*struct{}, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.EmailVerifiedAt != nil {
+		return nil, huma.Error409Conflict("Email address is already verified")
+	}
+
+	if err := uc.Verifier.SendVerification(ctx, user); err != nil {
+		return nil, huma.Error503ServiceUnavailable("Failed to send the verification email", err)
+	}
+	return &struct{}{}, nil
+}
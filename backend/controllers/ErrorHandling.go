@@ -2,13 +2,127 @@ package controllers
 
 import (
 	"errors"
+	"net/http"
+
 	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
 )
 
+// Error codes for problems that clients may want to handle specifically.
+// Other problems get a code derived from their status, see statusCodes.
+const (
+	CodeNotFound            = "not_found"
+	CodeAlreadyExists       = "already_exists"
+	CodeEmailTaken          = "email_taken"
+	CodeInvalidReference    = "invalid_reference"
+	CodeConstraintViolation = "constraint_violation"
+	CodeInvalidID           = "invalid_id"
+	CodeMalformedValue      = "malformed_value"
+	CodeSyncRunning         = "sync_running"
+	CodeURLRedacted         = "url_redacted"
+)
+
+// statusCodes are the default error codes of the statuses the API returns
+var statusCodes = map[int]string{
+	http.StatusBadRequest:            "bad_request",
+	http.StatusUnauthorized:          "unauthorized",
+	http.StatusForbidden:             "forbidden",
+	http.StatusNotFound:              CodeNotFound,
+	http.StatusMethodNotAllowed:      "method_not_allowed",
+	http.StatusNotAcceptable:         "not_acceptable",
+	http.StatusConflict:              "conflict",
+	http.StatusGone:                  "gone",
+	http.StatusPreconditionFailed:    "precondition_failed",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "unsupported_media_type",
+	http.StatusUnprocessableEntity:   "validation_failed",
+	http.StatusTooManyRequests:       "rate_limited",
+	http.StatusInternalServerError:   "internal_error",
+	http.StatusBadGateway:            "bad_gateway",
+	http.StatusServiceUnavailable:    "unavailable",
+	http.StatusGatewayTimeout:        "gateway_timeout",
+}
+
+// uniqueConstraints describe the unique violations with a more specific
+// problem than the generic conflict, by constraint name
+var uniqueConstraints = map[string]struct{ code, message string }{
+	"idx_users_email": {CodeEmailTaken, "This email address is already in use"},
+}
+
+// Problem is an RFC 7807 problem details response with a stable
+// machine-readable error code
+type Problem struct {
+	huma.ErrorModel
+	Code string `json:"code" example:"not_found" doc:"A stable machine-readable error code"`
+}
+
+// defaultNewError is huma's own error constructor
+var defaultNewError = huma.NewError
+
+func init() {
+	// Every error the API writes, including huma's validation errors, is a Problem
+	huma.NewError = func(status int, msg string, errs ...error) huma.StatusError {
+		return newProblem(status, statusCode(status), msg, errs...)
+	}
+}
+
+// statusCode returns the default error code of a status
+func statusCode(status int) string {
+	if code, ok := statusCodes[status]; ok {
+		return code
+	}
+	return "error"
+}
+
+// newProblem creates a problem with a specific error code
+func newProblem(status int, code, msg string, errs ...error) *Problem {
+	problem := &Problem{Code: code}
+	if model, ok := defaultNewError(status, msg, errs...).(*huma.ErrorModel); ok {
+		problem.ErrorModel = *model
+	} else {
+		problem.ErrorModel = huma.ErrorModel{Status: status, Title: http.StatusText(status), Detail: msg}
+	}
+	return problem
+}
+
+// ParseResourceID parses the UUID of a resource given at location in the request
+func ParseResourceID(location, id string) (uuid.UUID, error) {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return uuid.Nil, newProblem(http.StatusUnprocessableEntity, CodeInvalidID, "Invalid resource ID", &huma.ErrorDetail{
+			Location: location,
+			Message:  "Expected a UUID",
+			Value:    id,
+		})
+	}
+	return parsed, nil
+}
+
+// ErrorGormToHuma translates database errors into problems. Errors that
+// already are problems pass through, and unknown errors become a 500.
 func ErrorGormToHuma(err error) error {
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return huma.Error404NotFound("Not found", err)
+		return newProblem(http.StatusNotFound, CodeNotFound, "Not found", err)
+	}
+
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return err
+	}
+	switch pgErr.Code {
+	case "23505": // unique_violation
+		if constraint, ok := uniqueConstraints[pgErr.ConstraintName]; ok {
+			return newProblem(http.StatusConflict, constraint.code, constraint.message)
+		}
+		return newProblem(http.StatusConflict, CodeAlreadyExists, "The resource already exists")
+	case "23503": // foreign_key_violation
+		return newProblem(http.StatusUnprocessableEntity, CodeInvalidReference, "The request refers to a resource that doesn't exist or is still in use")
+	case "23514", "23502": // check_violation, not_null_violation
+		return newProblem(http.StatusUnprocessableEntity, CodeConstraintViolation, "The request violates a constraint")
+	case "22P02", "22007", "22008", "22003": // invalid_text_representation, invalid_datetime_format, datetime_field_overflow, numeric_value_out_of_range
+		return newProblem(http.StatusBadRequest, CodeMalformedValue, "The request contains a malformed value")
 	}
 	return err
 }
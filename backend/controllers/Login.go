@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"awesomeProject/oidc"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"gorm.io/gorm"
+)
+
+// TokenResponse represents the credentials returned after a successful authentication
+type TokenResponse struct {
+	AccessToken string    `json:"accessToken" example:"eyJhbGciOiJIUzI1NiIsInR5cCI6IkpXVCJ9..." doc:"The JWT to send as a bearer token"`
+	TokenType   string    `json:"tokenType" example:"Bearer" doc:"The type of the access token"`
+	ExpiresAt   time.Time `json:"expiresAt" format:"date-time" example:"2023-12-01T12:15:00Z" doc:"The time when the access token expires"`
+
+	RefreshToken          string    `json:"refreshToken,omitempty" example:"q3v6...Zt8" doc:"Single-use token to obtain a new access token; it is rotated on every use"`
+	RefreshTokenExpiresAt time.Time `json:"refreshTokenExpiresAt,omitempty" format:"date-time" example:"2023-12-31T12:00:00Z" doc:"The time when the session ends unless refreshed"`
+}
+
+// LoginInput represents the input for logging in with email and password
+type LoginInput struct {
+	UserAgent string `header:"User-Agent" doc:"Recorded on the created session"`
+	Body      struct {
+		Email    string `json:"email" format:"email" example:"user@example.com" doc:"User's email address"`
+		Password string `json:"password" format:"password" example:"StrongPass!123" doc:"User's password"`
+	}
+}
+
+// LoginResponse represents the result of the first login step. When the user
+// has two-factor authentication enabled, no tokens are issued yet and the
+// mfaToken has to be sent to login-2fa along with a code.
+type LoginResponse struct {
+	TokenResponse
+	MfaRequired bool   `json:"mfaRequired" example:"false" doc:"Whether a second factor is needed to complete the login"`
+	MfaToken    string `json:"mfaToken,omitempty" doc:"Short-lived token to send to the second login step"`
+}
+
+// LoginOutput represents the output for logging in
+type LoginOutput struct {
+	Body LoginResponse
+}
+
+// AuthController handles authentication operations
+type AuthController struct {
+	DB        *gorm.DB
+	Config    *AuthConfig
+	Passwords *PasswordHasher
+	// OidcProviders are the identity providers users can log in with
+	OidcProviders []*oidc.Provider
+	// PasswordLoginDisabled restricts logins to the OIDC providers
+	PasswordLoginDisabled bool
+}
+
+// errPasswordLoginDisabled is returned by password operations when only OIDC logins are allowed
+func errPasswordLoginDisabled() error {
+	return huma.Error403Forbidden("Password login is disabled, please log in through your identity provider")
+}
+
+// Login checks the user's credentials and starts a new session, unless a
+// second factor is required first
+func (ac *AuthController) Login(ctx context.Context, input *LoginInput) (*LoginOutput, error) {
+	if ac.PasswordLoginDisabled {
+		return nil, errPasswordLoginDisabled()
+	}
+	user, err := ac.authenticatePassword(ctx, input.Body.Email, input.Body.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &LoginOutput{}
+	if user.TotpEnabledAt != nil {
+		mfaToken, mfaLoginID, err := ac.Config.issuePurposeToken(PurposeMfaLogin, user, mfaLoginTTL)
+		if err != nil {
+			return nil, err
+		}
+		// Only the latest token can complete the login, see LoginTwoFactor
+		err = ac.DB.WithContext(ctx).Model(user).UpdateColumns(map[string]interface{}{
+			"mfa_login_id":    mfaLoginID,
+			"mfa_login_tries": 0,
+		}).Error
+		if err != nil {
+			return nil, ErrorGormToHuma(err)
+		}
+		resp.Body.MfaRequired = true
+		resp.Body.MfaToken = mfaToken
+		return resp, nil
+	}
+
+	tokens, err := ac.startSession(ctx, user, input.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.TokenResponse = *tokens
+	return resp, nil
+}
+
+// authenticatePassword returns the user matching the credentials, upgrading
+// the stored hash when it was produced with outdated cost parameters
+func (ac *AuthController) authenticatePassword(ctx context.Context, email, password string) (*models.User, error) {
+	invalid := huma.Error401Unauthorized("Invalid email or password")
+
+	var user models.User
+	if err := ac.DB.WithContext(ctx).Where("lower(email) = lower(?)", email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Spend the same time as a real verification so unknown emails can't be probed
+			_, _ = ac.Passwords.Hash(password)
+			return nil, invalid
+		}
+		return nil, ErrorGormToHuma(err)
+	}
+
+	match, needsRehash, err := ac.Passwords.Verify(password, user.PasswordHash)
+	if err != nil && !errors.Is(err, ErrInvalidPasswordHash) {
+		return nil, err
+	}
+	if !match {
+		return nil, invalid
+	}
+	if user.Disabled() {
+		return nil, huma.Error401Unauthorized("Account is disabled")
+	}
+
+	if needsRehash {
+		hash, err := ac.Passwords.Hash(password)
+		if err != nil {
+			return nil, err
+		}
+		if err := ac.DB.WithContext(ctx).Model(&user).Update("password_hash", hash).Error; err != nil {
+			return nil, ErrorGormToHuma(err)
+		}
+	}
+
+	return &user, nil
+}
@@ -0,0 +1,254 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"awesomeProject/oidc"
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PurposeOidcLogin is the purpose of tokens carrying the state of an OIDC login
+const PurposeOidcLogin = "oidc-login"
+
+// oidcFlowTTL bounds the time the user has to log in at the provider
+const oidcFlowTTL = 10 * time.Minute
+
+// OidcProvider represents a configured OpenID Connect provider in the API
+type OidcProvider struct {
+	Name        string `json:"name" example:"corp" doc:"The name of the provider, used in the login paths"`
+	DisplayName string `json:"displayName" example:"Corporate SSO" doc:"A name to show on login buttons"`
+}
+
+// GetOidcProvidersInput represents the input for listing OIDC providers
+type GetOidcProvidersInput struct{}
+
+// GetOidcProvidersOutput represents the output for listing OIDC providers
+type GetOidcProvidersOutput struct {
+	Body struct {
+		Data                  []OidcProvider `json:"data"`
+		PasswordLoginDisabled bool           `json:"passwordLoginDisabled" doc:"Whether users can only log in through a provider"`
+	}
+}
+
+// StartOidcLoginInput represents the input for starting an OIDC login
+type StartOidcLoginInput struct {
+	Provider string `path:"provider" doc:"The name of the provider"`
+}
+
+// StartOidcLoginOutput represents the output for starting an OIDC login
+type StartOidcLoginOutput struct {
+	Body struct {
+		AuthorizationURL string `json:"authorizationUrl" format:"uri" doc:"The URL to send the user to"`
+		FlowToken        string `json:"flowToken" doc:"Short-lived token to send back with the authorization code. Keep it on the client, it must not go through the provider."`
+	}
+}
+
+// CompleteOidcLoginInput represents the input for completing an OIDC login
+type CompleteOidcLoginInput struct {
+	Provider  string `path:"provider" doc:"The name of the provider"`
+	UserAgent string `header:"User-Agent" doc:"Recorded on the created session"`
+	Body      struct {
+		Code      string `json:"code" doc:"The authorization code the provider passed to the redirect URL"`
+		State     string `json:"state" doc:"The state the provider passed to the redirect URL"`
+		FlowToken string `json:"flowToken" doc:"The token returned by start-oidc-login"`
+	}
+}
+
+// CompleteOidcLoginOutput represents the output for completing an OIDC login
+type CompleteOidcLoginOutput struct {
+	Body TokenResponse
+}
+
+// oidcFlowClaims carry the secrets of a login in progress between its two steps
+type oidcFlowClaims struct {
+	PurposeClaims
+	Provider     string `json:"provider"`
+	State        string `json:"state"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+}
+
+// oidcProvider looks up a configured provider by name
+func (ac *AuthController) oidcProvider(name string) (*oidc.Provider, error) {
+	for _, provider := range ac.OidcProviders {
+		if provider.Name == name {
+			return provider, nil
+		}
+	}
+	return nil, huma.Error404NotFound("Unknown identity provider " + name)
+}
+
+// GetOidcProviders lists the providers users can log in with
+func (ac *AuthController) GetOidcProviders(ctx context.Context, input *GetOidcProvidersInput) (*GetOidcProvidersOutput, error) {
+	resp := &GetOidcProvidersOutput{}
+	resp.Body.Data = make([]OidcProvider, len(ac.OidcProviders))
+	for i, provider := range ac.OidcProviders {
+		resp.Body.Data[i] = OidcProvider{Name: provider.Name, DisplayName: provider.DisplayName}
+	}
+	resp.Body.PasswordLoginDisabled = ac.PasswordLoginDisabled
+	return resp, nil
+}
+
+// StartOidcLogin creates the authorization URL of an authorization code flow with PKCE
+func (ac *AuthController) StartOidcLogin(ctx context.Context, input *StartOidcLoginInput) (*StartOidcLoginOutput, error) {
+	provider, err := ac.oidcProvider(input.Provider)
+	if err != nil {
+		return nil, err
+	}
+
+	claims := oidcFlowClaims{Provider: provider.Name}
+	for _, value := range []*string{&claims.State, &claims.Nonce, &claims.CodeVerifier} {
+		if *value, err = oidc.RandomString(); err != nil {
+			return nil, err
+		}
+	}
+
+	authURL, err := provider.AuthCodeURL(ctx, claims.State, claims.Nonce, oidc.S256Challenge(claims.CodeVerifier))
+	if err != nil {
+		return nil, huma.Error502BadGateway("The identity provider is unavailable", err)
+	}
+
+	now := time.Now()
+	claims.PurposeClaims = PurposeClaims{
+		AccessClaims: AccessClaims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				Issuer:    ac.Config.Issuer,
+				Audience:  jwt.ClaimStrings{ac.Config.Audience},
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: jwt.NewNumericDate(now.Add(oidcFlowTTL)),
+				ID:        uuid.New().String(),
+			},
+		},
+		Purpose: PurposeOidcLogin,
+	}
+	flowToken, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(ac.Config.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &StartOidcLoginOutput{}
+	resp.Body.AuthorizationURL = authURL
+	resp.Body.FlowToken = flowToken
+	return resp, nil
+}
+
+// CompleteOidcLogin redeems the authorization code and starts a session for
+// the user linked to the identity, linking or creating one if needed
+func (ac *AuthController) CompleteOidcLogin(ctx context.Context, input *CompleteOidcLoginInput) (*CompleteOidcLoginOutput, error) {
+	invalid := huma.Error401Unauthorized("Invalid or expired login, please start again")
+
+	provider, err := ac.oidcProvider(input.Provider)
+	if err != nil {
+		return nil, err
+	}
+	flow := &oidcFlowClaims{}
+	if err := ac.Config.parse(input.Body.FlowToken, flow); err != nil || flow.Purpose != PurposeOidcLogin {
+		return nil, invalid
+	}
+	if flow.Provider != provider.Name || flow.State != input.Body.State {
+		return nil, invalid
+	}
+
+	claims, err := provider.Exchange(ctx, input.Body.Code, flow.CodeVerifier)
+	if err != nil {
+		var tokenErr *oidc.TokenError
+		if errors.As(err, &tokenErr) {
+			return nil, invalid
+		}
+		log.Printf("OIDC login with %s failed: %v", provider.Name, err)
+		return nil, huma.Error502BadGateway("The identity provider is unavailable")
+	}
+	if claims.Nonce != flow.Nonce {
+		return nil, invalid
+	}
+
+	user, err := ac.oidcUser(ctx, provider, claims)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled() {
+		return nil, huma.Error401Unauthorized("Account is disabled")
+	}
+
+	tokens, err := ac.startSession(ctx, user, input.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &CompleteOidcLoginOutput{Body: *tokens}, nil
+}
+
+// oidcUser returns the user linked to a provider identity. Unknown identities
+// are linked to the account with the same verified email address, or get a
+// new account.
+func (ac *AuthController) oidcUser(ctx context.Context, provider *oidc.Provider, claims *oidc.Claims) (*models.User, error) {
+	var user models.User
+	err := ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		var identity models.OidcIdentity
+		err := tx.Where("issuer = ? AND subject = ?", provider.Issuer, claims.Subject).First(&identity).Error
+		if err == nil {
+			if err := tx.Model(&identity).Update("last_login_at", now).Error; err != nil {
+				return err
+			}
+			return tx.First(&user, identity.UserID).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if claims.Email == "" || !claims.EmailVerified {
+			return huma.Error403Forbidden("The identity provider did not share a verified email address")
+		}
+
+		err = tx.Where("lower(email) = lower(?)", claims.Email).First(&user).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			user = models.User{
+				ResourceID:      uuid.New(),
+				Email:           claims.Email,
+				EmailVerifiedAt: &now,
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case user.EmailVerifiedAt == nil:
+			// Nobody proved owning this account's address before, so whoever
+			// registered it loses their password, sessions and tokens
+			user.PasswordHash = ""
+			user.EmailVerifiedAt = &now
+			if err := tx.Model(&user).Updates(map[string]interface{}{
+				"password_hash":     "",
+				"email_verified_at": now,
+			}).Error; err != nil {
+				return err
+			}
+			if err := RevokeSessions(tx, user.ID, nil); err != nil {
+				return err
+			}
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.ApiToken{}).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.Create(&models.OidcIdentity{
+			UserID:      user.ID,
+			Issuer:      provider.Issuer,
+			Subject:     claims.Subject,
+			LastLoginAt: now,
+		}).Error
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &user, nil
+}
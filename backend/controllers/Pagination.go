@@ -7,3 +7,17 @@ type Pagination struct {
 	TotalItems int `json:"totalItems" doc:"The total number of items available." example:"123"`
 	TotalPages int `json:"totalPages" doc:"The total number of pages available." example:"7"`
 }
+
+// NewPagination computes the pagination information for a page of a result set
+func NewPagination(page, pageSize int, totalItems int64) Pagination {
+	totalPages := int(totalItems) / pageSize
+	if int(totalItems)%pageSize > 0 {
+		totalPages++
+	}
+	return Pagination{
+		Page:       page,
+		PageSize:   pageSize,
+		TotalItems: int(totalItems),
+		TotalPages: totalPages,
+	}
+}
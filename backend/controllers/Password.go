@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// ErrInvalidPasswordHash is returned when a stored hash cannot be decoded
+var ErrInvalidPasswordHash = errors.New("invalid password hash")
+
+// PasswordHasher hashes and verifies passwords with argon2id.
+// Hashes are stored in the PHC string format, so their cost parameters travel
+// with them and can be compared with the current configuration.
+type PasswordHasher struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultPasswordHasher returns a hasher using the OWASP recommended argon2id parameters
+func DefaultPasswordHasher() *PasswordHasher {
+	return &PasswordHasher{
+		Memory:      64 * 1024,
+		Iterations:  3,
+		Parallelism: 2,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// Hash returns the encoded argon2id hash of the password
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, h.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify checks the password against an encoded hash.
+// needsRehash is true when the password matches but the hash was produced with
+// cost parameters different from the hasher's current ones.
+func (h *PasswordHasher) Verify(password, encoded string) (match bool, needsRehash bool, err error) {
+	params, salt, key, err := decodeArgon2idHash(encoded)
+	if err != nil {
+		return false, false, err
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash = params.Memory != h.Memory ||
+		params.Iterations != h.Iterations ||
+		params.Parallelism != h.Parallelism ||
+		uint32(len(salt)) != h.SaltLength ||
+		uint32(len(key)) != h.KeyLength
+	return true, needsRehash, nil
+}
+
+// decodeArgon2idHash parses a `$argon2id$v=19$m=...,t=...,p=...$salt$key` string
+func decodeArgon2idHash(encoded string) (*PasswordHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	params := &PasswordHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return nil, nil, nil, ErrInvalidPasswordHash
+	}
+	return params, salt, key, nil
+}
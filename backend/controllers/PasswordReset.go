@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"awesomeProject/mailer"
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"gorm.io/gorm"
+)
+
+// RequestPasswordResetInput represents the input for requesting a password reset
+type RequestPasswordResetInput struct {
+	Body struct {
+		Email string `json:"email" format:"email" example:"user@example.com" doc:"The email address of the account to recover"`
+	}
+}
+
+// ResetPasswordInput represents the input for performing a password reset
+type ResetPasswordInput struct {
+	Body struct {
+		Token    string `json:"token" doc:"The token from the password reset email"`
+		Password string `json:"password" format:"password" minLength:"8" maxLength:"128" example:"NewPass123!" doc:"The new password"`
+	}
+}
+
+// PasswordResetController handles account recovery by email
+type PasswordResetController struct {
+	DB        *gorm.DB
+	Passwords *PasswordHasher
+	Mailer    mailer.Mailer
+	// PublicURL is the externally reachable base URL of the app, used to build links
+	PublicURL string
+	TTL       time.Duration
+	// PasswordLoginDisabled turns off password resets along with password logins
+	PasswordLoginDisabled bool
+}
+
+// RequestPasswordReset emails a reset link if the address belongs to an account.
+// The response is the same either way, so it can't be used to probe for accounts.
+func (pc *PasswordResetController) RequestPasswordReset(ctx context.Context, input *RequestPasswordResetInput) (*struct{}, error) {
+	if pc.PasswordLoginDisabled {
+		return nil, errPasswordLoginDisabled()
+	}
+	accepted := &struct{}{}
+
+	var user models.User
+	if err := pc.DB.WithContext(ctx).Where("lower(email) = lower(?)", input.Body.Email).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return accepted, nil
+		}
+		return nil, ErrorGormToHuma(err)
+	}
+
+	token, tokenHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+	resetToken := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(pc.TTL),
+	}
+	if err := pc.DB.WithContext(ctx).Create(&resetToken).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	// Deliver in the background so the response time doesn't reveal the account either
+	link := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimRight(pc.PublicURL, "/"), url.QueryEscape(token))
+	msg := mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: "Someone asked to reset the password of your account. To choose a new password, open the link below:\n\n" +
+			link + "\n\n" +
+			fmt.Sprintf("The link can be used once and expires in %s. If you didn't ask for this, you can ignore this email.\n", pc.TTL),
+	}
+	go func() {
+		sendCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := pc.Mailer.Send(sendCtx, msg); err != nil {
+			log.Printf("Failed to send password reset email to user %s: %v", user.ResourceID, err)
+		}
+	}()
+
+	return accepted, nil
+}
+
+// ResetPassword sets a new password using a reset token, then signs the user
+// out everywhere and invalidates any other outstanding reset tokens
+func (pc *PasswordResetController) ResetPassword(ctx context.Context, input *ResetPasswordInput) (*struct{}, error) {
+	if pc.PasswordLoginDisabled {
+		return nil, errPasswordLoginDisabled()
+	}
+	invalid := huma.Error400BadRequest("Invalid or expired reset token")
+
+	passwordHash, err := pc.Passwords.Hash(input.Body.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	err = pc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var resetToken models.PasswordResetToken
+		if err := tx.Where("token_hash = ?", hashToken(input.Body.Token)).First(&resetToken).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return invalid
+			}
+			return err
+		}
+		now := time.Now()
+		if !resetToken.Usable(now) {
+			return invalid
+		}
+
+		// Redeem the token; the condition guards against concurrent use
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", resetToken.UserID).
+			Update("used_at", now)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return invalid
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", resetToken.UserID).Update("password_hash", passwordHash).Error; err != nil {
+			return err
+		}
+		return RevokeSessions(tx, resetToken.UserID, nil)
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
@@ -0,0 +1,252 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"golang.org/x/text/language"
+)
+
+// weekdays are the API names of the days of the week, indexed by time.Weekday
+var weekdays = []string{"sunday", "monday", "tuesday", "wednesday", "thursday", "friday", "saturday"}
+
+// WorkingPeriod represents a recurring range of working time in the API
+type WorkingPeriod struct {
+	Day   string `json:"day" enum:"monday,tuesday,wednesday,thursday,friday,saturday,sunday" example:"monday" doc:"The day of the week"`
+	Start string `json:"start" pattern:"^([01][0-9]|2[0-3]):[0-5][0-9]$" example:"09:00" doc:"The local time when work starts"`
+	End   string `json:"end" pattern:"^(([01][0-9]|2[0-3]):[0-5][0-9]|24:00)$" example:"17:00" doc:"The local time when work ends, 24:00 for midnight"`
+}
+
+// Profile represents the preferences of a user
+type Profile struct {
+	DisplayName  string          `json:"displayName" example:"Ada Lovelace" doc:"The name shown to people viewing the user's invites"`
+	Timezone     string          `json:"timezone" example:"Europe/Amsterdam" doc:"The IANA timezone agendas are shown in"`
+	Locale       string          `json:"locale" example:"nl-NL" doc:"The BCP 47 language tag used for rendering"`
+	WeekStart    string          `json:"weekStart" enum:"monday,tuesday,wednesday,thursday,friday,saturday,sunday" example:"monday" doc:"The first day of the week"`
+	WorkingHours []WorkingPeriod `json:"workingHours" doc:"The weekly working hours template, in the user's timezone"`
+}
+
+// GetProfileInput represents the input for getting a user's profile
+type GetProfileInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+}
+
+// ProfileUpdate holds the preferences to change. Omitted fields are left unchanged.
+type ProfileUpdate struct {
+	DisplayName  *string         `json:"displayName,omitempty" maxLength:"100" example:"Ada Lovelace" doc:"The name shown to people viewing the user's invites, empty to show none"`
+	Timezone     string          `json:"timezone,omitempty" maxLength:"64" example:"Europe/Amsterdam" doc:"The IANA timezone agendas are shown in"`
+	Locale       string          `json:"locale,omitempty" maxLength:"35" example:"nl-NL" doc:"The BCP 47 language tag used for rendering"`
+	WeekStart    string          `json:"weekStart,omitempty" enum:"monday,tuesday,wednesday,thursday,friday,saturday,sunday" example:"monday" doc:"The first day of the week"`
+	WorkingHours []WorkingPeriod `json:"workingHours,omitempty" maxItems:"50" doc:"The weekly working hours template, replacing the current one"`
+}
+
+// UpdateProfileInput represents the input for updating a user's profile
+type UpdateProfileInput struct {
+	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+	Body ProfileUpdate
+}
+
+// ProfileOutput represents the output of the profile operations
+type ProfileOutput struct {
+	Body Profile
+}
+
+// newProfile converts a user's stored preferences into their API representation
+func newProfile(user *models.User) Profile {
+	return Profile{
+		DisplayName:  user.DisplayName,
+		Timezone:     user.Location().String(),
+		Locale:       user.Locale,
+		WeekStart:    weekdays[user.WeekStart],
+		WorkingHours: newWorkingHours(user.EffectiveWorkingHours()),
+	}
+}
+
+// newWorkingHours converts a working hours template into its API representation
+func newWorkingHours(hours models.WorkingHours) []WorkingPeriod {
+	out := make([]WorkingPeriod, len(hours))
+	for i, period := range hours {
+		out[i] = WorkingPeriod{
+			Day:   weekdays[period.Weekday],
+			Start: formatClock(period.Start),
+			End:   formatClock(period.End),
+		}
+	}
+	return out
+}
+
+// formatClock formats minutes since midnight as HH:MM
+func formatClock(minutes int) string {
+	return fmt.Sprintf("%02d:%02d", minutes/60, minutes%60)
+}
+
+// parseClock parses a HH:MM time of day into minutes since midnight
+func parseClock(value string) (int, bool) {
+	var hours, minutes int
+	if _, err := fmt.Sscanf(value, "%02d:%02d", &hours, &minutes); err != nil {
+		return 0, false
+	}
+	if hours < 0 || minutes < 0 || minutes > 59 || hours > 24 || (hours == 24 && minutes > 0) {
+		return 0, false
+	}
+	return hours*60 + minutes, true
+}
+
+// parseWeekday parses the API name of a day of the week
+func parseWeekday(value string) (time.Weekday, bool) {
+	for i, name := range weekdays {
+		if name == value {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseTimezone validates an IANA timezone name and returns its canonical form
+func parseTimezone(location, value string) (string, error) {
+	// "Local" would depend on the server's configuration
+	if value == "" || value == "Local" {
+		return "", profileError(location, "Unknown timezone", value)
+	}
+	loc, err := time.LoadLocation(value)
+	if err != nil {
+		return "", profileError(location, "Unknown timezone", value)
+	}
+	return loc.String(), nil
+}
+
+// parseWorkingHours validates a working hours template. The periods of a day
+// must not overlap; they are returned sorted by day and start time.
+func parseWorkingHours(location string, periods []WorkingPeriod) (models.WorkingHours, error) {
+	hours := make(models.WorkingHours, len(periods))
+	for i, period := range periods {
+		itemLocation := fmt.Sprintf("%s[%d]", location, i)
+		day, ok := parseWeekday(period.Day)
+		if !ok {
+			return nil, profileError(itemLocation+".day", "Unknown day of the week", period.Day)
+		}
+		start, ok := parseClock(period.Start)
+		if !ok || start == 24*60 {
+			return nil, profileError(itemLocation+".start", "Expected a time of day like 09:00", period.Start)
+		}
+		end, ok := parseClock(period.End)
+		if !ok {
+			return nil, profileError(itemLocation+".end", "Expected a time of day like 17:00", period.End)
+		}
+		if end <= start {
+			return nil, profileError(itemLocation+".end", "Working time must end after it starts", period.End)
+		}
+		hours[i] = models.WorkingPeriod{Weekday: day, Start: start, End: end}
+	}
+
+	sort.SliceStable(hours, func(i, j int) bool {
+		if hours[i].Weekday != hours[j].Weekday {
+			return hours[i].Weekday < hours[j].Weekday
+		}
+		return hours[i].Start < hours[j].Start
+	})
+	for i := 1; i < len(hours); i++ {
+		if hours[i].Weekday == hours[i-1].Weekday && hours[i].Start < hours[i-1].End {
+			return nil, profileError(location, "Working periods overlap on "+weekdays[hours[i].Weekday], nil)
+		}
+	}
+	return hours, nil
+}
+
+// profileError reports an invalid preference
+func profileError(location, message string, value any) error {
+	return huma.Error422UnprocessableEntity("Invalid preferences", &huma.ErrorDetail{
+		Location: location,
+		Message:  message,
+		Value:    value,
+	})
+}
+
+// GetProfile returns the preferences of a user
+func (uc *UserController) GetProfile(ctx context.Context, input *GetProfileInput) (*ProfileOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := uc.DB.WithContext(ctx).Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &ProfileOutput{Body: newProfile(&user)}, nil
+}
+
+// UpdateProfile validates and stores the preferences of a user
+func (uc *UserController) UpdateProfile(ctx context.Context, input *UpdateProfileInput) (*ProfileOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := uc.DB.WithContext(ctx).Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	updates, err := input.Body.apply(&user, "body")
+	if err != nil {
+		return nil, err
+	}
+	if len(updates) > 0 {
+		if err := uc.DB.WithContext(ctx).Model(&user).Updates(updates).Error; err != nil {
+			return nil, ErrorGormToHuma(err)
+		}
+	}
+	return &ProfileOutput{Body: newProfile(&user)}, nil
+}
+
+// apply validates the update and sets the changed preferences on the user.
+// It returns the changed columns for saving them.
+func (p *ProfileUpdate) apply(user *models.User, location string) (map[string]interface{}, error) {
+	var err error
+	updates := map[string]interface{}{}
+	if p.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*p.DisplayName)
+		updates["display_name"] = user.DisplayName
+	}
+	if p.Timezone != "" {
+		if user.Timezone, err = parseTimezone(location+".timezone", p.Timezone); err != nil {
+			return nil, err
+		}
+		updates["timezone"] = user.Timezone
+	}
+	if p.Locale != "" {
+		tag, err := language.Parse(p.Locale)
+		if err != nil {
+			return nil, profileError(location+".locale", "Expected a BCP 47 language tag like en-GB", p.Locale)
+		}
+		user.Locale = tag.String()
+		updates["locale"] = user.Locale
+	}
+	if p.WeekStart != "" {
+		day, ok := parseWeekday(p.WeekStart)
+		if !ok {
+			return nil, profileError(location+".weekStart", "Unknown day of the week", p.WeekStart)
+		}
+		user.WeekStart = day
+		updates["week_start"] = day
+	}
+	if p.WorkingHours != nil {
+		if user.WorkingHours, err = parseWorkingHours(location+".workingHours", p.WorkingHours); err != nil {
+			return nil, err
+		}
+		updates["working_hours"] = user.WorkingHours
+	}
+	return updates, nil
+}
@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RefreshTokenInput represents the input for exchanging a refresh token
+type RefreshTokenInput struct {
+	Body struct {
+		RefreshToken string `json:"refreshToken" doc:"The refresh token returned by the last login or refresh"`
+	}
+}
+
+// RefreshTokenOutput represents the output for exchanging a refresh token
+type RefreshTokenOutput struct {
+	Body TokenResponse
+}
+
+// LogoutInput represents the input for logging out the current session
+type LogoutInput struct{}
+
+// LogoutAllInput represents the input for signing out of every session
+type LogoutAllInput struct{}
+
+// newOpaqueToken returns a random URL-safe token and its SHA-256 hash
+func newOpaqueToken() (string, string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, hashToken(token), nil
+}
+
+// hashToken returns the hex SHA-256 of an opaque token, the only form we persist
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// startSession creates a session for the user and returns its tokens
+func (ac *AuthController) startSession(ctx context.Context, user *models.User, userAgent string) (*TokenResponse, error) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := models.Session{
+		ResourceID:       uuid.New(),
+		UserID:           user.ID,
+		RefreshTokenHash: refreshHash,
+		UserAgent:        userAgent,
+		ExpiresAt:        now.Add(ac.Config.RefreshTokenTTL),
+		LastUsedAt:       now,
+	}
+	if err := ac.DB.WithContext(ctx).Create(&session).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	return ac.sessionTokens(user, &session, refreshToken)
+}
+
+// sessionTokens builds the token response for a session and its current refresh token
+func (ac *AuthController) sessionTokens(user *models.User, session *models.Session, refreshToken string) (*TokenResponse, error) {
+	accessToken, expiresAt, err := ac.Config.IssueSessionAccessToken(user, session)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenResponse{
+		AccessToken:           accessToken,
+		TokenType:             "Bearer",
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: session.ExpiresAt,
+	}, nil
+}
+
+// RefreshToken rotates a refresh token and returns a new access token.
+// Presenting an already rotated token revokes the whole session, since it
+// means the token was copied.
+func (ac *AuthController) RefreshToken(ctx context.Context, input *RefreshTokenInput) (*RefreshTokenOutput, error) {
+	invalid := huma.Error401Unauthorized("Invalid refresh token")
+	presentedHash := hashToken(input.Body.RefreshToken)
+	now := time.Now()
+
+	var session models.Session
+	err := ac.DB.WithContext(ctx).Where("refresh_token_hash = ?", presentedHash).First(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Reuse of a rotated token: revoke the session it belonged to
+		ac.DB.WithContext(ctx).Model(&models.Session{}).
+			Where("previous_refresh_token_hash = ? AND revoked_at IS NULL", presentedHash).
+			Update("revoked_at", now)
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	if !session.Active(now) {
+		return nil, invalid
+	}
+
+	var user models.User
+	if err := ac.DB.WithContext(ctx).First(&user, session.UserID).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	if user.Disabled() {
+		return nil, invalid
+	}
+
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	// Only rotate if nobody else rotated the same token concurrently
+	result := ac.DB.WithContext(ctx).Model(&session).
+		Where("refresh_token_hash = ?", presentedHash).
+		Updates(map[string]interface{}{
+			"refresh_token_hash":          refreshHash,
+			"previous_refresh_token_hash": presentedHash,
+			"last_used_at":                now,
+		})
+	if result.Error != nil {
+		return nil, ErrorGormToHuma(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, invalid
+	}
+
+	tokens, err := ac.sessionTokens(&user, &session, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &RefreshTokenOutput{Body: *tokens}, nil
+}
+
+// Logout revokes the session the caller's access token belongs to
+func (ac *AuthController) Logout(ctx context.Context, input *LogoutInput) (*struct{}, error) {
+	session, ok := SessionFromContext(ctx)
+	if !ok {
+		return nil, huma.Error400BadRequest("The access token is not bound to a session")
+	}
+
+	if err := ac.DB.WithContext(ctx).Model(session).Update("revoked_at", time.Now()).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// LogoutAll revokes every session of the caller, signing them out everywhere
+func (ac *AuthController) LogoutAll(ctx context.Context, input *LogoutAllInput) (*struct{}, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := RevokeSessions(ac.DB.WithContext(ctx), user.ID, nil); err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// RevokeSessions revokes all active sessions of a user except the given one
+func RevokeSessions(db *gorm.DB, userID uint, except *models.Session) error {
+	query := db.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if except != nil {
+		query = query.Where("id <> ?", except.ID)
+	}
+	return query.Update("revoked_at", time.Now()).Error
+}
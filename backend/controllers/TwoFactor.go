@@ -0,0 +1,285 @@
+package controllers
+
+import (
+	"awesomeProject/models"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/danielgtaylor/huma/v2"
+	"gorm.io/gorm"
+)
+
+// PurposeMfaLogin is the purpose of tokens proving the first login step succeeded
+const PurposeMfaLogin = "mfa-login"
+
+// mfaLoginTTL bounds the time between entering the password and the second factor
+const mfaLoginTTL = 5 * time.Minute
+
+// mfaLoginTries is how many codes can be entered with one MFA token
+const mfaLoginTries = 5
+
+// recoveryCodeCount is the number of recovery codes handed out on enrollment
+const recoveryCodeCount = 10
+
+// errInvalidSecondFactor is returned when a TOTP or recovery code is not accepted
+var errInvalidSecondFactor = errors.New("invalid second factor code")
+
+// EnrollTwoFactorInput represents the input for starting TOTP enrollment
+type EnrollTwoFactorInput struct{}
+
+// EnrollTwoFactorOutput represents the output for starting TOTP enrollment
+type EnrollTwoFactorOutput struct {
+	Body struct {
+		Secret     string `json:"secret" example:"JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP" doc:"The base32 TOTP secret to enter in an authenticator app"`
+		OtpauthURI string `json:"otpauthUri" example:"otpauth://totp/proton-agenda:user%40example.com?secret=..." doc:"The secret as an otpauth:// URI, to show as a QR code"`
+	}
+}
+
+// TwoFactorCodeInput represents an input carrying a code from the authenticator app
+type TwoFactorCodeInput struct {
+	Body struct {
+		Code string `json:"code" example:"123456" doc:"A current TOTP code, or a recovery code where accepted"`
+	}
+}
+
+// ConfirmTwoFactorOutput represents the output for confirming TOTP enrollment
+type ConfirmTwoFactorOutput struct {
+	Body struct {
+		RecoveryCodes []string `json:"recoveryCodes" example:"[\"k3mfa-7qx2p\"]" doc:"Single-use codes to log in without the authenticator. They are only shown once."`
+	}
+}
+
+// LoginTwoFactorInput represents the input for the second login step
+type LoginTwoFactorInput struct {
+	UserAgent string `header:"User-Agent" doc:"Recorded on the created session"`
+	Body      struct {
+		MfaToken string `json:"mfaToken" doc:"The token returned by the first login step"`
+		Code     string `json:"code" example:"123456" doc:"A current TOTP code or an unused recovery code"`
+	}
+}
+
+// LoginTwoFactorOutput represents the output for the second login step
+type LoginTwoFactorOutput struct {
+	Body TokenResponse
+}
+
+// newRecoveryCode returns a random code formatted as two groups of five characters
+func newRecoveryCode() (string, error) {
+	raw := make([]byte, 7)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode makes codes comparable regardless of case and dashes
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+// EnrollTwoFactor generates a new TOTP secret for the caller.
+// Two-factor authentication is only enabled once a code is confirmed.
+func (ac *AuthController) EnrollTwoFactor(ctx context.Context, input *EnrollTwoFactorInput) (*EnrollTwoFactorOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabledAt != nil {
+		return nil, huma.Error409Conflict("Two-factor authentication is already enabled")
+	}
+
+	secret, err := newTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := ac.DB.WithContext(ctx).Model(user).Updates(map[string]interface{}{
+		"totp_secret":    secret,
+		"totp_last_step": 0,
+	}).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	resp := &EnrollTwoFactorOutput{}
+	resp.Body.Secret = secret
+	resp.Body.OtpauthURI = totpURI(ac.Config.Issuer, user.Email, secret)
+	return resp, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication once the caller proves
+// their authenticator works, and hands out recovery codes
+func (ac *AuthController) ConfirmTwoFactor(ctx context.Context, input *TwoFactorCodeInput) (*ConfirmTwoFactorOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabledAt != nil {
+		return nil, huma.Error409Conflict("Two-factor authentication is already enabled")
+	}
+	if user.TotpSecret == "" {
+		return nil, huma.Error409Conflict("Start the enrollment first")
+	}
+
+	now := time.Now()
+	step, ok := matchTotp(user.TotpSecret, input.Body.Code, now, user.TotpLastStep)
+	if !ok {
+		return nil, huma.Error422UnprocessableEntity("Invalid code")
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	err = ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_enabled_at": now,
+			"totp_last_step":  step,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for i := range codes {
+			code, err := newRecoveryCode()
+			if err != nil {
+				return err
+			}
+			codes[i] = code
+			if err := tx.Create(&models.RecoveryCode{UserID: user.ID, CodeHash: hashToken(normalizeRecoveryCode(code))}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	resp := &ConfirmTwoFactorOutput{}
+	resp.Body.RecoveryCodes = codes
+	return resp, nil
+}
+
+// DisableTwoFactor turns two-factor authentication off, given a valid code
+func (ac *AuthController) DisableTwoFactor(ctx context.Context, input *TwoFactorCodeInput) (*struct{}, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if user.TotpEnabledAt == nil {
+		return nil, huma.Error409Conflict("Two-factor authentication is not enabled")
+	}
+
+	err = ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, user, input.Body.Code); err != nil {
+			if errors.Is(err, errInvalidSecondFactor) {
+				return huma.Error422UnprocessableEntity("Invalid code")
+			}
+			return err
+		}
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":     "",
+			"totp_enabled_at": nil,
+			"totp_last_step":  0,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// LoginTwoFactor completes a login for users with two-factor authentication.
+// An MFA token completes one login and allows only a few wrong codes.
+func (ac *AuthController) LoginTwoFactor(ctx context.Context, input *LoginTwoFactorInput) (*LoginTwoFactorOutput, error) {
+	if ac.PasswordLoginDisabled {
+		return nil, errPasswordLoginDisabled()
+	}
+	expired := huma.Error401Unauthorized("Invalid or expired login, please start again")
+	claims, err := ac.Config.ParsePurposeToken(PurposeMfaLogin, input.Body.MfaToken)
+	if err != nil || claims.ID == "" {
+		return nil, expired
+	}
+
+	var user models.User
+	if err := ac.DB.WithContext(ctx).Where("resource_id = ?", claims.Subject).First(&user).Error; err != nil {
+		return nil, expired
+	}
+	if user.TotpEnabledAt == nil || user.Disabled() {
+		return nil, expired
+	}
+
+	// Count the try before checking the code, so that parallel requests can't
+	// get past the limit
+	pending := ac.DB.WithContext(ctx).Model(&models.User{}).Where("id = ? AND mfa_login_id = ?", user.ID, claims.ID)
+	result := pending.Where("mfa_login_tries < ?", mfaLoginTries).UpdateColumn("mfa_login_tries", gorm.Expr("mfa_login_tries + 1"))
+	if result.Error != nil {
+		return nil, ErrorGormToHuma(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return nil, expired
+	}
+
+	err = ac.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := verifySecondFactor(tx, &user, input.Body.Code); err != nil {
+			return err
+		}
+		// The token is used up, unless another request used it first
+		result := tx.Model(&models.User{}).Where("id = ? AND mfa_login_id = ?", user.ID, claims.ID).
+			UpdateColumns(map[string]interface{}{"mfa_login_id": "", "mfa_login_tries": 0})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return expired
+		}
+		return nil
+	})
+	if errors.Is(err, errInvalidSecondFactor) {
+		return nil, huma.Error401Unauthorized("Invalid code")
+	}
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+
+	tokens, err := ac.startSession(ctx, &user, input.UserAgent)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginTwoFactorOutput{Body: *tokens}, nil
+}
+
+// verifySecondFactor accepts a TOTP code or consumes an unused recovery code
+func verifySecondFactor(tx *gorm.DB, user *models.User, code string) error {
+	if step, ok := matchTotp(user.TotpSecret, code, time.Now(), user.TotpLastStep); ok {
+		// Advance the last step only if no concurrent request used this code first
+		result := tx.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			Update("totp_last_step", step)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidSecondFactor
+		}
+		user.TotpLastStep = step
+		return nil
+	}
+
+	result := tx.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errInvalidSecondFactor
+	}
+	return nil
+}
@@ -2,26 +2,33 @@ package controllers
 
 import (
 	"awesomeProject/models"
+	"awesomeProject/sources"
 	"context"
+	"log"
 	"time"
 
+	"github.com/danielgtaylor/huma/v2"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id" format:"uuid" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the user"`
-	Email     string    `json:"email" format:"email" example:"user@example.com" doc:"The user's email address"`
-	CreatedAt time.Time `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the user was created"`
-	UpdatedAt time.Time `json:"updatedAt" format:"date-time" example:"2023-12-02T15:00:00Z" doc:"The last time the user's details were updated"`
+	ID               string     `json:"id" format:"uuid" example:"f47ac10b-58cc-4372-a567-0e02b2c3d479" doc:"The unique identifier of the user"`
+	Email            string     `json:"email" format:"email" example:"user@example.com" doc:"The user's email address"`
+	EmailVerified    bool       `json:"emailVerified" example:"true" doc:"Whether the user has confirmed their email address"`
+	TwoFactorEnabled bool       `json:"twoFactorEnabled" example:"false" doc:"Whether logging in requires a TOTP code"`
+	Role             string     `json:"role" enum:"user,admin" example:"user" doc:"The user's role"`
+	DisabledAt       *time.Time `json:"disabledAt,omitempty" format:"date-time" example:"2023-12-03T09:00:00Z" doc:"The time when an admin disabled the account, if disabled"`
+	CreatedAt        time.Time  `json:"createdAt" format:"date-time" example:"2023-12-01T12:00:00Z" doc:"The time when the user was created"`
+	UpdatedAt        time.Time  `json:"updatedAt" format:"date-time" example:"2023-12-02T15:00:00Z" doc:"The last time the user's details were updated"`
 }
 
 // RegisterUserInput represents the input for user registration
 type RegisterUserInput struct {
 	Body struct {
 		Email    string `json:"email" format:"email" example:"user@example.com" doc:"User's email address"`
-		Password string `json:"password" format:"password" example:"StrongPass!123" doc:"User's password"`
+		Password string `json:"password" format:"password" minLength:"8" maxLength:"128" example:"StrongPass!123" doc:"User's password"`
 	}
 }
 
@@ -35,7 +42,7 @@ type UpdateUserInput struct {
 	ID   string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
 	Body struct {
 		Email    string `json:"email,omitempty" format:"email" example:"newemail@example.com" doc:"User's new email address"`
-		Password string `json:"password,omitempty" format:"password" example:"NewPass123!" doc:"User's new password"`
+		Password string `json:"password,omitempty" format:"password" minLength:"8" maxLength:"128" example:"NewPass123!" doc:"User's new password"`
 	}
 }
 
@@ -44,16 +51,62 @@ type UpdateUserOutput struct {
 	Body User
 }
 
+// GetMeInput represents the input for getting the authenticated user
+type GetMeInput struct{}
+
+// GetUserInput represents the input for getting a user
+type GetUserInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+}
+
+// GetUserOutput represents the output for getting a user
+type GetUserOutput struct {
+	Body User
+}
+
+// DeleteUserInput represents the input for deleting a user
+type DeleteUserInput struct {
+	ID string `path:"id" format:"uuid" doc:"The unique identifier (UUID) of the user"`
+}
+
+// newUser converts a stored user into its API representation
+func newUser(user *models.User) User {
+	return User{
+		ID:               user.ResourceID.String(),
+		Email:            user.Email,
+		EmailVerified:    user.EmailVerifiedAt != nil,
+		TwoFactorEnabled: user.TotpEnabledAt != nil,
+		Role:             user.Role,
+		DisabledAt:       user.DisabledAt,
+		CreatedAt:        user.CreatedAt,
+		UpdatedAt:        user.UpdatedAt,
+	}
+}
+
 type UserController struct {
-	DB *gorm.DB
+	DB        *gorm.DB
+	Passwords *PasswordHasher
+	Verifier  *EmailVerifier
+	// Providers are the supported agenda source types, which imported sources are checked against
+	Providers *sources.Registry
+	// PasswordLoginDisabled turns off registration, accounts are created by OIDC logins instead
+	PasswordLoginDisabled bool
 }
 
 // CreateUser handles creating a new user
 func (uc *UserController) CreateUser(ctx context.Context, input *RegisterUserInput) (*RegisterUserOutput, error) {
+	if uc.PasswordLoginDisabled {
+		return nil, errPasswordLoginDisabled()
+	}
+	passwordHash, err := uc.Passwords.Hash(input.Body.Password)
+	if err != nil {
+		return nil, err
+	}
+
 	user := models.User{
 		ResourceID:   uuid.New(),
 		Email:        input.Body.Email,
-		PasswordHash: input.Body.Password,
+		PasswordHash: passwordHash,
 	}
 
 	if err := uc.DB.Create(&user).Error; err != nil {
@@ -61,37 +114,146 @@ func (uc *UserController) CreateUser(ctx context.Context, input *RegisterUserInp
 		// TODO: Maybe make it as something like a post-processing middleware?
 		return nil, ErrorGormToHuma(err)
 	}
+
+	// The account stays unverified until the link is confirmed; a failed
+	// delivery can be retried with resend-verification
+	if err := uc.Verifier.SendVerification(ctx, &user); err != nil {
+		log.Printf("Failed to send verification email to user %s: %v", user.ResourceID, err)
+	}
+
 	resp := &RegisterUserOutput{}
-	resp.Body.ID = user.ResourceID.String()
-	resp.Body.Email = user.Email
-	resp.Body.CreatedAt = user.CreatedAt
-	resp.Body.UpdatedAt = user.UpdatedAt
+	resp.Body = newUser(&user)
 	return resp, nil
 }
 
+// authorizeUser checks that the caller may access the account with the given ID.
+// Admins may access every account.
+func authorizeUser(caller *models.User, id string) error {
+	if caller.ResourceID.String() != id && !caller.IsAdmin() {
+		return huma.Error403Forbidden("You can only access your own account")
+	}
+	return nil
+}
+
+// GetMe returns the authenticated user
+func (uc *UserController) GetMe(ctx context.Context, input *GetMeInput) (*GetUserOutput, error) {
+	user, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &GetUserOutput{Body: newUser(user)}, nil
+}
+
+// GetUser returns a user by ID
+func (uc *UserController) GetUser(ctx context.Context, input *GetUserInput) (*GetUserOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := uc.DB.WithContext(ctx).Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &GetUserOutput{Body: newUser(&user)}, nil
+}
+
+// DeleteUser permanently deletes a user. The row is removed rather than soft
+// deleted so the database cascades to everything the user owns, including
+// sessions and tokens, which revokes all of their credentials.
+func (uc *UserController) DeleteUser(ctx context.Context, input *DeleteUserInput) (*struct{}, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
+	err = uc.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
+			return err
+		}
+		return deleteUser(tx, &user)
+	})
+	if err != nil {
+		return nil, ErrorGormToHuma(err)
+	}
+	return &struct{}{}, nil
+}
+
+// deleteUser hard deletes a user and, through the CASCADE constraints, all of
+// their data. Join table rows don't cascade, so they are removed first.
+func deleteUser(tx *gorm.DB, user *models.User) error {
+	invites := tx.Unscoped().Model(&models.AgendaInvite{}).Select("id").Where("user_id = ?", user.ID)
+	sources := tx.Unscoped().Model(&models.AgendaSource{}).Select("id").Where("user_id = ?", user.ID)
+	if err := tx.Exec("DELETE FROM invite_sources WHERE agenda_invite_id IN (?) OR agenda_source_id IN (?)", invites, sources).Error; err != nil {
+		return err
+	}
+	if err := tx.Exec("DELETE FROM invite_procedural_agendas WHERE agenda_invite_id IN (?)", invites).Error; err != nil {
+		return err
+	}
+	return tx.Unscoped().Delete(user).Error
+}
+
 func (uc *UserController) UpdateUser(ctx context.Context, input *UpdateUserInput) (*UpdateUserOutput, error) {
+	caller, err := CurrentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := authorizeUser(caller, input.ID); err != nil {
+		return nil, err
+	}
+
 	var user models.User
 	if err := uc.DB.Where("resource_id = ?", input.ID).First(&user).Error; err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
 
-	if input.Body.Email != "" {
+	emailChanged := false
+	if input.Body.Email != "" && input.Body.Email != user.Email {
 		user.Email = input.Body.Email
+		user.EmailVerifiedAt = nil
+		emailChanged = true
 	}
+	passwordChanged := false
 	if input.Body.Password != "" {
-		user.PasswordHash = input.Body.Password
+		passwordHash, err := uc.Passwords.Hash(input.Body.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = passwordHash
+		passwordChanged = true
 	}
 
-	if err := uc.DB.Save(&user).Error; err != nil {
+	err = uc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(&user).Error; err != nil {
+			return err
+		}
+		if passwordChanged {
+			// Keep the caller signed in but end every other session
+			current, _ := SessionFromContext(ctx)
+			return RevokeSessions(tx, user.ID, current)
+		}
+		return nil
+	})
+	if err != nil {
 		return nil, ErrorGormToHuma(err)
 	}
+
+	// A new address has to be verified again
+	if emailChanged {
+		if err := uc.Verifier.SendVerification(ctx, &user); err != nil {
+			log.Printf("Failed to send verification email to user %s: %v", user.ResourceID, err)
+		}
+	}
+
 	resp := &UpdateUserOutput{
-		Body: User{
-			ID:        user.ResourceID.String(),
-			Email:     user.Email,
-			CreatedAt: user.CreatedAt,
-			UpdatedAt: user.UpdatedAt,
-		},
+		Body: newUser(&user),
 	}
 	return resp, nil
 }
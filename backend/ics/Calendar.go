@@ -0,0 +1,176 @@
+// Package ics reads the events of iCalendar (RFC 5545) feeds
+package ics
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	ical "github.com/arran4/golang-ical"
+)
+
+// Event statuses
+const (
+	StatusTentative = "TENTATIVE"
+	StatusConfirmed = "CONFIRMED"
+	StatusCancelled = "CANCELLED"
+)
+
+// Event is a VEVENT of a calendar
+type Event struct {
+	UID      string
+	Summary  string
+	Location string
+	Notes    string // the DESCRIPTION of the event
+	Start    time.Time
+	End      time.Time
+	AllDay   bool // Start and End are dates rather than times
+	// Timezone is the timezone the event was scheduled in, empty for all-day
+	// events and floating times. Those are kept as UTC until Occurrences places
+	// them in a timezone.
+	Timezone string
+	Status   string // empty when the event doesn't tell
+	// Sequence and LastModified tell revisions of an event apart. LastModified
+	// is zero when the event doesn't tell.
+	Sequence     int
+	LastModified time.Time
+
+	// RRule is the recurrence rule of a recurring event, without the RRULE: prefix
+	RRule   string
+	RDates  []time.Time
+	ExDates []time.Time
+	// RecurrenceID is the original start of the instance an override replaces,
+	// zero for events that aren't overrides
+	RecurrenceID time.Time
+}
+
+// Calendar is a parsed iCalendar object
+type Calendar struct {
+	Name   string
+	Events []Event
+}
+
+// Parse reads an iCalendar object. Events that can't be placed in time are
+// left out rather than failing the whole calendar.
+func Parse(r io.Reader) (*Calendar, error) {
+	parsed, err := ical.ParseCalendar(r)
+	if err != nil {
+		return nil, fmt.Errorf("invalid iCalendar data: %w", err)
+	}
+
+	calendar := &Calendar{}
+	for _, prop := range parsed.CalendarProperties {
+		if prop.IANAToken == string(ical.PropertyXWRCalName) || prop.IANAToken == string(ical.PropertyName) {
+			calendar.Name = prop.Value
+		}
+	}
+	z := parseTimezones(parsed)
+	for _, vevent := range parsed.Events() {
+		event, err := parseEvent(vevent, z)
+		if err != nil {
+			continue
+		}
+		calendar.Events = append(calendar.Events, *event)
+	}
+	return calendar, nil
+}
+
+// parseEvent converts a VEVENT into an Event
+func parseEvent(vevent *ical.VEvent, z zones) (*Event, error) {
+	startProp := vevent.GetProperty(ical.ComponentPropertyDtStart)
+	if startProp == nil {
+		return nil, fmt.Errorf("event without DTSTART")
+	}
+	start, err := parseTime(startProp, z)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		Summary:  propertyValue(vevent, ical.ComponentPropertySummary),
+		Location: propertyValue(vevent, ical.ComponentPropertyLocation),
+		Notes:    propertyValue(vevent, ical.ComponentPropertyDescription),
+		Start:    start.Time,
+		AllDay:   start.Date,
+		Timezone: start.Timezone,
+		Status:   strings.ToUpper(propertyValue(vevent, ical.ComponentPropertyStatus)),
+	}
+	allDay := start.Date
+
+	switch {
+	case vevent.GetProperty(ical.ComponentPropertyDtEnd) != nil:
+		end, err := parseTime(vevent.GetProperty(ical.ComponentPropertyDtEnd), z)
+		if err != nil {
+			return nil, err
+		}
+		event.End = end.Time
+	case vevent.GetProperty(ical.ComponentPropertyDuration) != nil:
+		duration, err := parseDuration(propertyValue(vevent, ical.ComponentPropertyDuration))
+		if err != nil {
+			return nil, err
+		}
+		event.End = event.Start.Add(duration)
+	case allDay:
+		// An all-day event without an end takes the whole day
+		event.End = event.Start.AddDate(0, 0, 1)
+	default:
+		event.End = event.Start
+	}
+	if event.End.Before(event.Start) {
+		return nil, fmt.Errorf("event ends before it starts")
+	}
+
+	if sequence := propertyValue(vevent, ical.ComponentPropertySequence); sequence != "" {
+		event.Sequence, _ = strconv.Atoi(sequence)
+	}
+	if prop := vevent.GetProperty(ical.ComponentPropertyLastModified); prop != nil {
+		// A revision without a valid time is told apart by its sequence
+		if modified, err := parseTime(prop, z); err == nil {
+			event.LastModified = modified.Time.UTC()
+		}
+	}
+
+	event.RRule = strings.TrimPrefix(propertyValue(vevent, ical.ComponentPropertyRrule), "RRULE:")
+	for _, prop := range vevent.GetProperties(ical.ComponentPropertyRdate) {
+		dates, err := parseTimes(prop, z)
+		if err != nil {
+			return nil, err
+		}
+		event.RDates = append(event.RDates, dates...)
+	}
+	for _, prop := range vevent.GetProperties(ical.ComponentPropertyExdate) {
+		dates, err := parseTimes(prop, z)
+		if err != nil {
+			return nil, err
+		}
+		event.ExDates = append(event.ExDates, dates...)
+	}
+	if prop := vevent.GetProperty(ical.ComponentPropertyRecurrenceId); prop != nil {
+		recurrenceID, err := parseTime(prop, z)
+		if err != nil {
+			return nil, err
+		}
+		event.RecurrenceID = recurrenceID.Time
+	}
+
+	event.UID = propertyValue(vevent, ical.ComponentPropertyUniqueId)
+	if event.UID == "" {
+		// UID is required, but some feeds leave it out. Derive one that stays
+		// the same as long as the event does.
+		sum := sha256.Sum256([]byte(startProp.Value + "\n" + event.Summary))
+		event.UID = "generated-" + hex.EncodeToString(sum[:16])
+	}
+	return event, nil
+}
+
+// propertyValue returns the value of a property of a component, or an empty string
+func propertyValue(component *ical.VEvent, property ical.ComponentProperty) string {
+	if prop := component.GetProperty(property); prop != nil {
+		return strings.TrimSpace(prop.Value)
+	}
+	return ""
+}
@@ -0,0 +1,211 @@
+package ics
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/teambition/rrule-go"
+)
+
+// Occurrence is a single instance of an event in time
+type Occurrence struct {
+	Event
+	// ID identifies the occurrence within its calendar and stays the same
+	// across downloads: the UID for single events, the UID and the original
+	// start of the instance for recurring ones
+	ID string
+}
+
+// Recurring reports whether the event repeats
+func (e Event) Recurring() bool {
+	return e.RRule != "" || len(e.RDates) > 0
+}
+
+// Floating reports whether the times of the event are local to wherever it's
+// looked at, rather than fixed to a timezone
+func (e Event) Floating() bool {
+	return e.Timezone == ""
+}
+
+// In places the times of a floating event in a timezone
+func (e Event) In(loc *time.Location) Event {
+	if !e.Floating() {
+		return e
+	}
+	e.Start = localize(e.Start, loc)
+	e.End = localize(e.End, loc)
+	if !e.RecurrenceID.IsZero() {
+		e.RecurrenceID = localize(e.RecurrenceID, loc)
+	}
+	e.RDates = localizeAll(e.RDates, loc)
+	e.ExDates = localizeAll(e.ExDates, loc)
+	return e
+}
+
+// localizeAll localizes a list of times
+func localizeAll(times []time.Time, loc *time.Location) []time.Time {
+	if times == nil {
+		return nil
+	}
+	out := make([]time.Time, len(times))
+	for i, t := range times {
+		out[i] = localize(t, loc)
+	}
+	return out
+}
+
+// Occurrences expands the events of the calendar into the occurrences that
+// overlap the window from..to, sorted by start. All-day events and floating
+// times are placed in loc. Recurring events are expanded with their RRULE,
+// RDATEs and EXDATEs, and instances that were overridden by an event with a
+// RECURRENCE-ID are replaced by the override. Cancelled events and instances
+// are left out.
+func (c *Calendar) Occurrences(from, to time.Time, loc *time.Location) []Occurrence {
+	events := make([]Event, len(c.Events))
+	for i, event := range c.Events {
+		events[i] = event.In(loc)
+	}
+
+	// Overrides of instances of recurring events, by UID and original start
+	overrides := map[string]map[int64]Event{}
+	masters := map[string]bool{}
+	for _, event := range events {
+		if event.RecurrenceID.IsZero() {
+			masters[event.UID] = true
+			continue
+		}
+		if overrides[event.UID] == nil {
+			overrides[event.UID] = map[int64]Event{}
+		}
+		overrides[event.UID][event.RecurrenceID.Unix()] = event
+	}
+
+	var occurrences []Occurrence
+	seen := map[string]bool{}
+	add := func(occurrence Occurrence) {
+		// A feed can repeat an event; only its first copy counts
+		if seen[occurrence.ID] {
+			return
+		}
+		seen[occurrence.ID] = true
+		if occurrence.Status != StatusCancelled && overlaps(occurrence.Event, from, to) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+
+	for _, event := range events {
+		if !event.RecurrenceID.IsZero() {
+			// Overrides of events that aren't in the calendar stand on their own
+			if !masters[event.UID] {
+				add(Occurrence{Event: event, ID: event.instanceID(event.RecurrenceID)})
+			}
+			continue
+		}
+		if !event.Recurring() {
+			add(Occurrence{Event: event, ID: event.UID})
+			continue
+		}
+		if event.Status == StatusCancelled {
+			continue
+		}
+
+		instances, err := event.instances(from, to)
+		if err != nil {
+			// Keep the first instance of events with a rule we can't read
+			instances = []time.Time{event.Start}
+		}
+		for _, start := range instances {
+			instance := event
+			if override, ok := overrides[event.UID][start.Unix()]; ok {
+				instance = override
+			} else {
+				instance.Start = start
+				instance.End = event.end(start)
+				instance.RecurrenceID = start
+			}
+			add(Occurrence{Event: instance, ID: event.instanceID(start)})
+		}
+		// Overrides can move an instance into the window from outside of it
+		for _, override := range overrides[event.UID] {
+			add(Occurrence{Event: override, ID: event.instanceID(override.RecurrenceID)})
+		}
+	}
+
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		return occurrences[i].Start.Before(occurrences[j].Start)
+	})
+	return occurrences
+}
+
+// instances returns the starts of the instances of a recurring event that
+// may overlap the window from..to
+func (e Event) instances(from, to time.Time) ([]time.Time, error) {
+	set := &rrule.Set{}
+	if e.RRule != "" {
+		option, err := rrule.StrToROptionInLocation(e.RRule, e.Start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %w", e.RRule, err)
+		}
+		option.Dtstart = e.Start
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %q: %w", e.RRule, err)
+		}
+		set.RRule(rule)
+	}
+	// DTSTART is always the first instance, even when the rule doesn't match it
+	set.RDate(e.Start)
+	for _, date := range e.RDates {
+		set.RDate(date)
+	}
+	for _, date := range e.ExDates {
+		set.ExDate(date)
+	}
+	instances := set.Between(from.Add(-e.End.Sub(e.Start)), to, true)
+	// The rule is expanded on the wall clock, which can be ambiguous
+	for i, instance := range instances {
+		instances[i] = localize(instance, instance.Location())
+	}
+	return instances, nil
+}
+
+// overlaps reports whether an event takes place within the window from..to.
+// Events without a duration overlap when they start within it.
+func overlaps(event Event, from, to time.Time) bool {
+	if !event.Start.Before(to) {
+		return false
+	}
+	return event.End.After(from) || (event.End.Equal(event.Start) && !event.Start.Before(from))
+}
+
+// end returns the end of the instance of a recurring event that starts at
+// start. All-day events last the same number of days, even when a DST change
+// makes one of them shorter or longer; other events last the same time.
+func (e Event) end(start time.Time) time.Time {
+	if e.AllDay {
+		days := int(dateOf(e.End).Sub(dateOf(e.Start)).Hours() / 24)
+		return start.AddDate(0, 0, days)
+	}
+	return start.Add(e.End.Sub(e.Start))
+}
+
+// dateOf returns midnight UTC of the date of a time in its timezone
+func dateOf(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// instanceID identifies an instance of a recurring event by its original
+// start. Floating instances are identified by their wall clock time, which
+// doesn't change with the timezone they are placed in.
+func (e Event) instanceID(start time.Time) string {
+	switch {
+	case e.AllDay:
+		return e.UID + "/" + start.Format(dateFormat)
+	case e.Floating():
+		return e.UID + "/" + start.Format(dateTimeFormat)
+	default:
+		return e.UID + "/" + start.UTC().Format(dateTimeFormatUTC)
+	}
+}
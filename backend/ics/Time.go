@@ -0,0 +1,140 @@
+package ics
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	ical "github.com/arran4/golang-ical"
+)
+
+// iCalendar date and date-time formats
+const (
+	dateFormat         = "20060102"
+	dateTimeFormat     = "20060102T150405"
+	dateTimeFormatUTC  = "20060102T150405Z"
+	valueParameterDate = "DATE"
+)
+
+// timeValue is a parsed DATE or DATE-TIME value
+type timeValue struct {
+	Time time.Time
+	// Date is set for DATE values, which are taken as midnight UTC
+	Date bool
+	// Timezone is the timezone of a DATE-TIME, empty for dates and floating
+	// times, which are taken as UTC
+	Timezone string
+}
+
+// parseTime parses a DATE or DATE-TIME property
+func parseTime(prop *ical.IANAProperty, z zones) (timeValue, error) {
+	return parseTimeValue(prop, prop.Value, z)
+}
+
+// parseTimes parses the comma-separated values of an EXDATE or RDATE property.
+// Periods count by their start.
+func parseTimes(prop *ical.IANAProperty, z zones) ([]time.Time, error) {
+	var times []time.Time
+	for _, value := range strings.Split(prop.Value, ",") {
+		value, _, _ = strings.Cut(strings.TrimSpace(value), "/")
+		if value == "" {
+			continue
+		}
+		parsed, err := parseTimeValue(prop, value, z)
+		if err != nil {
+			return nil, err
+		}
+		times = append(times, parsed.Time)
+	}
+	return times, nil
+}
+
+// parseTimeValue parses a single DATE or DATE-TIME value of a property. Times
+// with a TZID are in that timezone; when the TZID is unknown they are floating.
+func parseTimeValue(prop *ical.IANAProperty, value string, z zones) (timeValue, error) {
+	value = strings.TrimSpace(value)
+	if parameter(prop, "VALUE") == valueParameterDate || len(value) == len(dateFormat) {
+		t, err := time.ParseInLocation(dateFormat, value, time.UTC)
+		if err != nil {
+			return timeValue{}, fmt.Errorf("invalid date %q", value)
+		}
+		return timeValue{Time: t, Date: true}, nil
+	}
+
+	if len(value) == len(dateTimeFormatUTC) {
+		t, err := time.Parse(dateTimeFormatUTC, value)
+		if err != nil {
+			return timeValue{}, fmt.Errorf("invalid date-time %q", value)
+		}
+		return timeValue{Time: t, Timezone: time.UTC.String()}, nil
+	}
+
+	t, err := time.ParseInLocation(dateTimeFormat, value, time.UTC)
+	if err != nil {
+		return timeValue{}, fmt.Errorf("invalid date-time %q", value)
+	}
+	if tzid := parameter(prop, "TZID"); tzid != "" {
+		if loc, ok := z.location(tzid); ok {
+			return timeValue{Time: localize(t, loc), Timezone: loc.String()}, nil
+		}
+	}
+	return timeValue{Time: t}, nil
+}
+
+// localize returns the time with the same wall clock in another timezone.
+// Like RFC 5545 wants, a wall clock that happens twice when DST ends is the
+// first of them, and one that is skipped when DST starts is taken with the
+// offset from before the change.
+func localize(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	local := time.Date(year, month, day, hour, min, sec, t.Nanosecond(), loc)
+
+	// The same wall clock with the offset of half a day earlier is the first
+	// one when it's valid
+	_, offset := local.Zone()
+	if _, earlier := local.Add(-12 * time.Hour).Zone(); earlier > offset {
+		first := local.Add(-time.Duration(earlier-offset) * time.Second)
+		if first.Day() == day && first.Hour() == hour && first.Minute() == min && first.Second() == sec {
+			return first
+		}
+	}
+	return local
+}
+
+// parameter returns the first value of a property parameter
+func parameter(prop *ical.IANAProperty, name string) string {
+	if values := prop.ICalParameters[name]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// durationPattern matches RFC 5545 durations like P1D, PT1H30M or -P2W
+var durationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W|(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?)$`)
+
+// parseDuration parses an RFC 5545 duration
+func parseDuration(value string) (time.Duration, error) {
+	match := durationPattern.FindStringSubmatch(value)
+	if match == nil || value == "P" || value == "PT" || match[0][len(match[0])-1] == 'T' {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var duration time.Duration
+	for i, unit := range []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		if match[i+2] == "" {
+			continue
+		}
+		n, err := strconv.Atoi(match[i+2])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", value)
+		}
+		duration += time.Duration(n) * unit
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
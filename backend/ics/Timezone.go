@@ -0,0 +1,247 @@
+package ics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	ical "github.com/arran4/golang-ical"
+	"github.com/teambition/rrule-go"
+)
+
+// Timezones are expanded from 1970 until the end of 32-bit time; later times
+// keep the last offset
+var (
+	timezoneStart = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+	timezoneEnd   = time.Unix(math.MaxInt32, 0)
+)
+
+// zones resolves the TZIDs of a calendar
+type zones map[string]*time.Location
+
+// location returns the timezone of a TZID. IANA names are looked up in the
+// system's tzdata, so that its rules win over outdated embedded definitions;
+// other names need a VTIMEZONE in the calendar.
+func (z zones) location(tzid string) (*time.Location, bool) {
+	// "Local" would depend on the server's configuration
+	if tzid != "" && tzid != "Local" {
+		if loc, err := time.LoadLocation(tzid); err == nil {
+			return loc, true
+		}
+	}
+	loc, ok := z[tzid]
+	return loc, ok
+}
+
+// parseTimezones reads the VTIMEZONE definitions of a calendar. Definitions
+// that can't be read are left out, making times in them floating.
+func parseTimezones(cal *ical.Calendar) zones {
+	z := zones{}
+	for _, vtimezone := range cal.Timezones() {
+		prop := vtimezone.GetProperty(ical.ComponentPropertyTzid)
+		if prop == nil {
+			continue
+		}
+		tzid := strings.TrimSpace(prop.Value)
+		// Some clients name the IANA timezone their definition was made from
+		if location := vtimezone.GetProperty(ical.ComponentProperty("X-LIC-LOCATION")); location != nil {
+			if loc, err := time.LoadLocation(strings.TrimSpace(location.Value)); err == nil && loc.String() != "Local" {
+				z[tzid] = loc
+				continue
+			}
+		}
+		if loc, err := parseTimezone(tzid, vtimezone); err == nil {
+			z[tzid] = loc
+		}
+	}
+	return z
+}
+
+// transition is a change of UTC offset
+type transition struct {
+	at     int64
+	from   int
+	offset int
+	dst    bool
+	name   string
+}
+
+// parseTimezone builds a location from the STANDARD and DAYLIGHT observances
+// of a VTIMEZONE
+func parseTimezone(tzid string, vtimezone *ical.VTimezone) (*time.Location, error) {
+	var transitions []transition
+	for _, component := range vtimezone.Components {
+		var observance *ical.ComponentBase
+		dst := false
+		switch c := component.(type) {
+		case *ical.Standard:
+			observance = &c.ComponentBase
+		case *ical.Daylight:
+			observance, dst = &c.ComponentBase, true
+		default:
+			continue
+		}
+		onsets, err := parseObservance(observance, dst)
+		if err != nil {
+			return nil, err
+		}
+		transitions = append(transitions, onsets...)
+	}
+	if len(transitions) == 0 {
+		return nil, fmt.Errorf("timezone %s has no observances", tzid)
+	}
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].at < transitions[j].at })
+
+	var data bytes.Buffer
+	writeTZif(&data, transitions)
+	return time.LoadLocationFromTZData(tzid, data.Bytes())
+}
+
+// parseObservance returns the transitions into a STANDARD or DAYLIGHT
+// observance. Its onsets are local times in the offset it starts from.
+func parseObservance(observance *ical.ComponentBase, dst bool) ([]transition, error) {
+	from, err := parseOffset(observance.GetProperty(ical.ComponentProperty(ical.PropertyTzoffsetfrom)))
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseOffset(observance.GetProperty(ical.ComponentProperty(ical.PropertyTzoffsetto)))
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%+03d", to/3600)
+	if prop := observance.GetProperty(ical.ComponentProperty(ical.PropertyTzname)); prop != nil && prop.Value != "" {
+		name = strings.TrimSpace(prop.Value)
+	}
+
+	startProp := observance.GetProperty(ical.ComponentPropertyDtStart)
+	if startProp == nil {
+		return nil, fmt.Errorf("observance without DTSTART")
+	}
+	zone := time.FixedZone("", from)
+	start, err := time.ParseInLocation(dateTimeFormat, strings.TrimSpace(startProp.Value), zone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid observance start %q", startProp.Value)
+	}
+
+	set := &rrule.Set{}
+	set.RDate(start)
+	if prop := observance.GetProperty(ical.ComponentPropertyRrule); prop != nil {
+		option, err := rrule.StrToROptionInLocation(strings.TrimPrefix(strings.TrimSpace(prop.Value), "RRULE:"), zone)
+		if err != nil {
+			return nil, fmt.Errorf("invalid observance RRULE %q: %w", prop.Value, err)
+		}
+		option.Dtstart = start
+		if start.Before(timezoneStart) && option.Freq == rrule.YEARLY && option.Count == 0 && option.Interval <= 1 {
+			// Outlook starts its rules in 1601, which is more than the rule
+			// iterator can cope with. Yearly rules are the same from a later year.
+			option.Dtstart = time.Date(timezoneStart.Year()-1, start.Month(), start.Day(), start.Hour(), start.Minute(), start.Second(), 0, zone)
+		}
+		rule, err := rrule.NewRRule(*option)
+		if err != nil {
+			return nil, fmt.Errorf("invalid observance RRULE %q: %w", prop.Value, err)
+		}
+		set.RRule(rule)
+	}
+	for _, prop := range observance.GetProperties(ical.ComponentPropertyRdate) {
+		for _, value := range strings.Split(prop.Value, ",") {
+			if date, err := time.ParseInLocation(dateTimeFormat, strings.TrimSpace(value), zone); err == nil {
+				set.RDate(date)
+			}
+		}
+	}
+
+	onsets := set.Between(timezoneStart, timezoneEnd, true)
+	// The last onset before then tells which observance 1970 starts in
+	if before := set.Before(timezoneStart, false); !before.IsZero() {
+		onsets = append([]time.Time{before}, onsets...)
+	}
+	transitions := make([]transition, len(onsets))
+	for i, onset := range onsets {
+		transitions[i] = transition{at: max(onset.Unix(), math.MinInt32), from: from, offset: to, dst: dst, name: name}
+	}
+	return transitions, nil
+}
+
+// parseOffset parses a UTC offset like +0100 or -053000 into seconds
+func parseOffset(prop *ical.IANAProperty) (int, error) {
+	if prop == nil {
+		return 0, fmt.Errorf("observance without UTC offset")
+	}
+	value := strings.TrimSpace(prop.Value)
+	if (len(value) != 5 && len(value) != 7) || (value[0] != '+' && value[0] != '-') {
+		return 0, fmt.Errorf("invalid UTC offset %q", value)
+	}
+	seconds := 0
+	for i, unit := range []int{3600, 60, 1} {
+		if 1+2*i >= len(value) {
+			break
+		}
+		n, err := strconv.Atoi(value[1+2*i : 3+2*i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid UTC offset %q", value)
+		}
+		seconds += n * unit
+	}
+	if value[0] == '-' {
+		seconds = -seconds
+	}
+	return seconds, nil
+}
+
+// writeTZif encodes transitions, sorted by time, as version 1 TZif data
+func writeTZif(w *bytes.Buffer, transitions []transition) {
+	type zoneType struct {
+		offset int
+		dst    bool
+		name   string
+	}
+	// The first type applies before the first transition
+	first := transitions[0].from
+	types := []zoneType{{first, false, fmt.Sprintf("%+03d", first/3600)}}
+	typeIndex := map[zoneType]int{}
+	indexes := make([]byte, len(transitions))
+	for i, t := range transitions {
+		zt := zoneType{t.offset, t.dst, t.name}
+		index, ok := typeIndex[zt]
+		if !ok {
+			index = len(types)
+			typeIndex[zt] = index
+			types = append(types, zt)
+		}
+		indexes[i] = byte(index)
+	}
+
+	var names bytes.Buffer
+	nameIndex := map[string]int{}
+	for _, zt := range types {
+		if _, ok := nameIndex[zt.name]; !ok {
+			nameIndex[zt.name] = names.Len()
+			names.WriteString(zt.name)
+			names.WriteByte(0)
+		}
+	}
+
+	w.WriteString("TZif")
+	w.Write(make([]byte, 16))
+	for _, count := range []int{0, 0, 0, len(transitions), len(types), names.Len()} {
+		binary.Write(w, binary.BigEndian, uint32(count))
+	}
+	for _, t := range transitions {
+		binary.Write(w, binary.BigEndian, int32(t.at))
+	}
+	w.Write(indexes)
+	for _, zt := range types {
+		binary.Write(w, binary.BigEndian, int32(zt.offset))
+		dst := byte(0)
+		if zt.dst {
+			dst = 1
+		}
+		w.Write([]byte{dst, byte(nameIndex[zt.name])})
+	}
+	w.Write(names.Bytes())
+}
@@ -0,0 +1,422 @@
+package ics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// calendar wraps lines into a VCALENDAR with CRLF line endings
+func calendar(lines ...string) string {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0", "PRODID:-//Test//EN"}, lines...)
+	all = append(all, "END:VCALENDAR")
+	return strings.Join(all, "\r\n") + "\r\n"
+}
+
+// Test reading the events of a calendar
+func TestParse(t *testing.T) {
+	cal, err := Parse(strings.NewReader(calendar(
+		"X-WR-CALNAME:Work",
+		"BEGIN:VEVENT",
+		"UID:1@example.com",
+		"DTSTART:20240304T090000Z",
+		"DTEND:20240304T091500Z",
+		"SUMMARY:Standup\\, daily",
+		"LOCATION:Room 1",
+		"DESCRIPTION:Agenda:\\nUpdates",
+		"SEQUENCE:2",
+		"LAST-MODIFIED:20240301T080000Z",
+		"STATUS:confirmed",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:2@example.com",
+		"DTSTART;TZID=Europe/Zurich:20240305T140000",
+		"DURATION:PT1H30M",
+		"SUMMARY:Review with a very long title that is folded over",
+		"  two lines",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:3@example.com",
+		"DTSTART;VALUE=DATE:20240307",
+		"SUMMARY:Holiday",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:No start",
+		"END:VEVENT",
+	)))
+	assert.NoError(t, err)
+	assert.Equal(t, "Work", cal.Name)
+	if !assert.Len(t, cal.Events, 3) {
+		return
+	}
+
+	standup := cal.Events[0]
+	assert.Equal(t, "1@example.com", standup.UID)
+	assert.Equal(t, "Standup, daily", standup.Summary)
+	assert.Equal(t, "Room 1", standup.Location)
+	assert.Equal(t, "Agenda:\nUpdates", standup.Notes)
+	assert.Equal(t, time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC), standup.Start)
+	assert.Equal(t, time.Date(2024, 3, 4, 9, 15, 0, 0, time.UTC), standup.End)
+	assert.Equal(t, 2, standup.Sequence)
+	assert.Equal(t, time.Date(2024, 3, 1, 8, 0, 0, 0, time.UTC), standup.LastModified)
+	assert.Equal(t, StatusConfirmed, standup.Status)
+
+	review := cal.Events[1]
+	assert.Equal(t, "Review with a very long title that is folded over two lines", review.Summary)
+	assert.Equal(t, time.Date(2024, 3, 5, 13, 0, 0, 0, time.UTC), review.Start.UTC())
+	assert.Equal(t, 90*time.Minute, review.End.Sub(review.Start))
+	assert.True(t, review.LastModified.IsZero())
+
+	holiday := cal.Events[2]
+	assert.True(t, holiday.AllDay)
+	assert.Equal(t, time.Date(2024, 3, 7, 0, 0, 0, 0, time.UTC), holiday.Start)
+	assert.Equal(t, time.Date(2024, 3, 8, 0, 0, 0, 0, time.UTC), holiday.End)
+}
+
+// Test that events without a UID get a stable one
+func TestParseGeneratedUID(t *testing.T) {
+	feed := calendar("BEGIN:VEVENT", "DTSTART:20240304T090000Z", "SUMMARY:Standup", "END:VEVENT")
+	first, err := Parse(strings.NewReader(feed))
+	assert.NoError(t, err)
+	second, err := Parse(strings.NewReader(feed))
+	assert.NoError(t, err)
+	assert.NotEmpty(t, first.Events[0].UID)
+	assert.Equal(t, first.Events[0].UID, second.Events[0].UID)
+}
+
+// Test that data which isn't a calendar is rejected
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(strings.NewReader("<html>Not found</html>"))
+	assert.Error(t, err)
+}
+
+// Test parsing RFC 5545 durations
+func TestParseDuration(t *testing.T) {
+	for value, expected := range map[string]time.Duration{
+		"PT15M":      15 * time.Minute,
+		"PT1H30M":    90 * time.Minute,
+		"P1D":        24 * time.Hour,
+		"P1DT12H":    36 * time.Hour,
+		"P2W":        14 * 24 * time.Hour,
+		"-PT5M":      -5 * time.Minute,
+		"+PT10S":     10 * time.Second,
+		"P0D":        0,
+		"PT1H0M30S":  time.Hour + 30*time.Second,
+		"P1DT1H1M1S": 25*time.Hour + time.Minute + time.Second,
+	} {
+		duration, err := parseDuration(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, expected, duration, value)
+	}
+
+	for _, value := range []string{"", "P", "PT", "P1DT", "1H", "P1H", "PT1D", "P1W2D"} {
+		_, err := parseDuration(value)
+		assert.Error(t, err, value)
+	}
+}
+
+// occurrences parses a calendar and expands it within a window
+func occurrences(t *testing.T, from, to time.Time, lines ...string) []Occurrence {
+	cal, err := Parse(strings.NewReader(calendar(lines...)))
+	if !assert.NoError(t, err) {
+		return nil
+	}
+	return cal.Occurrences(from, to, time.UTC)
+}
+
+// starts returns the starts of occurrences in UTC
+func starts(occurrences []Occurrence) []time.Time {
+	out := make([]time.Time, len(occurrences))
+	for i, occurrence := range occurrences {
+		out[i] = occurrence.Start.UTC()
+	}
+	return out
+}
+
+// Test expanding recurring events into occurrences
+func TestOccurrences(t *testing.T) {
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	april := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	utc := func(day, hour int) time.Time { return time.Date(2024, 3, day, hour, 0, 0, 0, time.UTC) }
+
+	t.Run("Single event", func(t *testing.T) {
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:single", "DTSTART:20240304T090000Z", "DTEND:20240304T100000Z", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:outside", "DTSTART:20240404T090000Z", "DTEND:20240404T100000Z", "END:VEVENT",
+		)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, "single", found[0].ID)
+		}
+	})
+
+	t.Run("COUNT", func(t *testing.T) {
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:daily", "DTSTART:20240304T090000Z", "DTEND:20240304T091500Z",
+			"RRULE:FREQ=DAILY;COUNT=3", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{utc(4, 9), utc(5, 9), utc(6, 9)}, starts(found))
+		if assert.Len(t, found, 3) {
+			assert.Equal(t, "daily/20240305T090000Z", found[1].ID)
+			assert.Equal(t, 15*time.Minute, found[1].End.Sub(found[1].Start))
+		}
+	})
+
+	t.Run("UNTIL and BYDAY", func(t *testing.T) {
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:weekly", "DTSTART:20240304T090000Z", "DTEND:20240304T100000Z",
+			"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20240313T090000Z", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{utc(4, 9), utc(6, 9), utc(11, 9), utc(13, 9)}, starts(found))
+	})
+
+	t.Run("BYMONTHDAY", func(t *testing.T) {
+		found := occurrences(t, march, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			"BEGIN:VEVENT", "UID:rent", "DTSTART;VALUE=DATE:20240131",
+			"RRULE:FREQ=MONTHLY;BYMONTHDAY=-1", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{
+			time.Date(2024, 3, 31, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 4, 30, 0, 0, 0, 0, time.UTC),
+			time.Date(2024, 5, 31, 0, 0, 0, 0, time.UTC),
+		}, starts(found))
+		if assert.Len(t, found, 3) {
+			assert.Equal(t, "rent/20240430", found[1].ID)
+			assert.True(t, found[1].AllDay)
+		}
+	})
+
+	t.Run("BYSETPOS", func(t *testing.T) {
+		// The last workday of the month
+		found := occurrences(t, march, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC),
+			"BEGIN:VEVENT", "UID:report", "DTSTART:20240329T160000Z", "DURATION:PT1H",
+			"RRULE:FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{
+			time.Date(2024, 3, 29, 16, 0, 0, 0, time.UTC),
+			time.Date(2024, 4, 30, 16, 0, 0, 0, time.UTC),
+			time.Date(2024, 5, 31, 16, 0, 0, 0, time.UTC),
+		}, starts(found))
+	})
+
+	t.Run("EXDATE and RDATE", func(t *testing.T) {
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:daily", "DTSTART:20240304T090000Z", "DTEND:20240304T091500Z",
+			"RRULE:FREQ=DAILY;COUNT=4",
+			"EXDATE:20240305T090000Z,20240306T090000Z",
+			"RDATE:20240310T120000Z", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{utc(4, 9), utc(7, 9), utc(10, 12)}, starts(found))
+	})
+
+	t.Run("Window", func(t *testing.T) {
+		// Instances that started before the window but still run are included
+		found := occurrences(t, utc(5, 9).Add(30*time.Minute), utc(7, 0),
+			"BEGIN:VEVENT", "UID:daily", "DTSTART:20230101T090000Z", "DTEND:20230101T100000Z",
+			"RRULE:FREQ=DAILY", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{utc(5, 9), utc(6, 9)}, starts(found))
+	})
+
+	t.Run("Timezone", func(t *testing.T) {
+		// The local time stays the same across the DST change of March 31
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:weekly", "DTSTART;TZID=Europe/Zurich:20240324T100000", "DURATION:PT1H",
+			"RRULE:FREQ=WEEKLY;COUNT=2", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{utc(24, 9), utc(31, 8)}, starts(found))
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		found := occurrences(t, march, april,
+			"BEGIN:VEVENT", "UID:daily", "DTSTART:20240304T090000Z", "DTEND:20240304T091500Z",
+			"SUMMARY:Standup", "RRULE:FREQ=DAILY;COUNT=3", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:daily", "RECURRENCE-ID:20240305T090000Z",
+			"DTSTART:20240305T140000Z", "DTEND:20240305T143000Z", "SUMMARY:Standup (moved)", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:daily", "RECURRENCE-ID:20240306T090000Z",
+			"DTSTART:20240306T090000Z", "DTEND:20240306T091500Z", "STATUS:CANCELLED", "END:VEVENT",
+		)
+		if assert.Len(t, found, 2) {
+			assert.Equal(t, "daily/20240304T090000Z", found[0].ID)
+			assert.Equal(t, "Standup", found[0].Summary)
+			assert.Equal(t, "daily/20240305T090000Z", found[1].ID)
+			assert.Equal(t, "Standup (moved)", found[1].Summary)
+			assert.Equal(t, utc(5, 14), found[1].Start)
+		}
+	})
+
+	t.Run("Override moved into the window", func(t *testing.T) {
+		found := occurrences(t, utc(2, 0), april,
+			"BEGIN:VEVENT", "UID:monthly", "DTSTART:20240201T090000Z", "DURATION:PT1H",
+			"RRULE:FREQ=MONTHLY;COUNT=3", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:monthly", "RECURRENCE-ID:20240401T090000Z",
+			"DTSTART:20240328T090000Z", "DURATION:PT1H", "END:VEVENT",
+		)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, "monthly/20240401T090000Z", found[0].ID)
+			assert.Equal(t, utc(28, 9), found[0].Start)
+		}
+	})
+}
+
+// westEurope is a VTIMEZONE the way Outlook writes it, with a name that isn't
+// in the tzdata
+var westEurope = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:W. Europe Standard Time",
+	"BEGIN:STANDARD",
+	"DTSTART:16010101T030000",
+	"TZOFFSETFROM:+0200",
+	"TZOFFSETTO:+0100",
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=10",
+	"END:STANDARD",
+	"BEGIN:DAYLIGHT",
+	"DTSTART:16010101T020000",
+	"TZOFFSETFROM:+0100",
+	"TZOFFSETTO:+0200",
+	"RRULE:FREQ=YEARLY;INTERVAL=1;BYDAY=-1SU;BYMONTH=3",
+	"END:DAYLIGHT",
+	"END:VTIMEZONE",
+}
+
+// Test placing events in time around DST changes
+func TestTimezones(t *testing.T) {
+	zurich, err := time.LoadLocation("Europe/Zurich")
+	if err != nil {
+		t.Skip("No tzdata available")
+	}
+	newYork, _ := time.LoadLocation("America/New_York")
+	march := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	november := time.Date(2024, 11, 1, 0, 0, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+	}
+
+	t.Run("Embedded VTIMEZONE", func(t *testing.T) {
+		found := occurrences(t, march, november, append(westEurope,
+			"BEGIN:VEVENT", "UID:weekly", "DTSTART;TZID=W. Europe Standard Time:20240323T100000",
+			"DTEND;TZID=W. Europe Standard Time:20240323T110000", "RRULE:FREQ=WEEKLY;COUNT=3", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:autumn", "DTSTART;TZID=W. Europe Standard Time:20241028T100000",
+			"DURATION:PT1H", "END:VEVENT",
+		)...)
+		// The local time stays the same across the DST changes of March 31 and October 27
+		assert.Equal(t, []time.Time{at(3, 23, 9, 0), at(3, 30, 9, 0), at(4, 6, 8, 0), at(10, 28, 9, 0)}, starts(found))
+		if assert.Len(t, found, 4) {
+			assert.Equal(t, "W. Europe Standard Time", found[0].Timezone)
+			assert.Equal(t, "weekly/20240406T080000Z", found[2].ID)
+		}
+	})
+
+	t.Run("X-LIC-LOCATION", func(t *testing.T) {
+		found := occurrences(t, march, november,
+			"BEGIN:VTIMEZONE", "TZID:/citadel.org/20190101_1/Europe/Zurich", "X-LIC-LOCATION:Europe/Zurich",
+			"BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0100", "END:STANDARD",
+			"END:VTIMEZONE",
+			"BEGIN:VEVENT", "UID:summer", "DTSTART;TZID=/citadel.org/20190101_1/Europe/Zurich:20240701T100000",
+			"DURATION:PT1H", "END:VEVENT",
+		)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, at(7, 1, 8, 0), found[0].Start.UTC())
+			assert.Equal(t, "Europe/Zurich", found[0].Timezone)
+		}
+	})
+
+	t.Run("tzdata wins over an embedded definition", func(t *testing.T) {
+		// This definition forgot about DST
+		found := occurrences(t, march, november,
+			"BEGIN:VTIMEZONE", "TZID:Europe/Zurich",
+			"BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0100", "END:STANDARD",
+			"END:VTIMEZONE",
+			"BEGIN:VEVENT", "UID:summer", "DTSTART;TZID=Europe/Zurich:20240701T100000", "DURATION:PT1H", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{at(7, 1, 8, 0)}, starts(found))
+	})
+
+	t.Run("Unknown TZID", func(t *testing.T) {
+		// Without a definition the time is floating
+		cal, err := Parse(strings.NewReader(calendar(
+			"BEGIN:VEVENT", "UID:unknown", "DTSTART;TZID=Mars/Olympus_Mons:20240701T100000", "DURATION:PT1H", "END:VEVENT",
+		)))
+		assert.NoError(t, err)
+		found := cal.Occurrences(march, november, zurich)
+		if assert.Len(t, found, 1) {
+			assert.True(t, found[0].Floating())
+			assert.Equal(t, at(7, 1, 8, 0), found[0].Start.UTC())
+		}
+	})
+
+	t.Run("Nonexistent local time", func(t *testing.T) {
+		// 02:30 doesn't exist on the day DST starts; it's taken with the offset before the gap
+		found := occurrences(t, march, november,
+			"BEGIN:VEVENT", "UID:gap", "DTSTART;TZID=Europe/Zurich:20240331T023000", "DURATION:PT30M", "END:VEVENT",
+		)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, at(3, 31, 1, 30), found[0].Start.UTC())
+			assert.Equal(t, 30*time.Minute, found[0].End.Sub(found[0].Start))
+		}
+	})
+
+	t.Run("Ambiguous local time", func(t *testing.T) {
+		// 02:30 happens twice on the day DST ends; the first one counts
+		found := occurrences(t, march, november,
+			"BEGIN:VEVENT", "UID:overlap", "DTSTART;TZID=Europe/Zurich:20241027T023000", "DURATION:PT30M", "END:VEVENT",
+			"BEGIN:VEVENT", "UID:nightly", "DTSTART;TZID=Europe/Zurich:20241026T023000", "DURATION:PT30M",
+			"RRULE:FREQ=DAILY;COUNT=3", "END:VEVENT",
+		)
+		assert.Equal(t, []time.Time{at(10, 26, 0, 30), at(10, 27, 0, 30), at(10, 27, 0, 30), at(10, 28, 1, 30)}, starts(found))
+	})
+
+	t.Run("Timed event across a DST change", func(t *testing.T) {
+		// An event that ends after DST started lasts an hour less on the clock
+		found := occurrences(t, march, november,
+			"BEGIN:VEVENT", "UID:night", "DTSTART;TZID=Europe/Zurich:20240331T000000",
+			"DTEND;TZID=Europe/Zurich:20240331T060000", "END:VEVENT",
+		)
+		if assert.Len(t, found, 1) {
+			assert.Equal(t, 5*time.Hour, found[0].End.Sub(found[0].Start))
+		}
+	})
+
+	t.Run("All-day events", func(t *testing.T) {
+		cal, err := Parse(strings.NewReader(calendar(
+			"BEGIN:VEVENT", "UID:holiday", "DTSTART;VALUE=DATE:20240330", "DTEND;VALUE=DATE:20240331",
+			"RRULE:FREQ=DAILY;COUNT=3", "END:VEVENT",
+		)))
+		assert.NoError(t, err)
+		found := cal.Occurrences(march, november, zurich)
+		if !assert.Len(t, found, 3) {
+			return
+		}
+		// Each instance takes its whole local day, which is 23 hours on March 31
+		for i, hours := range []time.Duration{24, 23, 24} {
+			day := time.Date(2024, 3, 30+i, 0, 0, 0, 0, zurich)
+			assert.True(t, found[i].AllDay)
+			assert.True(t, day.Equal(found[i].Start), found[i].Start)
+			assert.Equal(t, hours*time.Hour, found[i].End.Sub(found[i].Start))
+		}
+		assert.Equal(t, "holiday/20240331", found[1].ID)
+	})
+
+	t.Run("Floating times", func(t *testing.T) {
+		cal, err := Parse(strings.NewReader(calendar(
+			"BEGIN:VEVENT", "UID:standup", "DTSTART:20240308T090000", "DURATION:PT15M",
+			"RRULE:FREQ=DAILY;COUNT=3", "EXDATE:20240309T090000", "END:VEVENT",
+		)))
+		assert.NoError(t, err)
+
+		// The wall clock time stays the same across the US DST change of March 10
+		found := cal.Occurrences(march, november, newYork)
+		assert.Equal(t, []time.Time{at(3, 8, 14, 0), at(3, 10, 13, 0)}, starts(found))
+		if assert.Len(t, found, 2) {
+			assert.Equal(t, "standup/20240310T090000", found[1].ID)
+		}
+
+		// The identity of an instance doesn't depend on the timezone
+		elsewhere := cal.Occurrences(march, november, zurich)
+		if assert.Len(t, elsewhere, 2) {
+			assert.Equal(t, found[1].ID, elsewhere[1].ID)
+			assert.Equal(t, at(3, 10, 8, 0), elsewhere[1].Start.UTC())
+		}
+	})
+}
@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// OutboxMailer keeps sent emails in memory instead of delivering them, and
+// also writes them as .eml files when Dir is set. Meant for local development
+// and tests.
+type OutboxMailer struct {
+	From string
+	Dir  string
+
+	mu       sync.Mutex
+	messages []Message
+}
+
+// Send records the message in the outbox
+func (m *OutboxMailer) Send(ctx context.Context, msg Message) error {
+	for name, value := range map[string]string{"To": msg.To, "Subject": msg.Subject} {
+		if err := validateHeader(name, value); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+
+	if m.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(m.Dir, 0o700); err != nil {
+		return err
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%03d.eml", now.UTC().Format("20060102T150405.000000000"), len(m.messages))
+	return os.WriteFile(filepath.Join(m.Dir, name), msg.format(m.From, now), 0o600)
+}
+
+// Messages returns a copy of every message sent so far
+func (m *OutboxMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Message(nil), m.messages...)
+}
+
+// Last returns the most recent message sent to the address
+func (m *OutboxMailer) Last(to string) (Message, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := len(m.messages) - 1; i >= 0; i-- {
+		if m.messages[i].To == to {
+			return m.messages[i], true
+		}
+	}
+	return Message{}, false
+}
@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends emails through an SMTP server, using STARTTLS when offered
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	for name, value := range map[string]string{"To": msg.To, "Subject": msg.Subject, "From": m.From} {
+		if err := validateHeader(name, value); err != nil {
+			return err
+		}
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := fmt.Sprintf("%s:%d", m.Host, m.Port)
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.From, []string{msg.To}, msg.format(m.From, time.Now()))
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,46 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test the outbox keeps messages in memory and on disk
+func TestOutboxMailer(t *testing.T) {
+	dir := t.TempDir()
+	outbox := &OutboxMailer{From: "noreply@example.com", Dir: dir}
+
+	err := outbox.Send(context.Background(), Message{To: "a@example.com", Subject: "First", Body: "Hello\nthere"})
+	assert.NoError(t, err)
+	err = outbox.Send(context.Background(), Message{To: "b@example.com", Subject: "Second", Body: "Hi"})
+	assert.NoError(t, err)
+
+	assert.Len(t, outbox.Messages(), 2)
+	last, ok := outbox.Last("a@example.com")
+	assert.True(t, ok)
+	assert.Equal(t, "First", last.Subject)
+	_, ok = outbox.Last("nobody@example.com")
+	assert.False(t, ok)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+	content, err := os.ReadFile(files[0])
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "From: noreply@example.com\r\n"))
+	assert.Contains(t, string(content), "Subject: First\r\n")
+	assert.Contains(t, string(content), "Hello\r\nthere")
+}
+
+// Test header injection is refused
+func TestHeaderInjection(t *testing.T) {
+	outbox := &OutboxMailer{}
+	err := outbox.Send(context.Background(), Message{To: "a@example.com\r\nBcc: victim@example.com", Subject: "Hi"})
+	assert.Error(t, err)
+	assert.Empty(t, outbox.Messages())
+}
@@ -2,6 +2,13 @@ package main
 
 import (
 	"awesomeProject/controllers"
+	"awesomeProject/mailer"
+	"awesomeProject/models"
+	"awesomeProject/oidc"
+	"awesomeProject/secrets"
+	"awesomeProject/sources"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/danielgtaylor/huma/v2"
 	"github.com/danielgtaylor/huma/v2/adapters/humachi"
@@ -12,6 +19,8 @@ import (
 	"gorm.io/gorm"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 )
 
 // Options for the CLI
@@ -22,6 +31,161 @@ type Options struct {
 	DbUser string `help:"Database username" env:"POSTGRES_USER" default:"postgres"`
 	DbPass string `help:"Database password" env:"POSTGRES_PASSWORD" default:"password"`
 	Port   int    `help:"Port to listen on" short:"p" default:"8888"`
+
+	JwtSigningKey       string        `help:"Secret used to sign access tokens (HS256)" env:"JWT_SIGNING_KEY"`
+	JwtVerificationKeys string        `help:"Comma-separated list of previous signing secrets still accepted for verification" env:"JWT_VERIFICATION_KEYS"`
+	JwtIssuer           string        `help:"Issuer (iss) written to and required in access tokens" env:"JWT_ISSUER" default:"proton-agenda"`
+	JwtAudience         string        `help:"Audience (aud) written to and required in access tokens" env:"JWT_AUDIENCE" default:"proton-agenda-api"`
+	AccessTokenTTL      time.Duration `help:"Lifetime of issued access tokens" env:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL     time.Duration `help:"Lifetime of a session's refresh token" env:"REFRESH_TOKEN_TTL" default:"720h"`
+
+	SecretKeys string `help:"Comma-separated id:key pairs of base64-encoded 32-byte keys that encrypt agenda source URLs and credentials. The first one encrypts; to rotate, put a new key first and drop the old one after a restart." env:"SECRET_KEYS"`
+
+	PasswordMemory      int `help:"Argon2id memory cost in KiB; stored hashes are upgraded on login when changed" env:"PASSWORD_MEMORY" default:"65536"`
+	PasswordIterations  int `help:"Argon2id time cost (iterations)" env:"PASSWORD_ITERATIONS" default:"3"`
+	PasswordParallelism int `help:"Argon2id parallelism" env:"PASSWORD_PARALLELISM" default:"2"`
+
+	PublicURL            string        `help:"Externally reachable base URL of the API, used in emailed links" env:"PUBLIC_URL" default:"http://localhost:8888"`
+	EmailVerificationTTL time.Duration `help:"Lifetime of email verification links" env:"EMAIL_VERIFICATION_TTL" default:"48h"`
+	PasswordResetTTL     time.Duration `help:"Lifetime of password reset links" env:"PASSWORD_RESET_TTL" default:"30m"`
+	SmtpHost             string        `help:"SMTP server hostname; when empty emails go to the outbox instead" env:"SMTP_HOST"`
+	SmtpPort             int           `help:"SMTP server port" env:"SMTP_PORT" default:"587"`
+	SmtpUsername         string        `help:"SMTP username" env:"SMTP_USERNAME"`
+	SmtpPassword         string        `help:"SMTP password" env:"SMTP_PASSWORD"`
+	MailFrom             string        `help:"Sender address of outgoing emails" env:"MAIL_FROM" default:"ProtonAgenda <noreply@localhost>"`
+	MailOutboxDir        string        `help:"Directory the development outbox writes .eml files to" env:"MAIL_OUTBOX_DIR" default:"outbox"`
+
+	AdminEmails string `help:"Comma-separated email addresses of existing users to promote to admin on startup" env:"ADMIN_EMAILS"`
+
+	OidcProviders        string `help:"JSON list of OpenID Connect providers, e.g. [{\"name\":\"corp\",\"issuer\":\"https://sso.example.com\",\"clientId\":\"agenda\",\"clientSecret\":\"...\"}]" env:"OIDC_PROVIDERS"`
+	DisablePasswordLogin bool   `help:"Only allow logins through the OpenID Connect providers" env:"DISABLE_PASSWORD_LOGIN"`
+
+	SyncInterval   time.Duration `help:"How often the calendars of agenda sources without their own interval are downloaded, 0 to only sync those with their own" env:"SYNC_INTERVAL" default:"15m"`
+	SyncMaxBackoff time.Duration `help:"Longest delay before retrying an agenda source whose syncs keep failing" env:"SYNC_MAX_BACKOFF" default:"6h"`
+	SyncWorkers    int           `help:"Number of agenda sources synced at the same time" env:"SYNC_WORKERS" default:"2"`
+	SyncHorizon    time.Duration `help:"How far ahead the events of agenda sources are synced" env:"SYNC_HORIZON" default:"4320h"`
+	SyncHistory    time.Duration `help:"How far back the events of agenda sources are synced" env:"SYNC_HISTORY" default:"720h"`
+	SyncTimeout    time.Duration `help:"Longest time a request for the calendar of an agenda source may take, including its download" env:"SYNC_TIMEOUT" default:"1m"`
+	SyncMaxSize    int64         `help:"Largest calendar, in bytes, an agenda source may download" env:"SYNC_MAX_SIZE" default:"10485760"`
+
+	SourceAllowHosts string `help:"Comma-separated host names, IP addresses and CIDR ranges agenda sources may connect to even though they are private, like self-hosted calendar servers" env:"SOURCE_ALLOW_HOSTS"`
+
+	LocalCalendarDir string `help:"Directory of iCalendar files agenda sources of type file can read; when empty the type is disabled" env:"LOCAL_CALENDAR_DIR"`
+}
+
+// authConfig builds the token settings from the CLI options
+func (o *Options) authConfig() *controllers.AuthConfig {
+	if o.JwtSigningKey == "" {
+		log.Fatal("A JWT signing key is required, set it with --jwt-signing-key")
+	}
+	config := &controllers.AuthConfig{
+		SigningKey:      []byte(o.JwtSigningKey),
+		Issuer:          o.JwtIssuer,
+		Audience:        o.JwtAudience,
+		AccessTokenTTL:  o.AccessTokenTTL,
+		RefreshTokenTTL: o.RefreshTokenTTL,
+	}
+	for _, key := range strings.Split(o.JwtVerificationKeys, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			config.VerificationKeys = append(config.VerificationKeys, []byte(key))
+		}
+	}
+	return config
+}
+
+// passwordHasher builds the argon2id hasher from the CLI options
+func (o *Options) passwordHasher() *controllers.PasswordHasher {
+	hasher := controllers.DefaultPasswordHasher()
+	hasher.Memory = uint32(o.PasswordMemory)
+	hasher.Iterations = uint32(o.PasswordIterations)
+	hasher.Parallelism = uint8(o.PasswordParallelism)
+	return hasher
+}
+
+// adminEmails returns the configured admin email addresses
+func (o *Options) adminEmails() []string {
+	var emails []string
+	for _, email := range strings.Split(o.AdminEmails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	return emails
+}
+
+// oidcProviders parses the configured OpenID Connect providers. Their redirect
+// URL defaults to /oidc/{name}/callback under the public URL.
+func (o *Options) oidcProviders() []*oidc.Provider {
+	if o.OidcProviders == "" {
+		if o.DisablePasswordLogin {
+			log.Fatal("Password login can only be disabled when OIDC providers are configured")
+		}
+		return nil
+	}
+	var configs []oidc.Config
+	if err := json.Unmarshal([]byte(o.OidcProviders), &configs); err != nil {
+		log.Fatalf("Invalid OIDC provider configuration: %v", err)
+	}
+	providers := make([]*oidc.Provider, len(configs))
+	for i, config := range configs {
+		if config.RedirectURL == "" {
+			config.RedirectURL = fmt.Sprintf("%s/oidc/%s/callback", strings.TrimRight(o.PublicURL, "/"), config.Name)
+		}
+		provider, err := oidc.NewProvider(config)
+		if err != nil {
+			log.Fatalf("Invalid OIDC provider configuration: %v", err)
+		}
+		providers[i] = provider
+	}
+	return providers
+}
+
+// mailer builds the SMTP mailer, or the file outbox when no SMTP server is configured
+func (o *Options) mailer() mailer.Mailer {
+	if o.SmtpHost == "" {
+		log.Printf("No SMTP host configured, writing emails to %s", o.MailOutboxDir)
+		return &mailer.OutboxMailer{From: o.MailFrom, Dir: o.MailOutboxDir}
+	}
+	return &mailer.SMTPMailer{
+		Host:     o.SmtpHost,
+		Port:     o.SmtpPort,
+		Username: o.SmtpUsername,
+		Password: o.SmtpPassword,
+		From:     o.MailFrom,
+	}
+}
+
+// keyring builds the keyring that encrypts secrets in the database
+func (o *Options) keyring() *secrets.Keyring {
+	if o.SecretKeys == "" {
+		log.Fatal("An encryption key is required, set it with --secret-keys")
+	}
+	keyring, err := secrets.ParseKeyring(o.SecretKeys)
+	if err != nil {
+		log.Fatalf("Invalid --secret-keys: %v", err)
+	}
+	return keyring
+}
+
+// sourceProviders builds the registry of the supported agenda source types.
+// Remote calendars are downloaded through a guard that keeps them out of the
+// server's own network, except for the allowed hosts.
+func (o *Options) sourceProviders(db *gorm.DB, userAgent string) *sources.Registry {
+	guard, err := sources.NewGuard(strings.Split(o.SourceAllowHosts, ","))
+	if err != nil {
+		log.Fatalf("Invalid --source-allow-hosts: %v", err)
+	}
+	client := guard.Client(o.SyncTimeout)
+	feeds := sources.ICSProvider{Client: client, MaxFeedSize: o.SyncMaxSize, UserAgent: userAgent, Guard: guard}
+	providers := sources.NewRegistry(
+		&feeds,
+		&sources.ProtonProvider{ICSProvider: feeds},
+		&sources.CalDAVProvider{DB: db, Client: client, MaxFeedSize: o.SyncMaxSize, UserAgent: userAgent, Guard: guard},
+	)
+	if o.LocalCalendarDir != "" {
+		providers.Register(&sources.FileProvider{Dir: o.LocalCalendarDir, MaxFeedSize: o.SyncMaxSize})
+	}
+	return providers
 }
 
 func main() {
@@ -35,7 +199,7 @@ func main() {
 			// Example alternative describing the use of JWTs without documenting how
 			// they are issued or which flows might be supported. This is simpler but
 			// tells clients less information.
-			"BearerAuth": {
+			controllers.BearerAuthScheme: {
 				Type:         "http",
 				Scheme:       "bearer",
 				BearerFormat: "JWT",
@@ -47,27 +211,120 @@ func main() {
 		dsn := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
 			options.DbHost, options.DbPort, options.DbUser, options.DbPass, options.DbName)
 
+		// Encrypt the secrets of agenda sources, before the models are used
+		keyring := options.keyring()
+		secrets.Register(keyring)
+
 		// Connect to PostgreSQL
 		db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 		if err != nil {
 			panic(err.Error())
 		}
 
-		// Create user controller
-		userController := &controllers.UserController{DB: db}
-		agendaSourceController := &controllers.AgendaSourceController{DB: db}
+		// Keep the schema in sync with the models
+		err = db.AutoMigrate(&models.User{}, &models.Session{}, &models.ApiToken{}, &models.PasswordResetToken{}, &models.RecoveryCode{}, &models.OidcIdentity{}, &models.AgendaInvite{}, &models.AgendaSource{}, &models.CalendarCollection{}, &models.CalendarObject{}, &models.SyncRun{}, &models.AgendaItem{}, &models.ProceduralAgenda{})
+		if err != nil {
+			panic(err.Error())
+		}
+
+		// Encrypt what was stored in plain text or with a previous key
+		rotated, err := keyring.Rotate(db, "agenda_sources", "url", "username", "password")
+		if err != nil {
+			panic(err.Error())
+		}
+		if rotated > 0 {
+			log.Printf("Encrypted the secrets of %d agenda sources with the current key", rotated)
+		}
+
+		if err := controllers.PromoteAdmins(db, options.adminEmails()); err != nil {
+			panic(err.Error())
+		}
+
+		authConfig := options.authConfig()
+		passwords := options.passwordHasher()
+
+		// Verify bearer tokens before any protected operation is registered
+		api.UseMiddleware(controllers.NewAuthMiddleware(api, db, authConfig))
+
+		mail := options.mailer()
+
+		// Email verification links are signed with the token key
+		verifier := &controllers.EmailVerifier{
+			Config:    authConfig,
+			Mailer:    mail,
+			PublicURL: options.PublicURL,
+			TTL:       options.EmailVerificationTTL,
+		}
+
+		// Create controllers
+		providers := options.sourceProviders(db, "ProtonAgenda/"+config.Info.Version)
+		scheduler := &sources.Scheduler{
+			Syncer: &sources.Syncer{
+				DB:        db,
+				Providers: providers,
+				Horizon:   options.SyncHorizon,
+				History:   options.SyncHistory,
+			},
+			Interval:   options.SyncInterval,
+			MaxBackoff: options.SyncMaxBackoff,
+			Workers:    options.SyncWorkers,
+			// Without a default interval, only sources with their own are synced
+			OwnIntervalsOnly: options.SyncInterval == 0,
+		}
+
+		userController := &controllers.UserController{
+			DB:                    db,
+			Passwords:             passwords,
+			Verifier:              verifier,
+			Providers:             providers,
+			PasswordLoginDisabled: options.DisablePasswordLogin,
+		}
+		passwordResetController := &controllers.PasswordResetController{
+			DB:                    db,
+			Passwords:             passwords,
+			Mailer:                mail,
+			PublicURL:             options.PublicURL,
+			TTL:                   options.PasswordResetTTL,
+			PasswordLoginDisabled: options.DisablePasswordLogin,
+		}
+		agendaSourceController := &controllers.AgendaSourceController{DB: db, Providers: providers, Scheduler: scheduler}
+		authController := &controllers.AuthController{
+			DB:                    db,
+			Config:                authConfig,
+			Passwords:             passwords,
+			OidcProviders:         options.oidcProviders(),
+			PasswordLoginDisabled: options.DisablePasswordLogin,
+		}
+		apiTokenController := &controllers.ApiTokenController{DB: db}
+		agendaInviteController := &controllers.AgendaInviteController{DB: db}
+		adminController := &controllers.AdminController{DB: db}
 
 		// Register all routes
-		addRoutes(api, userController, agendaSourceController)
+		addRoutes(api, userController, passwordResetController, agendaSourceController, authController, apiTokenController, agendaInviteController, adminController)
+
+		server := &http.Server{Addr: fmt.Sprintf(":%d", options.Port), Handler: router}
 
 		// Tell the CLI how to start the router
 		hooks.OnStart(func() {
+			scheduler.Start()
 			fmt.Printf("Server started on port %d\n", options.Port)
-			err := http.ListenAndServe(fmt.Sprintf(":%d", options.Port), router)
-			if err != nil {
+			err := server.ListenAndServe()
+			if err != nil && err != http.ErrServerClosed {
 				log.Fatalf("Error starting server: %v", err)
 			}
 		})
+
+		// Cancel the running syncs and finish the running requests before exiting
+		hooks.OnStop(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := scheduler.Stop(ctx); err != nil {
+				log.Printf("Error stopping the sync scheduler: %v", err)
+			}
+			if err := server.Shutdown(ctx); err != nil {
+				log.Printf("Error stopping server: %v", err)
+			}
+		})
 	})
 
 	// Run the CLI
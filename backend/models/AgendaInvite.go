@@ -66,6 +66,18 @@ type AgendaInvite struct {
 	PaddingBefore     time.Duration
 	PaddingAfter      time.Duration
 	SlotSizes         Durations          `gorm:"type:json"` // Store durations as JSON array
+	Timezone          string             // empty to use the owner's timezone
+	WorkingHours      WorkingHours       `gorm:"type:json"` // nil to use the owner's working hours
+	Visibility        string             // one of the Visibility constants for all of its sources, empty to use their own
 	AgendaSources     []AgendaSource     `gorm:"many2many:invite_sources;"`
 	ProceduralAgendas []ProceduralAgenda `gorm:"many2many:invite_procedural_agendas;"`
 }
+
+// SourceVisibility returns how much of the items of one of its sources the
+// invite shows: its own visibility if it has one, otherwise the source's
+func (i *AgendaInvite) SourceVisibility(source *AgendaSource) string {
+	if i.Visibility != "" {
+		return i.Visibility
+	}
+	return source.ItemVisibility()
+}
@@ -11,7 +11,15 @@ type AgendaItem struct {
 	ResourceID     uuid.UUID `gorm:"type:uuid;default:gen_random_uuid()"`
 	StartTime      time.Time `gorm:"index"`
 	EndTime        time.Time
-	Description    string
-	AgendaSourceID uint
+	AllDay         bool   // StartTime and EndTime are midnight in the owner's timezone
+	Timezone       string // IANA timezone the event was scheduled in, empty for all-day and floating events
+	Description    string // the title of the item
+	Location       string
+	Notes          string     // further details, like the description of a synced event
+	AgendaSourceID uint       `gorm:"index:idx_agenda_items_source_external,unique,priority:1,where:external_id <> ''"`
+	ExternalID     string     `gorm:"index:idx_agenda_items_source_external,unique,priority:2"` // ID of the event occurrence in the source's calendar, empty for items that weren't synced
+	ExternalUID    string     `gorm:"index"`                                                    // UID of the event the item is an occurrence of
+	Sequence       int        // revision of the event when it was synced
+	LastModified   *time.Time // when the event was last modified, nil when the calendar doesn't tell
 	UserID         uint
 }
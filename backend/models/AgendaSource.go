@@ -1,15 +1,60 @@
 package models
 
 import (
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 )
 
+// Visibility levels, which tell how much of their agenda items invites show
+const (
+	VisibilityBusy  = "busy"  // only when the items take place, as busy time
+	VisibilityTitle = "title" // also their titles
+	VisibilityFull  = "full"  // also their locations and notes
+)
+
 type AgendaSource struct {
 	gorm.Model
 	ResourceID  uuid.UUID `gorm:"type:uuid;default:gen_random_uuid()"`
-	Url         string
+	Url         string    `gorm:"serializer:secret"`      // encrypted, as share links grant access to the calendar
+	URLRedacted bool      `gorm:"not null;default:false"` // imported without its real URL, not synced until the owner sets it
 	Type        string
 	UserID      uint
-	AgendaItems []AgendaItem `gorm:"constraint:OnDelete:SET NULL;"`
+	Username    string               `gorm:"serializer:secret"` // credentials for sources that log in, like CalDAV servers
+	Password    string               `gorm:"serializer:secret"`
+	AgendaItems []AgendaItem         `gorm:"constraint:OnDelete:SET NULL;"`
+	Calendars   []CalendarCollection `gorm:"constraint:OnDelete:CASCADE;"`
+	SyncRuns    []SyncRun            `gorm:"constraint:OnDelete:CASCADE;"`
+	Visibility  string               `gorm:"default:title"` // one of the Visibility constants, invites may override it
+
+	SyncInterval time.Duration // time between syncs, 0 for the server's default
+	NextSyncAt   *time.Time    `gorm:"index"` // nil when the source is due right away
+	SyncFailures int           // consecutive failed syncs, which back off the next one
+	SyncedAt     *time.Time    // when the events were last stored, nil before the first sync
+	ETag         string        // validators of the last downloaded calendar, for conditional requests
+	LastModified string
+
+	LastSyncedAt   *time.Time // when the last sync finished, whether or not it worked
+	LastSyncStatus string     // outcome of the last sync, one of the SyncStatus constants
+	LastError      string     // why the last sync failed, empty when it worked
+	ItemCount      int        // agenda items of the source after the last sync that worked
+}
+
+// ItemVisibility returns the source's visibility, which is VisibilityTitle
+// for sources stored without one
+func (s *AgendaSource) ItemVisibility() string {
+	if s.Visibility == "" {
+		return VisibilityTitle
+	}
+	return s.Visibility
+}
+
+// ValidVisibility reports whether v is one of the Visibility constants
+func ValidVisibility(v string) bool {
+	switch v {
+	case VisibilityBusy, VisibilityTitle, VisibilityFull:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+type Scopes []string
+
+// Value implements the driver.Valuer interface for database serialization
+func (s Scopes) Value() (driver.Value, error) {
+	if s == nil {
+		return json.Marshal([]string{})
+	}
+	return json.Marshal([]string(s))
+}
+
+// Scan implements the sql.Scanner interface for database deserialization
+func (s *Scopes) Scan(value interface{}) error {
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to scan Scopes: value is not []byte")
+	}
+	return json.Unmarshal(bytes, (*[]string)(s))
+}
+
+// Has reports whether all the given scopes are granted
+func (s Scopes) Has(required ...string) bool {
+	for _, r := range required {
+		found := false
+		for _, granted := range s {
+			if granted == r {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// ApiToken is a named personal access token for scripts and sync agents
+type ApiToken struct {
+	gorm.Model
+	ResourceID uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	UserID     uint      `gorm:"index"`
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"` // SHA-256 of the token, the token itself is never stored
+	Prefix     string // first characters of the token, to help users recognise it
+	Scopes     Scopes `gorm:"type:json"`
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+}
+
+// Expired reports whether the token has passed its optional expiry
+func (t *ApiToken) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
@@ -0,0 +1,22 @@
+package models
+
+import "gorm.io/gorm"
+
+// CalendarCollection is a calendar on the CalDAV server of an agenda source.
+// Its objects are kept so that later syncs only download what changed.
+type CalendarCollection struct {
+	gorm.Model
+	AgendaSourceID uint             `gorm:"uniqueIndex:idx_calendar_collections_href,priority:1"`
+	Href           string           `gorm:"uniqueIndex:idx_calendar_collections_href,priority:2"`
+	SyncToken      string           // token of the last sync-collection report, empty when the server doesn't support them
+	Objects        []CalendarObject `gorm:"constraint:OnDelete:CASCADE;"`
+}
+
+// CalendarObject is an iCalendar resource of a calendar collection
+type CalendarObject struct {
+	gorm.Model
+	CalendarCollectionID uint   `gorm:"uniqueIndex:idx_calendar_objects_href,priority:1"`
+	Href                 string `gorm:"uniqueIndex:idx_calendar_objects_href,priority:2"`
+	ETag                 string
+	Data                 string
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// OidcIdentity links a user to their account at an OpenID Connect provider
+type OidcIdentity struct {
+	gorm.Model
+	UserID      uint   `gorm:"index"`
+	Issuer      string `gorm:"uniqueIndex:idx_oidc_identities_issuer_subject"`
+	Subject     string `gorm:"uniqueIndex:idx_oidc_identities_issuer_subject"` // the stable "sub" claim of the provider
+	LastLoginAt time.Time
+}
@@ -0,0 +1,20 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// PasswordResetToken is a single-use token emailed to recover an account
+type PasswordResetToken struct {
+	gorm.Model
+	UserID    uint   `gorm:"index"`
+	TokenHash string `gorm:"uniqueIndex"` // SHA-256 of the token, the token itself is never stored
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// Usable reports whether the token can still be redeemed at the given time
+func (t *PasswordResetToken) Usable(now time.Time) bool {
+	return t.UsedAt == nil && now.Before(t.ExpiresAt)
+}
@@ -0,0 +1,77 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Defaults for users who haven't set their preferences
+const (
+	DefaultTimezone  = "UTC"
+	DefaultLocale    = "en"
+	DefaultWeekStart = time.Monday
+)
+
+// WorkingPeriod is a recurring range of working time on one day of the week.
+// Start and End are minutes since midnight in the user's timezone.
+type WorkingPeriod struct {
+	Weekday time.Weekday `json:"weekday"`
+	Start   int          `json:"start"`
+	End     int          `json:"end"`
+}
+
+// WorkingHours is a weekly template of working periods
+type WorkingHours []WorkingPeriod
+
+// DefaultWorkingHours returns the template used until a user sets their own:
+// Monday to Friday, 9:00 to 17:00
+func DefaultWorkingHours() WorkingHours {
+	hours := make(WorkingHours, 0, 5)
+	for day := time.Monday; day <= time.Friday; day++ {
+		hours = append(hours, WorkingPeriod{Weekday: day, Start: 9 * 60, End: 17 * 60})
+	}
+	return hours
+}
+
+// Value implements the driver.Valuer interface for database serialization
+func (w WorkingHours) Value() (driver.Value, error) {
+	if w == nil {
+		return nil, nil
+	}
+	return json.Marshal([]WorkingPeriod(w))
+}
+
+// Scan implements the sql.Scanner interface for database deserialization
+func (w *WorkingHours) Scan(value interface{}) error {
+	if value == nil {
+		*w = nil
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("failed to scan WorkingHours: value is not []byte")
+	}
+	return json.Unmarshal(bytes, (*[]WorkingPeriod)(w))
+}
+
+// Location returns the user's timezone, or UTC when it isn't set or no longer known
+func (u *User) Location() *time.Location {
+	if u.Timezone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(u.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// EffectiveWorkingHours returns the user's working hours template, or the default one
+func (u *User) EffectiveWorkingHours() WorkingHours {
+	if u.WorkingHours == nil {
+		return DefaultWorkingHours()
+	}
+	return u.WorkingHours
+}
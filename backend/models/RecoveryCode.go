@@ -0,0 +1,14 @@
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// RecoveryCode is a single-use code replacing a TOTP code when the authenticator is lost
+type RecoveryCode struct {
+	gorm.Model
+	UserID   uint   `gorm:"index"`
+	CodeHash string // SHA-256 of the code, the code itself is never stored
+	UsedAt   *time.Time
+}
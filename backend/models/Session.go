@@ -0,0 +1,25 @@
+package models
+
+import (
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"time"
+)
+
+// Session is a login of a user, kept alive by a rotating refresh token
+type Session struct {
+	gorm.Model
+	ResourceID               uuid.UUID `gorm:"type:uuid;default:gen_random_uuid();uniqueIndex"`
+	UserID                   uint      `gorm:"index"`
+	RefreshTokenHash         string    `gorm:"uniqueIndex"` // SHA-256 of the current refresh token
+	PreviousRefreshTokenHash string    `gorm:"index"`       // SHA-256 of the rotated-out token, used to detect reuse
+	UserAgent                string
+	ExpiresAt                time.Time
+	LastUsedAt               time.Time
+	RevokedAt                *time.Time
+}
+
+// Active reports whether the session can still be used at the given time
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outcomes of a sync
+const (
+	SyncStatusSuccess     = "success"
+	SyncStatusNotModified = "not_modified" // the calendar didn't change since the last sync
+	SyncStatusFailed      = "failed"
+)
+
+// What started a sync
+const (
+	SyncTriggerScheduled = "scheduled"
+	SyncTriggerManual    = "manual"
+)
+
+// SyncRun records a sync of an agenda source
+type SyncRun struct {
+	gorm.Model
+	ResourceID     uuid.UUID `gorm:"type:uuid;default:gen_random_uuid()"`
+	AgendaSourceID uint      `gorm:"index"`
+	Trigger        string
+	StartedAt      time.Time
+	Duration       time.Duration
+	Status         string
+	Error          string // why the sync failed, empty otherwise
+	Added          int
+	Updated        int
+	Removed        int
+}
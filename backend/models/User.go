@@ -3,14 +3,51 @@ package models
 import (
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"time"
+)
+
+// Roles a user can have
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
 )
 
 type User struct {
 	gorm.Model
-	ResourceID    uuid.UUID `gorm:"type:uuid;default:gen_random_uuid()"`
-	Email         string
-	PasswordHash  string
-	AgendaSources []AgendaSource `gorm:"constraint:OnDelete:CASCADE;"`
-	AgendaItems   []AgendaItem   `gorm:"constraint:OnDelete:CASCADE;"`
-	AgendaInvites []AgendaInvite `gorm:"constraint:OnDelete:CASCADE;"`
+	ResourceID      uuid.UUID `gorm:"type:uuid;default:gen_random_uuid()"`
+	Email           string    `gorm:"index:idx_users_email,unique,expression:lower(email),where:deleted_at IS NULL"` // unique regardless of case
+	PasswordHash    string
+	Role            string               `gorm:"not null;default:user"`
+	DisabledAt      *time.Time           // set while an admin has disabled the account
+	EmailVerifiedAt *time.Time           // nil until the user confirms the verification link
+	TotpSecret      string               // base32 RFC 6238 secret, set during enrollment
+	TotpEnabledAt   *time.Time           // nil until enrollment is confirmed with a valid code
+	TotpLastStep    int64                // last accepted time step, so a code can't be replayed
+	MfaLoginID      string               // ID of the token of the pending second login step, empty when there is none
+	MfaLoginTries   int                  // codes entered with that token, which allows only a few
+	AgendaSources   []AgendaSource       `gorm:"constraint:OnDelete:CASCADE;"`
+	AgendaItems     []AgendaItem         `gorm:"constraint:OnDelete:CASCADE;"`
+	AgendaInvites   []AgendaInvite       `gorm:"constraint:OnDelete:CASCADE;"`
+	Sessions        []Session            `gorm:"constraint:OnDelete:CASCADE;"`
+	ApiTokens       []ApiToken           `gorm:"constraint:OnDelete:CASCADE;"`
+	ResetTokens     []PasswordResetToken `gorm:"constraint:OnDelete:CASCADE;"`
+	RecoveryCodes   []RecoveryCode       `gorm:"constraint:OnDelete:CASCADE;"`
+	OidcIdentities  []OidcIdentity       `gorm:"constraint:OnDelete:CASCADE;"`
+
+	// Profile preferences
+	DisplayName  string
+	Timezone     string       `gorm:"not null;default:UTC"` // IANA timezone name
+	Locale       string       `gorm:"not null;default:en"`  // BCP 47 language tag
+	WeekStart    time.Weekday `gorm:"not null;default:1"`
+	WorkingHours WorkingHours `gorm:"type:json"` // nil until set, see EffectiveWorkingHours
+}
+
+// IsAdmin reports whether the user may use the administration operations
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// Disabled reports whether the account has been disabled by an admin
+func (u *User) Disabled() bool {
+	return u.DisabledAt != nil
 }
@@ -1,16 +1,22 @@
 package models
 
 import (
+	"awesomeProject/secrets"
+	"bytes"
 	"fmt"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 )
 
+// testKeyring encrypts the secrets of agenda sources in the tests
+var testKeyring, _ = secrets.NewKeyring(secrets.Key{ID: "test", Secret: bytes.Repeat([]byte{7}, secrets.KeySize)})
+
 // Setup PostgreSQL database for tests
 func setupTestDB() (*gorm.DB, error) {
 	// Use environment variables or default values
@@ -38,6 +44,8 @@ func setupTestDB() (*gorm.DB, error) {
 	// Connection string
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
 
+	secrets.Register(testKeyring)
+
 	// Connect to PostgreSQL
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
@@ -45,7 +53,7 @@ func setupTestDB() (*gorm.DB, error) {
 	}
 
 	// Auto-migrate all models
-	err = db.AutoMigrate(&User{}, &AgendaInvite{}, &AgendaSource{}, &AgendaItem{}, &ProceduralAgenda{})
+	err = db.AutoMigrate(&User{}, &Session{}, &ApiToken{}, &PasswordResetToken{}, &RecoveryCode{}, &AgendaInvite{}, &AgendaSource{}, &AgendaItem{}, &ProceduralAgenda{})
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +67,7 @@ func TestUserCreation(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Email:        "test@example.com",
+		Email:        "test-" + uuid.NewString() + "@example.com",
 		PasswordHash: "hashedpassword",
 	}
 
@@ -81,7 +89,7 @@ func TestAgendaInviteCreation(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Email:        "testuser@example.com",
+		Email:        "testuser-" + uuid.NewString() + "@example.com",
 		PasswordHash: "hashedpassword",
 	}
 	db.Create(&user)
@@ -117,7 +125,7 @@ func TestAgendaSourceAndItemRelations(t *testing.T) {
 	assert.NoError(t, err)
 
 	user := User{
-		Email:        "testuser@example.com",
+		Email:        "testuser-" + uuid.NewString() + "@example.com",
 		PasswordHash: "hashedpassword",
 	}
 	db.Create(&user)
@@ -144,6 +152,32 @@ func TestAgendaSourceAndItemRelations(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, fetchedSource.AgendaItems, 1)
 	assert.Equal(t, "Sample Agenda Item", fetchedSource.AgendaItems[0].Description)
+	assert.Equal(t, VisibilityTitle, fetchedSource.Visibility)
+}
+
+// Test which visibility invites show the items of their sources with
+func TestAgendaInviteSourceVisibility(t *testing.T) {
+	busy := &AgendaSource{Visibility: VisibilityBusy}
+	full := &AgendaSource{Visibility: VisibilityFull}
+	unset := &AgendaSource{}
+
+	invite := &AgendaInvite{}
+	assert.Equal(t, VisibilityBusy, invite.SourceVisibility(busy))
+	assert.Equal(t, VisibilityFull, invite.SourceVisibility(full))
+	assert.Equal(t, VisibilityTitle, invite.SourceVisibility(unset))
+
+	// The invite's own visibility applies to all of its sources
+	invite.Visibility = VisibilityTitle
+	assert.Equal(t, VisibilityTitle, invite.SourceVisibility(busy))
+	assert.Equal(t, VisibilityTitle, invite.SourceVisibility(full))
+}
+
+func TestValidVisibility(t *testing.T) {
+	for _, v := range []string{VisibilityBusy, VisibilityTitle, VisibilityFull} {
+		assert.True(t, ValidVisibility(v), v)
+	}
+	assert.False(t, ValidVisibility(""))
+	assert.False(t, ValidVisibility("Title"))
 }
 
 // Test ProceduralAgenda Creation
@@ -176,7 +210,7 @@ func TestAgendaInviteProceduralAgendaRelationship(t *testing.T) {
 
 	// Create a user for the invite
 	user := User{
-		Email:        "testuser@example.com",
+		Email:        "testuser-" + uuid.NewString() + "@example.com",
 		PasswordHash: "hashedpassword",
 	}
 	result := db.Create(&user)
@@ -0,0 +1,118 @@
+package oidc_test
+
+import (
+	"awesomeProject/oidc"
+	"awesomeProject/oidc/oidctest"
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	stub := oidctest.NewServer("agenda", "s3cret")
+	t.Cleanup(stub.Close)
+	stub.Identity = oidctest.Identity{Subject: "user-1", Email: "user@example.com", EmailVerified: true}
+
+	provider, err := oidc.NewProvider(oidc.Config{
+		Name:         "stub",
+		Issuer:       stub.Issuer(),
+		ClientID:     "agenda",
+		ClientSecret: "s3cret",
+		RedirectURL:  "https://agenda.example.com/oidc/stub/callback",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	return stub, provider
+}
+
+// Test the authorization code flow with PKCE
+func TestAuthorizationCodeFlow(t *testing.T) {
+	stub, provider := newTestProvider(t)
+	ctx := context.Background()
+
+	verifier, err := oidc.RandomString()
+	assert.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, "state-1", "nonce-1", oidc.S256Challenge(verifier))
+	assert.NoError(t, err)
+
+	parsed, err := url.Parse(authURL)
+	assert.NoError(t, err)
+	assert.Equal(t, "openid email profile", parsed.Query().Get("scope"))
+	assert.Equal(t, "S256", parsed.Query().Get("code_challenge_method"))
+
+	t.Run("Valid code", func(t *testing.T) {
+		code, state, err := stub.Authorize(authURL)
+		assert.NoError(t, err)
+		assert.Equal(t, "state-1", state)
+
+		claims, err := provider.Exchange(ctx, code, verifier)
+		assert.NoError(t, err)
+		assert.Equal(t, "user-1", claims.Subject)
+		assert.Equal(t, "user@example.com", claims.Email)
+		assert.True(t, claims.EmailVerified)
+		assert.Equal(t, "nonce-1", claims.Nonce)
+
+		// Codes are single use
+		_, err = provider.Exchange(ctx, code, verifier)
+		var tokenErr *oidc.TokenError
+		assert.True(t, errors.As(err, &tokenErr))
+	})
+
+	t.Run("Wrong code verifier", func(t *testing.T) {
+		code, _, err := stub.Authorize(authURL)
+		assert.NoError(t, err)
+
+		_, err = provider.Exchange(ctx, code, "not-the-verifier")
+		var tokenErr *oidc.TokenError
+		if assert.True(t, errors.As(err, &tokenErr)) {
+			assert.Equal(t, "invalid_grant", tokenErr.Code)
+		}
+	})
+}
+
+// Test that ID tokens are checked beyond their signature
+func TestVerify(t *testing.T) {
+	stub, provider := newTestProvider(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	valid := jwt.MapClaims{
+		"iss": stub.Issuer(),
+		"sub": "user-1",
+		"aud": "agenda",
+		"iat": now.Unix(),
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	_, err := provider.Verify(ctx, stub.SignIDToken(valid))
+	assert.NoError(t, err)
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"Other audience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"Other issuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"Expired":        func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Hour).Unix() },
+		"No subject":     func(c jwt.MapClaims) { delete(c, "sub") },
+	} {
+		t.Run(name, func(t *testing.T) {
+			claims := jwt.MapClaims{}
+			for k, v := range valid {
+				claims[k] = v
+			}
+			change(claims)
+			_, err := provider.Verify(ctx, stub.SignIDToken(claims))
+			assert.Error(t, err)
+		})
+	}
+
+	t.Run("Unsigned token", func(t *testing.T) {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodNone, valid).SignedString(jwt.UnsafeAllowNoneSignatureType)
+		assert.NoError(t, err)
+		_, err = provider.Verify(ctx, token)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,228 @@
+// Package oidctest provides a minimal in-process OpenID Connect provider for tests
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity is the user the stub provider logs in
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// authorization is a pending authorization code
+type authorization struct {
+	identity      Identity
+	redirectURI   string
+	nonce         string
+	codeChallenge string
+}
+
+// Server is a stub provider supporting discovery, the authorization code flow
+// with PKCE (S256 only) and RS256 signed ID tokens
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+	// Identity is logged in by the authorization endpoint
+	Identity Identity
+
+	key   *rsa.PrivateKey
+	kid   string
+	mu    sync.Mutex
+	codes map[string]*authorization
+}
+
+// NewServer starts a stub provider with a fresh signing key. Close it when done.
+func NewServer(clientID, clientSecret string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		kid:          "stub-key-1",
+		codes:        map[string]*authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("/authorize", s.handleAuthorize)
+	mux.HandleFunc("/token", s.handleToken)
+	mux.HandleFunc("/jwks", s.handleJwks)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Issuer returns the issuer identifier of the stub
+func (s *Server) Issuer() string {
+	return s.URL
+}
+
+// Authorize performs the user's side of the flow for an authorization URL:
+// it logs in the current Identity and returns the code and state that the
+// provider would pass to the redirect URI
+func (s *Server) Authorize(authURL string) (code, state string, err error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := parsed.Query()
+	if query.Get("client_id") != s.ClientID {
+		return "", "", fmt.Errorf("unknown client %q", query.Get("client_id"))
+	}
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		return "", "", fmt.Errorf("unsupported authorization request %q", parsed.RawQuery)
+	}
+
+	code = randomString()
+	s.mu.Lock()
+	s.codes[code] = &authorization{
+		identity:      s.Identity,
+		redirectURI:   query.Get("redirect_uri"),
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+	}
+	s.mu.Unlock()
+	return code, query.Get("state"), nil
+}
+
+// SignIDToken signs arbitrary claims with the stub's key, e.g. to test rejection of bad tokens
+func (s *Server) SignIDToken(claims jwt.Claims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = s.kid
+	signed, err := token.SignedString(s.key)
+	if err != nil {
+		panic(err)
+	}
+	return signed
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	code, state, err := s.Authorize(r.URL.String())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	redirect, err := url.Parse(r.URL.Query().Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	query := redirect.Query()
+	query.Set("code", code)
+	query.Set("state", state)
+	redirect.RawQuery = query.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": code})
+	}
+	if r.Method != http.MethodPost || r.ParseForm() != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		tokenError("invalid_request")
+		return
+	}
+	if s.ClientSecret != "" {
+		id, secret, ok := r.BasicAuth()
+		if !ok || id != s.ClientID || secret != s.ClientSecret {
+			tokenError("invalid_client")
+			return
+		}
+	}
+
+	s.mu.Lock()
+	auth, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+	if !ok || auth.redirectURI != r.PostForm.Get("redirect_uri") {
+		tokenError("invalid_grant")
+		return
+	}
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.codeChallenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	now := time.Now()
+	idToken := s.SignIDToken(jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            auth.identity.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.identity.Email,
+		"email_verified": auth.identity.EmailVerified,
+		"name":           auth.identity.Name,
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (s *Server) handleJwks(w http.ResponseWriter, r *http.Request) {
+	encode := func(i *big.Int) string {
+		return base64.RawURLEncoding.EncodeToString(i.Bytes())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": s.kid,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   encode(s.key.N),
+			"e":   encode(big.NewInt(int64(s.key.E))),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
@@ -0,0 +1,188 @@
+// Package secrets encrypts sensitive columns, like the URLs and credentials
+// of agenda sources, with envelope encryption: every value is sealed with its
+// own data key, which is sealed in turn with a key of the keyring.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// prefix marks sealed values and the version of their format:
+// enc1:<key ID>:<sealed data key>:<sealed value>
+const prefix = "enc1:"
+
+// KeySize is the size of the keys of a keyring, which are AES-256 keys
+const KeySize = 32
+
+// ErrUnknownKey is returned for values sealed with a key the keyring doesn't have
+var ErrUnknownKey = errors.New("the value was encrypted with an unknown key")
+
+// Key is a key of a keyring
+type Key struct {
+	// ID identifies the key in the values it sealed
+	ID     string
+	Secret []byte
+}
+
+// Keyring seals values with its current key and opens values sealed with any
+// of its keys, so that keys can be rotated: a new key is put first, the
+// values are sealed again with Rotate, and then the old key can be dropped.
+type Keyring struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewKeyring creates a keyring whose first key is the current one
+func NewKeyring(keys ...Key) (*Keyring, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("no keys")
+	}
+	k := &Keyring{current: keys[0].ID, keys: map[string]cipher.AEAD{}}
+	for _, key := range keys {
+		if key.ID == "" || strings.ContainsAny(key.ID, ":,") {
+			return nil, fmt.Errorf("invalid key ID %q", key.ID)
+		}
+		if _, ok := k.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key ID %q", key.ID)
+		}
+		if len(key.Secret) != KeySize {
+			return nil, fmt.Errorf("key %q must be %d bytes long", key.ID, KeySize)
+		}
+		aead, err := newAEAD(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+		k.keys[key.ID] = aead
+	}
+	return k, nil
+}
+
+// ParseKeyring creates a keyring from comma-separated id:key pairs with
+// base64-encoded keys, the current key first
+func ParseKeyring(spec string) (*Keyring, error) {
+	var keys []Key
+	for _, pair := range strings.Split(spec, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(pair, ":")
+		if !ok {
+			return nil, fmt.Errorf("key %q must be written as id:key", pair)
+		}
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q is not base64-encoded", id)
+		}
+		keys = append(keys, Key{ID: id, Secret: secret})
+	}
+	return NewKeyring(keys...)
+}
+
+// Seal encrypts a value with a new data key, which is encrypted with the
+// current key. The value can only be opened for the same purpose, like the
+// name of the column it's stored in.
+func (k *Keyring) Seal(value, purpose string) (string, error) {
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	sealedKey, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealedValue, err := seal(aead, []byte(value), []byte(purpose))
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	return prefix + k.current + ":" + encoding.EncodeToString(sealedKey) + ":" + encoding.EncodeToString(sealedValue), nil
+}
+
+// Open decrypts a value Seal returned for the same purpose. Values that
+// aren't sealed are returned as they are, so that those stored before they
+// were encrypted stay readable until they are rotated.
+func (k *Keyring) Open(value, purpose string) (string, error) {
+	if !Sealed(value) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", errors.New("malformed encrypted value")
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownKey, parts[0])
+	}
+	sealedKey, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	sealedValue, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.New("malformed encrypted value")
+	}
+	dataKey, err := open(kek, sealedKey, []byte(parts[0]))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := open(aead, sealedValue, []byte(purpose))
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Current reports whether a value is sealed with the current key
+func (k *Keyring) Current(value string) bool {
+	return strings.HasPrefix(value, prefix+k.current+":")
+}
+
+// Sealed reports whether a value was sealed by a keyring
+func Sealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// newAEAD returns AES-GCM with a key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts data with a random nonce, which is put in front of it
+func seal(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, additional), nil
+}
+
+// open decrypts what seal returned
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("malformed encrypted value")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, errors.New("the encrypted value can't be decrypted")
+	}
+	return plain, nil
+}
@@ -0,0 +1,115 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// SerializerName is the gorm serializer that encrypts string fields, as in
+// `gorm:"serializer:secret"`
+const SerializerName = "secret"
+
+// rotateBatch is how many rows Rotate reads at once
+const rotateBatch = 100
+
+// Serializer encrypts string fields with a keyring when they are stored and
+// decrypts them when they are read. Values are sealed for their column, so
+// they can't be moved to another one. Empty strings are stored as they are.
+type Serializer struct {
+	Keyring *Keyring
+}
+
+// Register makes gorm encrypt the fields tagged with the secret serializer
+// with a keyring. It must be called before the models are first used.
+func Register(keyring *Keyring) {
+	schema.RegisterSerializer(SerializerName, Serializer{Keyring: keyring})
+}
+
+// Scan decrypts a value read from the database
+func (s Serializer) Scan(ctx context.Context, field *schema.Field, dst reflect.Value, dbValue interface{}) error {
+	var value string
+	switch v := dbValue.(type) {
+	case nil:
+	case string:
+		value = v
+	case []byte:
+		value = string(v)
+	default:
+		return fmt.Errorf("invalid encrypted value of type %T", dbValue)
+	}
+	if value != "" {
+		if s.Keyring == nil {
+			return errors.New("no keyring to decrypt with")
+		}
+		var err error
+		if value, err = s.Keyring.Open(value, field.DBName); err != nil {
+			return fmt.Errorf("%s: %w", field.DBName, err)
+		}
+	}
+	field.ReflectValueOf(ctx, dst).SetString(value)
+	return nil
+}
+
+// Value encrypts a value written to the database
+func (s Serializer) Value(ctx context.Context, field *schema.Field, dst reflect.Value, fieldValue interface{}) (interface{}, error) {
+	value, ok := fieldValue.(string)
+	if !ok {
+		return nil, fmt.Errorf("invalid field type %T for the secret serializer, only string is supported", fieldValue)
+	}
+	if value == "" {
+		return "", nil
+	}
+	if s.Keyring == nil {
+		return nil, errors.New("no keyring to encrypt with")
+	}
+	return s.Keyring.Seal(value, field.DBName)
+}
+
+// Rotate seals the values of columns of a table again with the current key,
+// when they were sealed with another key or stored before they were
+// encrypted. Soft-deleted rows are included. It returns the number of rows
+// it updated.
+func (k *Keyring) Rotate(db *gorm.DB, table string, columns ...string) (int, error) {
+	rotated := 0
+	var last any = 0
+	for {
+		var rows []map[string]any
+		err := db.Table(table).Select(append([]string{"id"}, columns...)).
+			Where("id > ?", last).Order("id").Limit(rotateBatch).Find(&rows).Error
+		if err != nil {
+			return rotated, err
+		}
+		for _, row := range rows {
+			updates := map[string]any{}
+			for _, column := range columns {
+				value, _ := row[column].(string)
+				if value == "" || k.Current(value) {
+					continue
+				}
+				plain, err := k.Open(value, column)
+				if err != nil {
+					return rotated, fmt.Errorf("%s %v %s: %w", table, row["id"], column, err)
+				}
+				if updates[column], err = k.Seal(plain, column); err != nil {
+					return rotated, err
+				}
+			}
+			if len(updates) == 0 {
+				continue
+			}
+			if err := db.Table(table).Where("id = ?", row["id"]).UpdateColumns(updates).Error; err != nil {
+				return rotated, err
+			}
+			rotated++
+		}
+		if len(rows) < rotateBatch {
+			return rotated, nil
+		}
+		last = rows[len(rows)-1]["id"]
+	}
+}
@@ -0,0 +1,187 @@
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testKey returns a key filled with one byte
+func testKey(id string, fill byte) Key {
+	return Key{ID: id, Secret: bytes.Repeat([]byte{fill}, KeySize)}
+}
+
+// Setup PostgreSQL database for tests
+func setupTestDB() (*gorm.DB, error) {
+	// Use environment variables or default values
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	user := os.Getenv("POSTGRES_USER")
+	if user == "" {
+		user = "postgres"
+	}
+	password := os.Getenv("POSTGRES_PASSWORD")
+	if password == "" {
+		password = "password"
+	}
+	dbname := os.Getenv("POSTGRES_DB")
+	if dbname == "" {
+		dbname = "test_db"
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+	return gorm.Open(postgres.Open(dsn), &gorm.Config{})
+}
+
+// Test sealing and opening values
+func TestKeyring(t *testing.T) {
+	keyring, err := NewKeyring(testKey("k1", 1))
+	assert.NoError(t, err)
+
+	sealed, err := keyring.Seal("https://calendar.proton.me/secret", "url")
+	assert.NoError(t, err)
+	assert.True(t, Sealed(sealed))
+	assert.True(t, keyring.Current(sealed))
+	assert.True(t, strings.HasPrefix(sealed, "enc1:k1:"))
+	assert.NotContains(t, sealed, "secret")
+
+	opened, err := keyring.Open(sealed, "url")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://calendar.proton.me/secret", opened)
+
+	// Every value has its own data key
+	again, err := keyring.Seal("https://calendar.proton.me/secret", "url")
+	assert.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	// Values only open for their purpose and unchanged
+	_, err = keyring.Open(sealed, "password")
+	assert.Error(t, err)
+	tampered := []byte(sealed)
+	tampered[len(tampered)-2] ^= 1
+	_, err = keyring.Open(string(tampered), "url")
+	assert.Error(t, err)
+	_, err = keyring.Open("enc1:k1:garbage", "url")
+	assert.Error(t, err)
+
+	// Values stored before encryption are read as they are
+	opened, err = keyring.Open("https://example.com/calendar.ics", "url")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://example.com/calendar.ics", opened)
+
+	t.Run("Rotation", func(t *testing.T) {
+		rotated, err := NewKeyring(testKey("k2", 2), testKey("k1", 1))
+		assert.NoError(t, err)
+		assert.False(t, rotated.Current(sealed))
+		opened, err := rotated.Open(sealed, "url")
+		assert.NoError(t, err)
+		assert.Equal(t, "https://calendar.proton.me/secret", opened)
+		resealed, err := rotated.Seal(opened, "url")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(resealed, "enc1:k2:"))
+
+		_, err = keyring.Open(resealed, "url")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	})
+
+	t.Run("Invalid keys", func(t *testing.T) {
+		_, err := NewKeyring()
+		assert.Error(t, err)
+		_, err = NewKeyring(Key{ID: "short", Secret: []byte("too short")})
+		assert.Error(t, err)
+		_, err = NewKeyring(testKey("a:b", 1))
+		assert.Error(t, err)
+		_, err = NewKeyring(testKey("k1", 1), testKey("k1", 2))
+		assert.Error(t, err)
+	})
+}
+
+// Test reading keyrings from options
+func TestParseKeyring(t *testing.T) {
+	spec := "k2:" + strings.Repeat("Ag", 21) + "g=, k1:" + strings.Repeat("AQ", 21) + "g="
+	keyring, err := ParseKeyring(spec)
+	if assert.NoError(t, err) {
+		sealed, err := keyring.Seal("value", "url")
+		assert.NoError(t, err)
+		assert.True(t, strings.HasPrefix(sealed, "enc1:k2:"))
+	}
+
+	for _, invalid := range []string{"", "no-id", "k1:not base64!", "k1:AQID"} {
+		_, err := ParseKeyring(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// secretRecord is a model with an encrypted column
+type secretRecord struct {
+	ID     uint
+	Secret string `gorm:"serializer:secret"`
+}
+
+// Test that the serializer encrypts columns and Rotate reseals them
+func TestSerializer(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	old, err := NewKeyring(testKey("k1", 1))
+	assert.NoError(t, err)
+	Register(old)
+	assert.NoError(t, db.Migrator().DropTable(&secretRecord{}))
+	assert.NoError(t, db.AutoMigrate(&secretRecord{}))
+	raw := func(id uint) string {
+		var value string
+		assert.NoError(t, db.Table("secret_records").Select("secret").Where("id = ?", id).Scan(&value).Error)
+		return value
+	}
+
+	sealed := secretRecord{Secret: "app-password"}
+	empty := secretRecord{}
+	assert.NoError(t, db.Create(&sealed).Error)
+	assert.NoError(t, db.Create(&empty).Error)
+	assert.NoError(t, db.Exec("INSERT INTO secret_records (secret) VALUES ('legacy')").Error)
+	assert.True(t, old.Current(raw(sealed.ID)))
+	assert.Equal(t, "", raw(empty.ID))
+
+	var records []secretRecord
+	assert.NoError(t, db.Order("id").Find(&records).Error)
+	if assert.Len(t, records, 3) {
+		assert.Equal(t, "app-password", records[0].Secret)
+		assert.Equal(t, "", records[1].Secret)
+		assert.Equal(t, "legacy", records[2].Secret)
+	}
+
+	// A new key reseals the values of the old one and those in plain text
+	current, err := NewKeyring(testKey("k2", 2), testKey("k1", 1))
+	assert.NoError(t, err)
+	rotated, err := current.Rotate(db, "secret_records", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rotated)
+	for _, record := range records {
+		if record.Secret != "" {
+			assert.True(t, current.Current(raw(record.ID)))
+		}
+	}
+	rotated, err = current.Rotate(db, "secret_records", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, rotated)
+
+	// After which the old key isn't needed anymore
+	next, err := NewKeyring(testKey("k2", 2))
+	assert.NoError(t, err)
+	opened, err := next.Open(raw(records[0].ID), "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, "app-password", opened)
+}
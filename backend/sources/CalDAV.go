@@ -0,0 +1,699 @@
+package sources
+
+import (
+	"awesomeProject/ics"
+	"awesomeProject/models"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TypeCalDAV is the type of agenda sources that are CalDAV (RFC 4791) servers
+const TypeCalDAV = "caldav"
+
+// nsDAV is the XML namespace of WebDAV
+const nsDAV = "DAV:"
+
+// maxSyncPages bounds how often a truncated sync-collection report is continued
+const maxSyncPages = 100
+
+// multigetBatch is how many objects a calendar-multiget report asks for
+const multigetBatch = 100
+
+// CalDAVProvider reads the calendars of a CalDAV server. The URL of a source
+// can point to a calendar, or to a principal or calendar home whose event
+// calendars are then discovered. Servers that support sync-collection reports
+// (RFC 6578) are synced incrementally, with the calendar objects kept in the
+// database; others are asked for the events within the sync window.
+type CalDAVProvider struct {
+	DB *gorm.DB
+	// Client sends the requests, http.DefaultClient when nil
+	Client *http.Client
+	// MaxFeedSize bounds the size of a response, DefaultMaxFeedSize when zero
+	MaxFeedSize int64
+	// UserAgent is sent with the requests
+	UserAgent string
+	// Guard rejects URLs of the server's own network, any URL is allowed when
+	// nil. Client should connect through it, see Guard.Client.
+	Guard *Guard
+}
+
+// Type returns TypeCalDAV
+func (p *CalDAVProvider) Type() string {
+	return TypeCalDAV
+}
+
+// Capabilities of CalDAV servers
+func (p *CalDAVProvider) Capabilities() Capabilities {
+	return Capabilities{Remote: true, Schemes: []string{"https", "http"}, Credentials: true}
+}
+
+// ValidateURL checks that a URL is an absolute HTTP(S) URL the guard allows
+func (p *CalDAVProvider) ValidateURL(rawURL string) error {
+	parsed, err := feedURL(rawURL, p.Capabilities().Schemes)
+	if err != nil {
+		return err
+	}
+	return p.Guard.CheckURL(parsed)
+}
+
+// Fetch syncs the calendars of a source and returns their objects, one
+// VCALENDAR after another
+func (p *CalDAVProvider) Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) ([]byte, error) {
+	base, err := feedURL(source.Url, p.Capabilities().Schemes)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Guard.CheckURL(base); err != nil {
+		return nil, err
+	}
+	client := &davClient{client: p.Client, source: source, maxSize: p.MaxFeedSize, userAgent: p.UserAgent}
+	calendars, err := client.discover(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+
+	var stored []models.CalendarCollection
+	if err := p.DB.WithContext(ctx).Where("agenda_source_id = ?", source.ID).Find(&stored).Error; err != nil {
+		return nil, err
+	}
+	collections := map[string]models.CalendarCollection{}
+	for _, collection := range stored {
+		collections[collection.Href] = collection
+	}
+
+	hrefs := make([]string, len(calendars))
+	for i, calendar := range calendars {
+		hrefs[i] = calendar.href
+		collection, ok := collections[calendar.href]
+		if !ok {
+			collection = models.CalendarCollection{AgendaSourceID: source.ID, Href: calendar.href}
+		}
+		if err := p.syncCalendar(ctx, client, &collection, calendar, from, to); err != nil {
+			return nil, fmt.Errorf("calendar %s: %w", calendar.href, err)
+		}
+	}
+
+	// Calendars that are gone take their objects with them
+	gone := p.DB.WithContext(ctx).Unscoped().Where("agenda_source_id = ? AND href NOT IN ?", source.ID, hrefs)
+	if err := gone.Delete(&models.CalendarCollection{}).Error; err != nil {
+		return nil, err
+	}
+
+	var objects []models.CalendarObject
+	err = p.DB.WithContext(ctx).
+		Where("calendar_collection_id IN (?)", p.DB.Model(&models.CalendarCollection{}).Select("id").Where("agenda_source_id = ?", source.ID)).
+		Order("id").Find(&objects).Error
+	if err != nil {
+		return nil, err
+	}
+	var data bytes.Buffer
+	for _, object := range objects {
+		data.WriteString(strings.TrimSpace(object.Data))
+		data.WriteString("\r\n")
+	}
+	return data.Bytes(), nil
+}
+
+// Parse reads the calendar objects Fetch returned. Each object has its own
+// timezone definitions, so they are parsed one by one; objects that can't be
+// read are left out.
+func (p *CalDAVProvider) Parse(data []byte) (*ics.Calendar, error) {
+	calendar := &ics.Calendar{}
+	for _, object := range splitCalendars(data) {
+		parsed, err := ics.Parse(bytes.NewReader(object))
+		if err != nil {
+			continue
+		}
+		calendar.Events = append(calendar.Events, parsed.Events...)
+	}
+	return calendar, nil
+}
+
+// splitCalendars splits a stream of iCalendar objects at their BEGIN:VCALENDAR
+// lines
+func splitCalendars(data []byte) [][]byte {
+	var objects [][]byte
+	var current bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if strings.EqualFold(strings.TrimSpace(string(line)), "BEGIN:VCALENDAR") && current.Len() > 0 {
+			objects = append(objects, bytes.Clone(current.Bytes()))
+			current.Reset()
+		}
+		current.Write(line)
+		current.WriteString("\r\n")
+	}
+	if current.Len() > 0 {
+		objects = append(objects, current.Bytes())
+	}
+	return objects
+}
+
+// syncCalendar brings the stored objects of a calendar up to date
+func (p *CalDAVProvider) syncCalendar(ctx context.Context, client *davClient, collection *models.CalendarCollection, calendar davCalendar, from, to time.Time) error {
+	if !calendar.syncable {
+		objects, err := client.query(ctx, calendar.url, from, to)
+		if err != nil {
+			return err
+		}
+		collection.SyncToken = ""
+		return p.store(ctx, collection, &davChanges{objects: objects, full: true})
+	}
+
+	changes, err := client.syncCollection(ctx, calendar.url, collection.SyncToken)
+	var status *davStatusError
+	if errors.As(err, &status) && collection.SyncToken != "" && status.invalidToken() {
+		// The server forgot the token, start over
+		changes, err = client.syncCollection(ctx, calendar.url, "")
+	}
+	if err != nil {
+		return err
+	}
+
+	// Only download the objects whose ETag changed
+	var known []models.CalendarObject
+	if collection.ID != 0 {
+		if err := p.DB.WithContext(ctx).Select("href", "e_tag").Where("calendar_collection_id = ?", collection.ID).Find(&known).Error; err != nil {
+			return err
+		}
+	}
+	etags := map[string]string{}
+	for _, object := range known {
+		etags[object.Href] = object.ETag
+	}
+	var changed []string
+	for _, object := range changes.objects {
+		if etag, ok := etags[object.href]; !ok || etag != object.etag || object.etag == "" {
+			changed = append(changed, object.href)
+		}
+	}
+	listed := changes.objects
+	changes.objects, err = client.multiget(ctx, calendar.url, changed)
+	if err != nil {
+		return err
+	}
+	if changes.full {
+		// Objects that weren't listed are gone, but the unchanged ones are kept
+		changes.keep = make([]string, len(listed))
+		for i, object := range listed {
+			changes.keep[i] = object.href
+		}
+	}
+	collection.SyncToken = changes.token
+	return p.store(ctx, collection, changes)
+}
+
+// store saves the changes to the objects of a calendar
+func (p *CalDAVProvider) store(ctx context.Context, collection *models.CalendarCollection, changes *davChanges) error {
+	return p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(collection).Error; err != nil {
+			return err
+		}
+		objects := make([]models.CalendarObject, len(changes.objects))
+		for i, object := range changes.objects {
+			objects[i] = models.CalendarObject{CalendarCollectionID: collection.ID, Href: object.href, ETag: object.etag, Data: object.data}
+		}
+		if len(objects) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "calendar_collection_id"}, {Name: "href"}},
+				DoUpdates: clause.AssignmentColumns([]string{"e_tag", "data", "updated_at"}),
+			}).CreateInBatches(objects, 100).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		objectsOf := tx.Unscoped().Where("calendar_collection_id = ?", collection.ID)
+		if len(changes.removed) > 0 {
+			if err := objectsOf.Where("href IN ?", changes.removed).Delete(&models.CalendarObject{}).Error; err != nil {
+				return err
+			}
+		}
+		if changes.full {
+			keep := changes.keep
+			if keep == nil {
+				for _, object := range changes.objects {
+					keep = append(keep, object.href)
+				}
+			}
+			stale := tx.Unscoped().Where("calendar_collection_id = ?", collection.ID)
+			if len(keep) > 0 {
+				stale = stale.Where("href NOT IN ?", keep)
+			}
+			if err := stale.Delete(&models.CalendarObject{}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// davCalendar is a calendar collection found on a server
+type davCalendar struct {
+	url  *url.URL
+	href string
+	// syncable is set when the server supports sync-collection reports on it
+	syncable bool
+}
+
+// davObject is a calendar object resource
+type davObject struct {
+	href string
+	etag string
+	data string
+}
+
+// davChanges are the changes to the objects of a calendar since the last sync
+type davChanges struct {
+	// objects are the new and changed objects
+	objects []davObject
+	// removed are the hrefs of deleted objects
+	removed []string
+	// full is set when the objects are all there are, any others are deleted
+	full bool
+	// keep are the hrefs of all objects of a full sync, when they aren't
+	// all in objects
+	keep []string
+	// token is the sync token to continue from next time
+	token string
+}
+
+// davStatusError is returned when a server answers with an unexpected status
+type davStatusError struct {
+	status int
+	body   string
+}
+
+func (e *davStatusError) Error() string {
+	return fmt.Sprintf("CalDAV server returned %d %s", e.status, http.StatusText(e.status))
+}
+
+// invalidToken reports whether a sync-collection report failed because the
+// server doesn't know the sync token anymore
+func (e *davStatusError) invalidToken() bool {
+	return strings.Contains(e.body, "valid-sync-token") || e.status == http.StatusForbidden || e.status == http.StatusConflict
+}
+
+// davClient sends WebDAV requests with the credentials of a source
+type davClient struct {
+	client    *http.Client
+	source    *models.AgendaSource
+	maxSize   int64
+	userAgent string
+}
+
+// Request bodies. The calendar-query and sync-collection ones are completed
+// with fmt.Sprintf.
+const (
+	propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:resourcetype/>
+    <d:current-user-principal/>
+    <c:calendar-home-set/>
+    <c:supported-calendar-component-set/>
+    <d:supported-report-set/>
+  </d:prop>
+</d:propfind>`
+	calendarQueryBody = `<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:getetag/>
+    <c:calendar-data/>
+  </d:prop>
+  <c:filter>
+    <c:comp-filter name="VCALENDAR">
+      <c:comp-filter name="VEVENT">
+        <c:time-range start="%s" end="%s"/>
+      </c:comp-filter>
+    </c:comp-filter>
+  </c:filter>
+</c:calendar-query>`
+	syncCollectionBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:">
+  <d:sync-token>%s</d:sync-token>
+  <d:sync-level>1</d:sync-level>
+  <d:prop>
+    <d:getetag/>
+  </d:prop>
+</d:sync-collection>`
+)
+
+// multistatus is the body of a 207 Multi-Status response
+type multistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+	SyncToken string        `xml:"DAV: sync-token"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Status    string        `xml:"DAV: status"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Prop   davProp `xml:"DAV: prop"`
+	Status string  `xml:"DAV: status"`
+}
+
+type davProp struct {
+	ResourceType *struct {
+		Calendar *struct{} `xml:"urn:ietf:params:xml:ns:caldav calendar"`
+	} `xml:"DAV: resourcetype"`
+	CurrentUserPrincipal *davHref `xml:"DAV: current-user-principal"`
+	CalendarHomeSet      *davHref `xml:"urn:ietf:params:xml:ns:caldav calendar-home-set"`
+	ComponentSet         *struct {
+		Comps []struct {
+			Name string `xml:"name,attr"`
+		} `xml:"urn:ietf:params:xml:ns:caldav comp"`
+	} `xml:"urn:ietf:params:xml:ns:caldav supported-calendar-component-set"`
+	ReportSet *struct {
+		Reports []struct {
+			Report struct {
+				Names []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: report"`
+		} `xml:"DAV: supported-report"`
+	} `xml:"DAV: supported-report-set"`
+	ETag         string `xml:"DAV: getetag"`
+	CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+}
+
+type davHref struct {
+	Href string `xml:"DAV: href"`
+}
+
+// succeeded reports whether a DAV:status line is a success
+func succeeded(status string) bool {
+	fields := strings.Fields(status)
+	return len(fields) < 2 || strings.HasPrefix(fields[1], "2")
+}
+
+// prop merges the properties of the successful propstats of a response
+func (r *davResponse) prop() davProp {
+	var merged davProp
+	for _, propstat := range r.Propstats {
+		if !succeeded(propstat.Status) {
+			continue
+		}
+		prop := propstat.Prop
+		if prop.ResourceType != nil {
+			merged.ResourceType = prop.ResourceType
+		}
+		if prop.CurrentUserPrincipal != nil {
+			merged.CurrentUserPrincipal = prop.CurrentUserPrincipal
+		}
+		if prop.CalendarHomeSet != nil {
+			merged.CalendarHomeSet = prop.CalendarHomeSet
+		}
+		if prop.ComponentSet != nil {
+			merged.ComponentSet = prop.ComponentSet
+		}
+		if prop.ReportSet != nil {
+			merged.ReportSet = prop.ReportSet
+		}
+		if prop.ETag != "" {
+			merged.ETag = prop.ETag
+		}
+		if prop.CalendarData != "" {
+			merged.CalendarData = prop.CalendarData
+		}
+	}
+	return merged
+}
+
+// calendar reports whether the resource is a calendar with events
+func (p *davProp) calendar() bool {
+	if p.ResourceType == nil || p.ResourceType.Calendar == nil {
+		return false
+	}
+	// Calendars that don't tell can store any component
+	if p.ComponentSet == nil || len(p.ComponentSet.Comps) == 0 {
+		return true
+	}
+	for _, comp := range p.ComponentSet.Comps {
+		if strings.EqualFold(comp.Name, "VEVENT") {
+			return true
+		}
+	}
+	return false
+}
+
+// syncable reports whether the resource supports sync-collection reports
+func (p *davProp) syncable() bool {
+	if p.ReportSet == nil {
+		return false
+	}
+	for _, report := range p.ReportSet.Reports {
+		for _, name := range report.Report.Names {
+			if name.XMLName.Space == nsDAV && name.XMLName.Local == "sync-collection" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// do sends a request and decodes its multistatus response
+func (c *davClient) do(ctx context.Context, method string, target *url.URL, depth, body string) (*multistatus, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target.String(), strings.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	if depth != "" {
+		req.Header.Set("Depth", depth)
+	}
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+	if c.source.Username != "" || c.source.Password != "" {
+		req.SetBasicAuth(c.source.Username, c.source.Password)
+	}
+
+	client := c.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := readLimited(resp.Body, c.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusMultiStatus {
+		return nil, &davStatusError{status: resp.StatusCode, body: string(data)}
+	}
+	var ms multistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("invalid CalDAV response: %w", err)
+	}
+	return &ms, nil
+}
+
+// resolve returns the URL of an href relative to the URL it was received from
+func resolve(base *url.URL, href string) (*url.URL, error) {
+	ref, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return nil, fmt.Errorf("invalid href %q", href)
+	}
+	resolved := base.ResolveReference(ref)
+	// Credentials and redirects stay with the server the source points to
+	if resolved.Host != base.Host || resolved.Scheme != base.Scheme {
+		return nil, fmt.Errorf("href %q is on another server", href)
+	}
+	return resolved, nil
+}
+
+// find returns the response about the resource at target
+func find(ms *multistatus, target *url.URL) *davResponse {
+	for i := range ms.Responses {
+		if resolved, err := resolve(target, ms.Responses[i].Href); err == nil && samePath(resolved.Path, target.Path) {
+			return &ms.Responses[i]
+		}
+	}
+	// Some servers answer about the resource under another name
+	if len(ms.Responses) == 1 {
+		return &ms.Responses[0]
+	}
+	return nil
+}
+
+// samePath compares paths ignoring a trailing slash
+func samePath(a, b string) bool {
+	return strings.TrimSuffix(a, "/") == strings.TrimSuffix(b, "/")
+}
+
+// discover finds the event calendars at a URL: the calendar itself, or the
+// calendars in the calendar home of the principal it belongs to
+func (c *davClient) discover(ctx context.Context, start *url.URL) ([]davCalendar, error) {
+	ms, err := c.do(ctx, "PROPFIND", start, "0", propfindBody)
+	if err != nil {
+		return nil, err
+	}
+	resp := find(ms, start)
+	if resp == nil {
+		return nil, errors.New("the URL isn't a CalDAV resource")
+	}
+	prop := resp.prop()
+	if prop.calendar() {
+		return []davCalendar{{url: start, href: start.Path, syncable: prop.syncable()}}, nil
+	}
+
+	home := start
+	switch {
+	case prop.CalendarHomeSet != nil:
+		if home, err = resolve(start, prop.CalendarHomeSet.Href); err != nil {
+			return nil, err
+		}
+	case prop.CurrentUserPrincipal != nil:
+		principal, err := resolve(start, prop.CurrentUserPrincipal.Href)
+		if err != nil {
+			return nil, err
+		}
+		if ms, err = c.do(ctx, "PROPFIND", principal, "0", propfindBody); err != nil {
+			return nil, err
+		}
+		if resp := find(ms, principal); resp != nil {
+			if prop := resp.prop(); prop.CalendarHomeSet != nil {
+				if home, err = resolve(principal, prop.CalendarHomeSet.Href); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if ms, err = c.do(ctx, "PROPFIND", home, "1", propfindBody); err != nil {
+		return nil, err
+	}
+	var calendars []davCalendar
+	for _, resp := range ms.Responses {
+		prop := resp.prop()
+		if !succeeded(resp.Status) || !prop.calendar() {
+			continue
+		}
+		calendar, err := resolve(home, resp.Href)
+		if err != nil {
+			return nil, err
+		}
+		calendars = append(calendars, davCalendar{url: calendar, href: calendar.Path, syncable: prop.syncable()})
+	}
+	if len(calendars) == 0 {
+		return nil, errors.New("no calendars with events found at the URL")
+	}
+	return calendars, nil
+}
+
+// objects reads the calendar objects of a multistatus response
+func (c *davClient) objects(calendar *url.URL, ms *multistatus) ([]davObject, error) {
+	var objects []davObject
+	for _, resp := range ms.Responses {
+		prop := resp.prop()
+		if !succeeded(resp.Status) || prop.CalendarData == "" {
+			continue
+		}
+		object, err := resolve(calendar, resp.Href)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, davObject{href: object.Path, etag: prop.ETag, data: prop.CalendarData})
+	}
+	return objects, nil
+}
+
+// query asks for the objects of a calendar with events in from..to
+func (c *davClient) query(ctx context.Context, calendar *url.URL, from, to time.Time) ([]davObject, error) {
+	body := fmt.Sprintf(calendarQueryBody, from.UTC().Format("20060102T150405Z"), to.UTC().Format("20060102T150405Z"))
+	ms, err := c.do(ctx, "REPORT", calendar, "1", body)
+	if err != nil {
+		return nil, err
+	}
+	return c.objects(calendar, ms)
+}
+
+// syncCollection asks what changed in a calendar since the sync token; with
+// an empty token all objects are listed. The objects only have their href and
+// ETag.
+func (c *davClient) syncCollection(ctx context.Context, calendar *url.URL, token string) (*davChanges, error) {
+	changes := &davChanges{full: token == ""}
+	for page := 0; page < maxSyncPages; page++ {
+		var escaped bytes.Buffer
+		xml.EscapeText(&escaped, []byte(token))
+		ms, err := c.do(ctx, "REPORT", calendar, "", fmt.Sprintf(syncCollectionBody, escaped.String()))
+		if err != nil {
+			return nil, err
+		}
+
+		truncated := false
+		for _, resp := range ms.Responses {
+			object, err := resolve(calendar, resp.Href)
+			if err != nil {
+				return nil, err
+			}
+			if samePath(object.Path, calendar.Path) {
+				// The server lists the rest of the changes with the next token
+				truncated = strings.Contains(resp.Status, " 507")
+				continue
+			}
+			if strings.Contains(resp.Status, " 404") {
+				changes.removed = append(changes.removed, object.Path)
+				continue
+			}
+			changes.objects = append(changes.objects, davObject{href: object.Path, etag: resp.prop().ETag})
+		}
+		changes.token = ms.SyncToken
+		if !truncated || ms.SyncToken == "" || ms.SyncToken == token {
+			return changes, nil
+		}
+		token = ms.SyncToken
+	}
+	return nil, errors.New("too many changes to sync")
+}
+
+// multiget downloads the calendar objects at hrefs
+func (c *davClient) multiget(ctx context.Context, calendar *url.URL, hrefs []string) ([]davObject, error) {
+	var objects []davObject
+	for start := 0; start < len(hrefs); start += multigetBatch {
+		var body strings.Builder
+		body.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<c:calendar-multiget xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">
+  <d:prop>
+    <d:getetag/>
+    <c:calendar-data/>
+  </d:prop>`)
+		for _, href := range hrefs[start:min(start+multigetBatch, len(hrefs))] {
+			body.WriteString("\n  <d:href>")
+			xml.EscapeText(&body, []byte((&url.URL{Path: href}).EscapedPath()))
+			body.WriteString("</d:href>")
+		}
+		body.WriteString("\n</c:calendar-multiget>")
+
+		ms, err := c.do(ctx, "REPORT", calendar, "1", body.String())
+		if err != nil {
+			return nil, err
+		}
+		batch, err := c.objects(calendar, ms)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, batch...)
+	}
+	return objects, nil
+}
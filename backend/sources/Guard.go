@@ -0,0 +1,195 @@
+package sources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrBlockedAddress is returned for URLs and connections to addresses that
+// agenda sources may not reach, like those of the server's own network
+var ErrBlockedAddress = errors.New("the address is private or reserved")
+
+// maxRedirects bounds how many redirects a download follows
+const maxRedirects = 5
+
+// blockedPrefixes are the reserved ranges that aren't covered by the netip
+// predicates Guard checks
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"),   // carrier-grade NAT, also used by cloud metadata services
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, including broadcast
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local-use NAT64
+	netip.MustParsePrefix("100::/64"),        // discard
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, including Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+}
+
+// Ranges of IPv6 addresses that embed an IPv4 address, which is checked too
+var (
+	nat64Prefix     = netip.MustParsePrefix("64:ff9b::/96")
+	sixToFourPrefix = netip.MustParsePrefix("2002::/16")
+)
+
+// blockedHosts are names of the server itself and of cloud metadata services
+var blockedHosts = []string{"localhost", "metadata", "metadata.google.internal"}
+
+// Guard keeps agenda sources from reaching the server's own network: it
+// rejects URLs of loopback, private, link-local, metadata and other reserved
+// addresses, and checks the address of every connection once the host name
+// is resolved, which covers redirects and DNS records that change. Hosts and
+// ranges on its allow-list, like those of self-hosted calendar servers, are
+// reachable anyway.
+type Guard struct {
+	hosts    map[string]bool
+	prefixes []netip.Prefix
+}
+
+// NewGuard creates a guard that allows the private hosts, IP addresses and
+// CIDR ranges of the allow-list
+func NewGuard(allow []string) (*Guard, error) {
+	g := &Guard{hosts: map[string]bool{}}
+	for _, entry := range allow {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			g.prefixes = append(g.prefixes, prefix.Masked())
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			g.prefixes = append(g.prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		if strings.ContainsAny(entry, "/:") {
+			return nil, fmt.Errorf("invalid allowed host %q", entry)
+		}
+		g.hosts[normalizeHost(entry)] = true
+	}
+	return g, nil
+}
+
+// CheckURL rejects URLs whose host is a blocked name or address. Names are
+// checked again when they are resolved, see DialContext. A nil guard allows
+// any URL.
+func (g *Guard) CheckURL(target *url.URL) error {
+	if g == nil {
+		return nil
+	}
+	host := normalizeHost(target.Hostname())
+	if g.hosts[host] {
+		return nil
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return g.checkAddr(addr)
+	}
+	for _, blocked := range blockedHosts {
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+		}
+	}
+	return nil
+}
+
+// checkAddr rejects blocked addresses that aren't on the allow-list
+func (g *Guard) checkAddr(addr netip.Addr) error {
+	addr = addr.Unmap().WithZone("")
+	for _, prefix := range g.prefixes {
+		if prefix.Contains(addr) {
+			return nil
+		}
+	}
+	if blockedAddr(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, addr)
+	}
+	bytes := addr.As16()
+	switch {
+	case nat64Prefix.Contains(addr):
+		return g.checkAddr(netip.AddrFrom4([4]byte(bytes[12:16])))
+	case sixToFourPrefix.Contains(addr):
+		return g.checkAddr(netip.AddrFrom4([4]byte(bytes[2:6])))
+	}
+	return nil
+}
+
+// blockedAddr reports whether an address is of a range sources may not reach
+func blockedAddr(addr netip.Addr) bool {
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// DialContext connects like net.Dialer, but refuses blocked addresses. The
+// address is checked after the host name is resolved, right before each
+// connection attempt.
+func (g *Guard) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if !g.hosts[normalizeHost(host)] {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			addr, err := netip.ParseAddr(host)
+			if err != nil {
+				return err
+			}
+			return g.checkAddr(addr)
+		}
+	}
+	return dialer.DialContext(ctx, network, address)
+}
+
+// Client returns an HTTP client that connects through the guard and gives up
+// on requests that take longer than timeout, including reading the response.
+// Proxies are not used, as they would connect on the guard's behalf.
+func (g *Guard) Client(timeout time.Duration) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = g.DialContext
+	transport.ResponseHeaderTimeout = timeout
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+				return fmt.Errorf("redirect to unsupported scheme %q", req.URL.Scheme)
+			}
+			return g.CheckURL(req.URL)
+		},
+	}
+}
+
+// normalizeHost lowercases a host name and drops the dot of a fully
+// qualified one
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,246 @@
+package sources
+
+import (
+	"awesomeProject/ics"
+	"awesomeProject/models"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Types of the agenda sources that are iCalendar feeds
+const (
+	TypeICS    = "ics"
+	TypeProton = "proton"
+	TypeFile   = "file"
+)
+
+// ICSProvider downloads iCalendar feeds over HTTP. webcal:// URLs are fetched
+// over HTTPS.
+type ICSProvider struct {
+	// Client downloads the calendars, http.DefaultClient when nil
+	Client *http.Client
+	// MaxFeedSize bounds the size of a calendar, DefaultMaxFeedSize when zero
+	MaxFeedSize int64
+	// UserAgent is sent with the download requests
+	UserAgent string
+	// Guard rejects URLs of the server's own network, any URL is allowed when
+	// nil. Client should connect through it, see Guard.Client.
+	Guard *Guard
+}
+
+// Type returns TypeICS
+func (p *ICSProvider) Type() string {
+	return TypeICS
+}
+
+// Capabilities of iCalendar feeds
+func (p *ICSProvider) Capabilities() Capabilities {
+	return Capabilities{Remote: true, Schemes: []string{"https", "http", "webcal"}}
+}
+
+// ValidateURL checks that a URL is an absolute HTTP(S) or webcal URL the
+// guard allows
+func (p *ICSProvider) ValidateURL(rawURL string) error {
+	parsed, err := feedURL(rawURL, p.Capabilities().Schemes)
+	if err != nil {
+		return err
+	}
+	return p.Guard.CheckURL(parsed)
+}
+
+// Fetch downloads the feed of a source, unless it didn't change since the
+// source's ETag or LastModified
+func (p *ICSProvider) Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) ([]byte, error) {
+	parsed, err := feedURL(source.Url, p.Capabilities().Schemes)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Guard.CheckURL(parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Scheme == "webcal" {
+		parsed.Scheme = "https"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, parsed.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/calendar")
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+	if source.ETag != "" {
+		req.Header.Set("If-None-Match", source.ETag)
+	}
+	if source.LastModified != "" {
+		req.Header.Set("If-Modified-Since", source.LastModified)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar feed returned %s", resp.Status)
+	}
+	data, err := readLimited(resp.Body, p.MaxFeedSize)
+	if err != nil {
+		return nil, err
+	}
+	source.ETag = resp.Header.Get("ETag")
+	source.LastModified = resp.Header.Get("Last-Modified")
+	return data, nil
+}
+
+// Parse reads an iCalendar feed
+func (p *ICSProvider) Parse(data []byte) (*ics.Calendar, error) {
+	return ics.Parse(bytes.NewReader(data))
+}
+
+// feedURL parses an absolute URL with one of the schemes, which is lowercased
+func feedURL(rawURL string, schemes []string) (*url.URL, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.New("invalid URL")
+	}
+	parsed.Scheme = strings.ToLower(parsed.Scheme)
+	for _, scheme := range schemes {
+		if parsed.Scheme == scheme {
+			if parsed.Host == "" {
+				return nil, errors.New("the URL has no host")
+			}
+			return parsed, nil
+		}
+	}
+	return nil, fmt.Errorf("the URL must start with %s://", strings.Join(schemes, ":// or "))
+}
+
+// ProtonProvider downloads the links Proton Calendar shares calendars with,
+// which are iCalendar feeds over HTTPS
+type ProtonProvider struct {
+	ICSProvider
+}
+
+// Type returns TypeProton
+func (p *ProtonProvider) Type() string {
+	return TypeProton
+}
+
+// Capabilities of Proton calendar links
+func (p *ProtonProvider) Capabilities() Capabilities {
+	return Capabilities{Remote: true, Schemes: []string{"https", "webcal"}}
+}
+
+// ValidateURL checks that a URL is an HTTPS or webcal URL the guard allows
+func (p *ProtonProvider) ValidateURL(rawURL string) error {
+	parsed, err := feedURL(rawURL, p.Capabilities().Schemes)
+	if err != nil {
+		return err
+	}
+	return p.Guard.CheckURL(parsed)
+}
+
+// Fetch downloads the calendar a link shares
+func (p *ProtonProvider) Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) ([]byte, error) {
+	if err := p.ValidateURL(source.Url); err != nil {
+		return nil, err
+	}
+	return p.ICSProvider.Fetch(ctx, source, from, to)
+}
+
+// FileProvider reads iCalendar files from a directory on the server. Sources
+// refer to them with file: URLs relative to the directory, like file:team.ics
+// or file:///team/holidays.ics.
+type FileProvider struct {
+	Dir string
+	// MaxFeedSize bounds the size of a calendar, DefaultMaxFeedSize when zero
+	MaxFeedSize int64
+}
+
+// Type returns TypeFile
+func (p *FileProvider) Type() string {
+	return TypeFile
+}
+
+// Capabilities of local files
+func (p *FileProvider) Capabilities() Capabilities {
+	return Capabilities{Schemes: []string{"file"}}
+}
+
+// ValidateURL checks that a URL is a file: URL
+func (p *FileProvider) ValidateURL(rawURL string) error {
+	_, err := p.path(rawURL)
+	return err
+}
+
+// Fetch reads the file of a source, unless it wasn't modified since the
+// source's LastModified
+func (p *FileProvider) Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) ([]byte, error) {
+	name, err := p.path(source.Url)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	modified := info.ModTime().UTC().Format(time.RFC3339Nano)
+	if source.LastModified == modified {
+		return nil, ErrNotModified
+	}
+	data, err := readLimited(file, p.MaxFeedSize)
+	if err != nil {
+		return nil, err
+	}
+	source.LastModified = modified
+	return data, nil
+}
+
+// Parse reads an iCalendar file
+func (p *FileProvider) Parse(data []byte) (*ics.Calendar, error) {
+	return ics.Parse(bytes.NewReader(data))
+}
+
+// path returns the file a file: URL refers to. Cleaning the path as if it
+// were absolute keeps it within the directory.
+func (p *FileProvider) path(rawURL string) (string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || !strings.EqualFold(parsed.Scheme, "file") {
+		return "", errors.New("the URL must start with file:")
+	}
+	if parsed.Host != "" && parsed.Host != "localhost" {
+		return "", errors.New("the file must be on this server")
+	}
+	name := parsed.Opaque
+	if name == "" {
+		name = parsed.Path
+	}
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", errors.New("the URL has no file name")
+	}
+	return filepath.Join(p.Dir, filepath.FromSlash(name)), nil
+}
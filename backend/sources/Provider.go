@@ -0,0 +1,96 @@
+package sources
+
+import (
+	"awesomeProject/ics"
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"time"
+)
+
+// ErrUnknownType is returned for agenda sources of a type without a provider
+var ErrUnknownType = errors.New("unknown agenda source type")
+
+// ErrNotModified is returned by Fetch when a calendar hasn't changed since the
+// ETag or Last-Modified of its source
+var ErrNotModified = errors.New("calendar not modified")
+
+// Capabilities describe what a type of agenda source supports
+type Capabilities struct {
+	// Remote is set when calendars are downloaded over the network
+	Remote bool
+	// Schemes are the URL schemes sources of the type accept
+	Schemes []string
+	// Credentials is set when sources can log in with a username and password
+	Credentials bool
+}
+
+// Provider reads the calendars of one type of agenda source
+type Provider interface {
+	// Type is the AgendaSource.Type the provider handles
+	Type() string
+	// Capabilities describe what the provider supports
+	Capabilities() Capabilities
+	// ValidateURL checks that a source of this type can use a URL
+	ValidateURL(rawURL string) error
+	// Fetch reads the calendar data of a source. Providers that can filter
+	// events on the server only need to return those overlapping from..to.
+	// Providers that support conditional requests return ErrNotModified when
+	// the calendar matches the source's ETag or LastModified, and update those
+	// otherwise.
+	Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) ([]byte, error)
+	// Parse reads the events from calendar data the provider fetched
+	Parse(data []byte) (*ics.Calendar, error)
+}
+
+// Registry holds the providers of the supported agenda source types
+type Registry struct {
+	providers map[string]Provider
+}
+
+// NewRegistry creates a registry with providers
+func NewRegistry(providers ...Provider) *Registry {
+	r := &Registry{providers: map[string]Provider{}}
+	for _, provider := range providers {
+		r.Register(provider)
+	}
+	return r
+}
+
+// Register adds a provider, replacing the one of the same type
+func (r *Registry) Register(provider Provider) {
+	r.providers[provider.Type()] = provider
+}
+
+// Get returns the provider of a type
+func (r *Registry) Get(sourceType string) (Provider, bool) {
+	provider, ok := r.providers[sourceType]
+	return provider, ok
+}
+
+// Types returns the supported types, sorted
+func (r *Registry) Types() []string {
+	types := make([]string, 0, len(r.providers))
+	for sourceType := range r.providers {
+		types = append(types, sourceType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// readLimited reads at most maxSize bytes, DefaultMaxFeedSize when zero
+func readLimited(r io.Reader, maxSize int64) ([]byte, error) {
+	if maxSize == 0 {
+		maxSize = DefaultMaxFeedSize
+	}
+	data, err := io.ReadAll(io.LimitReader(r, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrFeedTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,274 @@
+package sources
+
+import (
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Default schedule of the syncs
+const (
+	// DefaultInterval is the time between syncs of sources without their own
+	DefaultInterval = 15 * time.Minute
+	// DefaultRetry is the delay after the first failed sync of a source, which
+	// doubles with every further failure
+	DefaultRetry = time.Minute
+	// DefaultMaxBackoff bounds the delay after failed syncs
+	DefaultMaxBackoff = 6 * time.Hour
+	// DefaultPoll is how often the scheduler looks for sources that are due
+	DefaultPoll = 30 * time.Second
+)
+
+// HistorySize is how many of its latest syncs are kept for each source
+const HistorySize = 100
+
+// ErrSyncRunning is returned when a source is synced while it's being synced
+var ErrSyncRunning = errors.New("agenda source is already being synced")
+
+// Scheduler syncs the agenda sources of supported types in the background,
+// each when it is due: at its own interval or the default one, and after
+// failures with a jittered exponential backoff. The schedule is kept in the
+// database, so it survives restarts.
+type Scheduler struct {
+	Syncer *Syncer
+	// Interval is the time between syncs of sources without their own,
+	// DefaultInterval when zero
+	Interval time.Duration
+	// OwnIntervalsOnly leaves the sources without their own interval alone,
+	// for servers that don't sync those
+	OwnIntervalsOnly bool
+	// MaxBackoff bounds the delay after failures, DefaultMaxBackoff when zero
+	MaxBackoff time.Duration
+	// Poll is how often due sources are looked for, DefaultPoll when zero
+	Poll time.Duration
+	// Workers is how many sources are synced at the same time, 1 when zero
+	Workers int
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	done    chan struct{}
+	running map[uint]bool // IDs of the sources being synced
+}
+
+// Start runs the scheduler in the background until Stop is called
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel, s.done = cancel, make(chan struct{})
+	go s.run(ctx, s.done)
+}
+
+// Stop cancels the running syncs and waits until the scheduler has stopped,
+// or ctx is done. Sources whose sync was cancelled stay due.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	cancel, done := s.cancel, s.done
+	s.cancel, s.done = nil, nil
+	s.mu.Unlock()
+	if cancel == nil {
+		return nil
+	}
+
+	cancel()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run syncs the due sources now and then at every poll
+func (s *Scheduler) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	poll := s.Poll
+	if poll == 0 {
+		poll = DefaultPoll
+	}
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		if _, err := s.SyncDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to sync agenda sources: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SyncDue syncs the sources that are due and schedules their next sync. It
+// returns the number of sources it synced, whether or not that worked.
+func (s *Scheduler) SyncDue(ctx context.Context) (int, error) {
+	var due []models.AgendaSource
+	query := s.Syncer.DB.WithContext(ctx).
+		Where("type IN ? AND NOT url_redacted AND (next_sync_at IS NULL OR next_sync_at <= ?)", s.Syncer.Providers.Types(), s.Syncer.now())
+	if s.OwnIntervalsOnly {
+		query = query.Where("sync_interval > 0")
+	}
+	err := query.Order("next_sync_at NULLS FIRST, id").Find(&due).Error
+	if err != nil {
+		return 0, err
+	}
+
+	queue := make(chan *models.AgendaSource)
+	var wg sync.WaitGroup
+	for range max(s.Workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for source := range queue {
+				_, err := s.Sync(ctx, source, models.SyncTriggerScheduled)
+				if err != nil && ctx.Err() == nil && !errors.Is(err, ErrSyncRunning) {
+					log.Print(err)
+				}
+			}
+		}()
+	}
+	synced := 0
+enqueue:
+	for i := range due {
+		select {
+		case queue <- &due[i]:
+			synced++
+		case <-ctx.Done():
+			break enqueue
+		}
+	}
+	close(queue)
+	wg.Wait()
+	return synced, ctx.Err()
+}
+
+// Sync syncs a source now, records the sync in the source's history and
+// status, and schedules the next one. A sync that fails is recorded and
+// returned like one that worked. The error is for syncs that couldn't be
+// recorded or were cancelled, and is ErrSyncRunning when the source is being
+// synced already.
+func (s *Scheduler) Sync(ctx context.Context, source *models.AgendaSource, trigger string) (*models.SyncRun, error) {
+	s.mu.Lock()
+	if s.running[source.ID] {
+		s.mu.Unlock()
+		return nil, ErrSyncRunning
+	}
+	if s.running == nil {
+		s.running = map[uint]bool{}
+	}
+	s.running[source.ID] = true
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.running, source.ID)
+		s.mu.Unlock()
+	}()
+
+	run := &models.SyncRun{
+		ResourceID:     uuid.New(),
+		AgendaSourceID: source.ID,
+		Trigger:        trigger,
+		StartedAt:      s.Syncer.now(),
+	}
+	started := time.Now()
+	result, err := s.Syncer.SyncSource(ctx, source)
+	if ctx.Err() != nil {
+		// Cancelled syncs aren't recorded, the source stays due
+		return nil, ctx.Err()
+	}
+	run.Duration = time.Since(started)
+
+	failures := 0
+	next := s.Syncer.now()
+	switch {
+	case err != nil:
+		run.Status, run.Error = models.SyncStatusFailed, err.Error()
+		failures = source.SyncFailures + 1
+		next = next.Add(s.backoff(failures))
+		log.Printf("Failed to sync agenda source %s (%d in a row): %v", source.ResourceID, failures, err)
+	case result.NotModified:
+		run.Status = models.SyncStatusNotModified
+		next = next.Add(s.interval(source))
+	default:
+		run.Status = models.SyncStatusSuccess
+		run.Added, run.Updated, run.Removed = result.Added, result.Updated, result.Removed
+		next = next.Add(s.interval(source))
+		log.Printf("Synced agenda source %s: %d added, %d updated, %d removed", source.ResourceID, result.Added, result.Updated, result.Removed)
+	}
+
+	finished := s.Syncer.now()
+	itemCount := source.ItemCount
+	status := map[string]any{
+		"next_sync_at":     next,
+		"sync_failures":    failures,
+		"last_synced_at":   finished,
+		"last_sync_status": run.Status,
+		"last_error":       run.Error,
+	}
+	err = s.Syncer.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(run).Error; err != nil {
+			return err
+		}
+		if run.Status != models.SyncStatusFailed {
+			var count int64
+			if err := tx.Model(&models.AgendaItem{}).Where("agenda_source_id = ?", source.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			itemCount = int(count)
+			status["item_count"] = itemCount
+		}
+		if err := tx.Model(source).UpdateColumns(status).Error; err != nil {
+			return err
+		}
+		// Only the latest runs are kept
+		latest := tx.Model(&models.SyncRun{}).Select("id").Where("agenda_source_id = ?", source.ID).Order("id DESC").Limit(HistorySize)
+		return tx.Unscoped().Where("agenda_source_id = ? AND id NOT IN (?)", source.ID, latest).Delete(&models.SyncRun{}).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to record sync of agenda source %s: %w", source.ResourceID, err)
+	}
+	source.NextSyncAt, source.SyncFailures = &next, failures
+	source.LastSyncedAt, source.LastSyncStatus, source.LastError = &finished, run.Status, run.Error
+	source.ItemCount = itemCount
+	return run, nil
+}
+
+// interval returns the time until the next sync of a source that worked,
+// give or take a tenth so that sources added together don't stay together
+func (s *Scheduler) interval(source *models.AgendaSource) time.Duration {
+	interval := source.SyncInterval
+	if interval == 0 {
+		interval = s.Interval
+	}
+	if interval == 0 {
+		interval = DefaultInterval
+	}
+	return interval - interval/10 + rand.N(interval/5+1)
+}
+
+// backoff returns the delay after a number of failed syncs in a row. The
+// retry delay doubles with every failure after the first, up to the maximum,
+// and between half of it and all of it is waited.
+func (s *Scheduler) backoff(failures int) time.Duration {
+	maxBackoff := s.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = DefaultMaxBackoff
+	}
+	delay := maxBackoff
+	if failures < 20 {
+		delay = min(DefaultRetry<<(failures-1), maxBackoff)
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
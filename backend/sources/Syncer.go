@@ -0,0 +1,269 @@
+// Package sources keeps the agenda items of agenda sources in sync with the
+// calendars they point to
+package sources
+
+import (
+	"awesomeProject/ics"
+	"awesomeProject/models"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DefaultMaxFeedSize bounds the size of a downloaded calendar
+const DefaultMaxFeedSize = 10 << 20
+
+// Default window of time around now that recurring events are expanded in
+const (
+	DefaultHorizon = 180 * 24 * time.Hour
+	DefaultHistory = 30 * 24 * time.Hour
+)
+
+// DefaultRefresh is how long an unchanged calendar is trusted before its
+// events are expanded into the moving sync window again
+const DefaultRefresh = 24 * time.Hour
+
+// lookupBatch is how many agenda items are looked up or deleted at once
+const lookupBatch = 1000
+
+// ErrFeedTooLarge is returned when a calendar is larger than the configured maximum
+var ErrFeedTooLarge = errors.New("calendar feed is too large")
+
+// Result summarizes the changes a sync made to a source's agenda items
+type Result struct {
+	Added   int
+	Updated int
+	Removed int
+	// NotModified is set when the calendar didn't change and was left alone
+	NotModified bool
+}
+
+// Syncer downloads the calendars of agenda sources and stores their events
+// as agenda items
+type Syncer struct {
+	DB *gorm.DB
+	// Providers read the calendars of the supported agenda source types
+	Providers *Registry
+	// Horizon is how far ahead events are synced, DefaultHorizon when zero
+	Horizon time.Duration
+	// History is how far back events are synced, DefaultHistory when zero.
+	// Items that ended before then are kept as they are.
+	History time.Duration
+	// Refresh is how long conditional requests are made after the events of a
+	// source were stored, DefaultRefresh when zero
+	Refresh time.Duration
+	// Now returns the current time, time.Now when nil
+	Now func() time.Time
+}
+
+// Fetch reads and parses the calendar of a source with the provider of its
+// type. Events outside of from..to may be left out.
+func (s *Syncer) Fetch(ctx context.Context, source *models.AgendaSource, from, to time.Time) (*ics.Calendar, error) {
+	provider, ok := s.Providers.Get(source.Type)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownType, source.Type)
+	}
+	data, err := provider.Fetch(ctx, source, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return provider.Parse(data)
+}
+
+// now returns the current time
+func (s *Syncer) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+// window returns the period of time events are synced in
+func (s *Syncer) window() (time.Time, time.Time) {
+	now := s.now()
+	horizon, history := s.Horizon, s.History
+	if horizon == 0 {
+		horizon = DefaultHorizon
+	}
+	if history == 0 {
+		history = DefaultHistory
+	}
+	return now.Add(-history), now.Add(horizon)
+}
+
+// SyncSource downloads the calendar of a source and makes its agenda items
+// match the occurrences of its events within the sync window. Items are
+// matched with occurrences by their event's UID and recurrence instance, so
+// they keep their ResourceID: new occurrences are inserted, changed ones are
+// updated and items of occurrences that disappeared from the calendar are
+// soft-deleted, all in one transaction. Calendars that didn't change since the
+// last sync are left alone, until they are due for a refresh.
+func (s *Syncer) SyncSource(ctx context.Context, source *models.AgendaSource) (*Result, error) {
+	from, to := s.window()
+	refresh := s.Refresh
+	if refresh == 0 {
+		refresh = DefaultRefresh
+	}
+	fetched := *source
+	if source.SyncedAt == nil || s.now().Sub(*source.SyncedAt) >= refresh {
+		// Recurring events have to be expanded into the window now and then
+		fetched.ETag, fetched.LastModified = "", ""
+	}
+	calendar, err := s.Fetch(ctx, &fetched, from, to)
+	if errors.Is(err, ErrNotModified) {
+		return &Result{NotModified: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// All-day events and floating times take place in the owner's timezone
+	var owner models.User
+	if err := s.DB.WithContext(ctx).First(&owner, source.UserID).Error; err != nil {
+		return nil, err
+	}
+
+	occurrences := calendar.Occurrences(from, to, owner.Location())
+	syncedAt := s.now()
+	result := &Result{}
+	err = s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// The items of the occurrences, including deleted ones that come back
+		ids := make([]string, len(occurrences))
+		for i, occurrence := range occurrences {
+			ids[i] = occurrence.ID
+		}
+		existing := map[string]*models.AgendaItem{}
+		for start := 0; start < len(ids); start += lookupBatch {
+			var batch []models.AgendaItem
+			err := tx.Unscoped().Where("agenda_source_id = ? AND external_id IN ?", source.ID, ids[start:min(start+lookupBatch, len(ids))]).Find(&batch).Error
+			if err != nil {
+				return err
+			}
+			for i := range batch {
+				existing[batch[i].ExternalID] = &batch[i]
+			}
+		}
+
+		var added []models.AgendaItem
+		for _, occurrence := range occurrences {
+			item, ok := existing[occurrence.ID]
+			if !ok {
+				added = append(added, models.AgendaItem{
+					ResourceID:     uuid.New(),
+					AgendaSourceID: source.ID,
+					ExternalID:     occurrence.ID,
+					ExternalUID:    occurrence.UID,
+					UserID:         source.UserID,
+				})
+				apply(&added[len(added)-1], &occurrence)
+				continue
+			}
+			if !item.DeletedAt.Valid && !changed(item, &occurrence) {
+				continue
+			}
+			apply(item, &occurrence)
+			err := tx.Unscoped().Model(item).Updates(map[string]any{
+				"start_time":    item.StartTime,
+				"end_time":      item.EndTime,
+				"all_day":       item.AllDay,
+				"timezone":      item.Timezone,
+				"description":   item.Description,
+				"location":      item.Location,
+				"notes":         item.Notes,
+				"external_uid":  item.ExternalUID,
+				"sequence":      item.Sequence,
+				"last_modified": item.LastModified,
+				"user_id":       source.UserID,
+				"deleted_at":    nil,
+			}).Error
+			if err != nil {
+				return err
+			}
+			result.Updated++
+		}
+		if len(added) > 0 {
+			if err := tx.CreateInBatches(added, 500).Error; err != nil {
+				return err
+			}
+			result.Added = len(added)
+		}
+
+		// Items within the window whose occurrence is gone. Those that ended
+		// before it are kept as they are.
+		var current []models.AgendaItem
+		err := tx.Select("id", "external_id").Where("agenda_source_id = ? AND external_id <> '' AND end_time > ?", source.ID, from).Find(&current).Error
+		if err != nil {
+			return err
+		}
+		seen := make(map[string]bool, len(ids))
+		for _, id := range ids {
+			seen[id] = true
+		}
+		var removed []uint
+		for _, item := range current {
+			if !seen[item.ExternalID] {
+				removed = append(removed, item.ID)
+			}
+		}
+		for start := 0; start < len(removed); start += lookupBatch {
+			deleted := tx.Delete(&models.AgendaItem{}, removed[start:min(start+lookupBatch, len(removed))])
+			if deleted.Error != nil {
+				return deleted.Error
+			}
+			result.Removed += int(deleted.RowsAffected)
+		}
+
+		// The validators are only kept once the events are stored
+		return tx.Model(source).UpdateColumns(map[string]any{
+			"e_tag":         fetched.ETag,
+			"last_modified": fetched.LastModified,
+			"synced_at":     syncedAt,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	source.ETag, source.LastModified, source.SyncedAt = fetched.ETag, fetched.LastModified, &syncedAt
+	return result, nil
+}
+
+// apply copies an occurrence onto its item
+func apply(item *models.AgendaItem, occurrence *ics.Occurrence) {
+	item.StartTime = occurrence.Start
+	item.EndTime = occurrence.End
+	item.AllDay = occurrence.AllDay
+	item.Timezone = occurrence.Timezone
+	item.Description = occurrence.Summary
+	item.Location = occurrence.Location
+	item.Notes = occurrence.Notes
+	item.ExternalUID = occurrence.UID
+	item.Sequence = occurrence.Sequence
+	item.LastModified = nil
+	if !occurrence.LastModified.IsZero() {
+		lastModified := occurrence.LastModified
+		item.LastModified = &lastModified
+	}
+}
+
+// changed reports whether an occurrence differs from its item. A new revision
+// of the event counts as a change even when the occurrence looks the same.
+func changed(item *models.AgendaItem, occurrence *ics.Occurrence) bool {
+	var lastModified time.Time
+	if item.LastModified != nil {
+		lastModified = *item.LastModified
+	}
+	return item.Sequence != occurrence.Sequence ||
+		!lastModified.Equal(occurrence.LastModified) ||
+		!item.StartTime.Equal(occurrence.Start) ||
+		!item.EndTime.Equal(occurrence.End) ||
+		item.AllDay != occurrence.AllDay ||
+		item.Timezone != occurrence.Timezone ||
+		item.Description != occurrence.Summary ||
+		item.Location != occurrence.Location ||
+		item.Notes != occurrence.Notes ||
+		item.ExternalUID != occurrence.UID
+}
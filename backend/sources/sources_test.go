@@ -0,0 +1,1128 @@
+package sources
+
+import (
+	"awesomeProject/models"
+	"awesomeProject/secrets"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// testKeyring encrypts the secrets of agenda sources in the tests
+var testKeyring, _ = secrets.NewKeyring(secrets.Key{ID: "test", Secret: bytes.Repeat([]byte{7}, secrets.KeySize)})
+
+// Setup PostgreSQL database for tests
+func setupTestDB() (*gorm.DB, error) {
+	// Use environment variables or default values
+	host := os.Getenv("POSTGRES_HOST")
+	if host == "" {
+		host = "localhost"
+	}
+	port := os.Getenv("POSTGRES_PORT")
+	if port == "" {
+		port = "5432"
+	}
+	user := os.Getenv("POSTGRES_USER")
+	if user == "" {
+		user = "postgres"
+	}
+	password := os.Getenv("POSTGRES_PASSWORD")
+	if password == "" {
+		password = "password"
+	}
+	dbname := os.Getenv("POSTGRES_DB")
+	if dbname == "" {
+		dbname = "test_db"
+	}
+
+	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable", host, port, user, password, dbname)
+	secrets.Register(testKeyring)
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.AutoMigrate(&models.User{}, &models.AgendaSource{}, &models.CalendarCollection{}, &models.CalendarObject{}, &models.SyncRun{}, &models.AgendaItem{})
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// march1 is the time the tests sync at, just before the events of the fixtures
+func march1() time.Time {
+	return time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// feedServer serves the fixture file whose name is stored in feed
+func feedServer(t *testing.T, feed *atomic.Value) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, err := os.ReadFile("testdata/" + feed.Load().(string))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// source returns an agenda source of a type with a URL
+func source(sourceType, url string) *models.AgendaSource {
+	return &models.AgendaSource{ResourceID: uuid.New(), Url: url, Type: sourceType}
+}
+
+// Test downloading calendar feeds
+func TestICSProvider(t *testing.T) {
+	var feed atomic.Value
+	feed.Store("proton.ics")
+	server := feedServer(t, &feed)
+	provider := &ICSProvider{}
+
+	t.Run("Valid feed", func(t *testing.T) {
+		data, err := provider.Fetch(context.Background(), source(TypeICS, server.URL+"/calendar.ics"), time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		calendar, err := provider.Parse(data)
+		assert.NoError(t, err)
+		assert.Equal(t, "Work", calendar.Name)
+		assert.Len(t, calendar.Events, 4)
+	})
+
+	t.Run("Missing feed", func(t *testing.T) {
+		feed.Store("missing.ics")
+		defer feed.Store("proton.ics")
+		_, err := provider.Fetch(context.Background(), source(TypeICS, server.URL+"/calendar.ics"), time.Time{}, time.Time{})
+		assert.Error(t, err)
+	})
+
+	t.Run("Feed too large", func(t *testing.T) {
+		small := &ICSProvider{MaxFeedSize: 100}
+		_, err := small.Fetch(context.Background(), source(TypeICS, server.URL+"/calendar.ics"), time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrFeedTooLarge)
+	})
+
+	t.Run("Not a calendar", func(t *testing.T) {
+		html := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("<html>Log in to continue</html>"))
+		}))
+		defer html.Close()
+		data, err := provider.Fetch(context.Background(), source(TypeICS, html.URL), time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		_, err = provider.Parse(data)
+		assert.Error(t, err)
+	})
+
+	t.Run("webcal URL", func(t *testing.T) {
+		tls := httptest.NewTLSServer(server.Config.Handler)
+		defer tls.Close()
+		webcal := &ProtonProvider{ICSProvider{Client: tls.Client()}}
+		data, err := webcal.Fetch(context.Background(), source(TypeProton, strings.Replace(tls.URL, "https://", "webcal://", 1)), time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		calendar, err := webcal.Parse(data)
+		assert.NoError(t, err)
+		assert.Len(t, calendar.Events, 4)
+	})
+
+	t.Run("Conditional requests", func(t *testing.T) {
+		conditional := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", "Fri, 01 Mar 2024 08:00:00 GMT")
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			data, _ := os.ReadFile("testdata/proton.ics")
+			w.Write(data)
+		}))
+		defer conditional.Close()
+
+		feed := source(TypeICS, conditional.URL)
+		_, err := provider.Fetch(context.Background(), feed, time.Time{}, time.Time{})
+		assert.NoError(t, err)
+		assert.Equal(t, `"v1"`, feed.ETag)
+		assert.Equal(t, "Fri, 01 Mar 2024 08:00:00 GMT", feed.LastModified)
+
+		_, err = provider.Fetch(context.Background(), feed, time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrNotModified)
+	})
+
+	t.Run("Validate URL", func(t *testing.T) {
+		assert.NoError(t, provider.ValidateURL("https://example.com/calendar.ics"))
+		assert.NoError(t, provider.ValidateURL("http://example.com/calendar.ics"))
+		assert.NoError(t, provider.ValidateURL("WEBCAL://example.com/calendar.ics"))
+		assert.Error(t, provider.ValidateURL("ftp://example.com/calendar.ics"))
+		assert.Error(t, provider.ValidateURL("https:///calendar.ics"))
+		assert.Error(t, provider.ValidateURL("calendar.ics"))
+
+		// Proton only shares links over HTTPS
+		proton := &ProtonProvider{}
+		assert.NoError(t, proton.ValidateURL("https://calendar.proton.me/api/calendar/v1/url/abc/calendar.ics?CacheKey=x"))
+		assert.Error(t, proton.ValidateURL("http://calendar.proton.me/api/calendar/v1/url/abc/calendar.ics"))
+	})
+}
+
+// Test reading calendar files from a directory
+func TestFileProvider(t *testing.T) {
+	provider := &FileProvider{Dir: "testdata"}
+
+	for _, url := range []string{"file:proton.ics", "file:///proton.ics", "file://localhost/proton.ics", "file:team/../proton.ics"} {
+		data, err := provider.Fetch(context.Background(), source(TypeFile, url), time.Time{}, time.Time{})
+		if assert.NoError(t, err, url) {
+			calendar, err := provider.Parse(data)
+			assert.NoError(t, err)
+			assert.Len(t, calendar.Events, 4)
+		}
+	}
+
+	// Files can't be read from outside of the directory
+	for _, url := range []string{"file:../Syncer.go", "file:///../../go.mod", "file://example.com/proton.ics", "file:", "https://example.com/proton.ics"} {
+		_, err := provider.Fetch(context.Background(), source(TypeFile, url), time.Time{}, time.Time{})
+		assert.Error(t, err, url)
+	}
+	assert.Error(t, provider.ValidateURL("file:///"))
+
+	// Files are only read again once they are modified
+	file := source(TypeFile, "file:proton.ics")
+	_, err := provider.Fetch(context.Background(), file, time.Time{}, time.Time{})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, file.LastModified)
+	_, err = provider.Fetch(context.Background(), file, time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, ErrNotModified)
+
+	small := &FileProvider{Dir: "testdata", MaxFeedSize: 100}
+	_, err = small.Fetch(context.Background(), source(TypeFile, "file:proton.ics"), time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, ErrFeedTooLarge)
+}
+
+// Test looking up providers by type
+func TestRegistry(t *testing.T) {
+	registry := NewRegistry(&ProtonProvider{}, &ICSProvider{}, &FileProvider{Dir: "testdata"})
+	assert.Equal(t, []string{TypeFile, TypeICS, TypeProton}, registry.Types())
+
+	provider, ok := registry.Get(TypeProton)
+	assert.True(t, ok)
+	assert.Equal(t, TypeProton, provider.Type())
+	assert.True(t, provider.Capabilities().Remote)
+	_, ok = registry.Get("exchange")
+	assert.False(t, ok)
+
+	syncer := &Syncer{Providers: registry}
+	_, err := syncer.Fetch(context.Background(), source("exchange", "https://example.com"), time.Time{}, time.Time{})
+	assert.ErrorIs(t, err, ErrUnknownType)
+}
+
+// Test that the guard keeps sources out of private networks
+func TestGuard(t *testing.T) {
+	guard, err := NewGuard([]string{"calendar.internal.example", "10.1.0.0/16", " 192.168.5.5 ", ""})
+	assert.NoError(t, err)
+
+	for rawURL, allowed := range map[string]bool{
+		"https://calendar.proton.me/api/calendar/v1/url/abc": true,
+		"https://93.184.216.34/calendar.ics":                 true,
+		"https://[2606:4700::1111]/calendar.ics":             true,
+		"http://127.0.0.1/calendar.ics":                      false,
+		"http://127.1.2.3:8080/calendar.ics":                 false,
+		"http://[::1]/calendar.ics":                          false,
+		"http://[::ffff:127.0.0.1]/calendar.ics":             false,
+		"http://[::ffff:a9fe:a9fe]/calendar.ics":             false,
+		"http://0.0.0.0/calendar.ics":                        false,
+		"http://10.0.0.1/calendar.ics":                       false,
+		"http://172.16.0.1/calendar.ics":                     false,
+		"http://192.168.1.1/calendar.ics":                    false,
+		"http://169.254.169.254/latest/meta-data/":           false,
+		"http://100.100.100.200/latest/meta-data/":           false,
+		"http://[fe80::1%25eth0]/calendar.ics":               false,
+		"http://[fd00:ec2::254]/latest/meta-data/":           false,
+		"http://[64:ff9b::7f00:1]/calendar.ics":              false,
+		"http://[2002:7f00:1::]/calendar.ics":                false,
+		"http://localhost/calendar.ics":                      false,
+		"http://LocalHost./calendar.ics":                     false,
+		"http://calendar.localhost/calendar.ics":             false,
+		"http://metadata.google.internal/computeMetadata/v1": false,
+		"https://calendar.internal.example/dav/":             true,
+		"https://Calendar.Internal.Example./dav/":            true,
+		"https://10.1.2.3/dav/":                              true,
+		"https://10.2.0.1/dav/":                              false,
+		"https://192.168.5.5/dav/":                           true,
+		"https://192.168.5.6/dav/":                           false,
+	} {
+		parsed, err := url.Parse(rawURL)
+		if !assert.NoError(t, err, rawURL) {
+			continue
+		}
+		err = guard.CheckURL(parsed)
+		if allowed {
+			assert.NoError(t, err, rawURL)
+		} else {
+			assert.ErrorIs(t, err, ErrBlockedAddress, rawURL)
+		}
+	}
+
+	_, err = NewGuard([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	assert.NoError(t, (*Guard)(nil).CheckURL(&url.URL{Scheme: "http", Host: "127.0.0.1"}))
+
+	t.Run("Connections", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("BEGIN:VCALENDAR\r\nEND:VCALENDAR\r\n"))
+		}))
+		defer server.Close()
+		port := server.URL[strings.LastIndex(server.URL, ":")+1:]
+		client := guard.Client(5 * time.Second)
+
+		// Names are checked once they are resolved
+		_, err := client.Get("http://localhost:" + port + "/calendar.ics")
+		assert.ErrorIs(t, err, ErrBlockedAddress)
+		_, err = client.Get(server.URL)
+		assert.ErrorIs(t, err, ErrBlockedAddress)
+
+		allowed, err := NewGuard([]string{"localhost"})
+		assert.NoError(t, err)
+		resp, err := allowed.Client(5 * time.Second).Get("http://localhost:" + port + "/calendar.ics")
+		if assert.NoError(t, err) {
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		}
+	})
+
+	t.Run("Redirects", func(t *testing.T) {
+		target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("secret"))
+		}))
+		defer target.Close()
+		redirects := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/private":
+				http.Redirect(w, r, target.URL, http.StatusFound)
+			case "/file":
+				http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+			default:
+				http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+			}
+		}))
+		defer redirects.Close()
+		port := redirects.URL[strings.LastIndex(redirects.URL, ":")+1:]
+
+		// The redirecting server is allowed by name, the target of its redirects isn't
+		allowed, err := NewGuard([]string{"localhost"})
+		assert.NoError(t, err)
+		client := allowed.Client(5 * time.Second)
+		_, err = client.Get("http://localhost:" + port + "/private")
+		assert.ErrorIs(t, err, ErrBlockedAddress)
+		_, err = client.Get("http://localhost:" + port + "/file")
+		assert.ErrorContains(t, err, "unsupported scheme")
+		_, err = client.Get("http://localhost:" + port + "/loop")
+		assert.ErrorContains(t, err, "too many redirects")
+	})
+
+	t.Run("Providers", func(t *testing.T) {
+		provider := &ICSProvider{Guard: guard}
+		assert.ErrorIs(t, provider.ValidateURL("webcal://127.0.0.1/calendar.ics"), ErrBlockedAddress)
+		assert.NoError(t, provider.ValidateURL("webcal://calendar.example.com/calendar.ics"))
+		_, err := provider.Fetch(context.Background(), source(TypeICS, "http://169.254.169.254/latest/meta-data/"), time.Time{}, time.Time{})
+		assert.ErrorIs(t, err, ErrBlockedAddress)
+		proton := &ProtonProvider{ICSProvider: *provider}
+		assert.ErrorIs(t, proton.ValidateURL("https://[::1]/calendar.ics"), ErrBlockedAddress)
+		caldav := &CalDAVProvider{Guard: guard}
+		assert.ErrorIs(t, caldav.ValidateURL("http://192.168.1.1/dav/"), ErrBlockedAddress)
+	})
+}
+
+// Test that syncing a source upserts its events as agenda items
+func TestSyncSource(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	var feed atomic.Value
+	feed.Store("proton.ics")
+	server := feedServer(t, &feed)
+	syncer := &Syncer{DB: db, Providers: NewRegistry(&ProtonProvider{}), Now: march1}
+
+	user := models.User{ResourceID: uuid.New(), Email: "sync-" + uuid.NewString() + "@example.com", Timezone: "Europe/Zurich"}
+	assert.NoError(t, db.Create(&user).Error)
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/calendar.ics", Type: TypeProton, UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+
+	items := func() map[string]models.AgendaItem {
+		var found []models.AgendaItem
+		assert.NoError(t, db.Where("agenda_source_id = ?", source.ID).Find(&found).Error)
+		byUID := map[string]models.AgendaItem{}
+		for _, item := range found {
+			byUID[item.ExternalID] = item
+		}
+		return byUID
+	}
+
+	result, err := syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Added)
+
+	first := items()
+	assert.Len(t, first, 3)
+	assert.NotContains(t, first, "cancelled-1@proton.me")
+	standup := first["standup-1@proton.me"]
+	assert.Equal(t, "Daily standup", standup.Description)
+	assert.Equal(t, user.ID, standup.UserID)
+	assert.True(t, time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC).Equal(standup.StartTime))
+	assert.Equal(t, "Design review, round 2", first["review-1@proton.me"].Description)
+	assert.Equal(t, "Europe/Zurich", first["review-1@proton.me"].Timezone)
+	assert.Equal(t, "Meeting room 3", first["review-1@proton.me"].Location)
+	assert.Equal(t, "Mockups of the new onboarding", first["review-1@proton.me"].Notes)
+	assert.Equal(t, "UTC", standup.Timezone)
+
+	// All-day events take whole days where the owner is
+	offsite := first["offsite-1@proton.me"]
+	assert.True(t, offsite.AllDay)
+	assert.Empty(t, offsite.Timezone)
+	assert.True(t, time.Date(2024, 3, 6, 23, 0, 0, 0, time.UTC).Equal(offsite.StartTime))
+	assert.True(t, time.Date(2024, 3, 8, 23, 0, 0, 0, time.UTC).Equal(offsite.EndTime))
+
+	// Syncing again changes nothing
+	result, err = syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, *result)
+	again := items()
+	assert.Len(t, again, 3)
+	assert.Equal(t, standup.ResourceID, again["standup-1@proton.me"].ResourceID)
+
+	// Changed events are updated in place, removed ones are deleted
+	feed.Store("proton-updated.ics")
+	result, err = syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Added: 1, Updated: 1, Removed: 2}, *result)
+
+	updated := items()
+	assert.Len(t, updated, 2)
+	assert.Equal(t, standup.ResourceID, updated["standup-1@proton.me"].ResourceID)
+	assert.Equal(t, "Daily standup (moved)", updated["standup-1@proton.me"].Description)
+	assert.Contains(t, updated, "lunch-1@proton.me")
+
+	// A feed that can't be downloaded leaves the items alone
+	feed.Store("missing.ics")
+	_, err = syncer.SyncSource(context.Background(), &source)
+	assert.Error(t, err)
+	assert.Len(t, items(), 2)
+
+	// Deleted items are kept, and come back as they were when their events do
+	feed.Store("proton.ics")
+	result, err = syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{Updated: 3, Removed: 1}, *result)
+	restored := items()
+	assert.Len(t, restored, 3)
+	assert.Equal(t, first["review-1@proton.me"].ResourceID, restored["review-1@proton.me"].ResourceID)
+	var lunch models.AgendaItem
+	assert.NoError(t, db.Unscoped().Where("agenda_source_id = ? AND external_id = ?", source.ID, "lunch-1@proton.me").First(&lunch).Error)
+	assert.True(t, lunch.DeletedAt.Valid)
+}
+
+// Test that syncing a recurring event stores an item per occurrence that
+// keeps its identity across syncs
+func TestSyncRecurring(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	var feed atomic.Value
+	feed.Store("recurring.ics")
+	server := feedServer(t, &feed)
+	syncer := &Syncer{DB: db, Providers: NewRegistry(&ProtonProvider{}), Now: march1, Horizon: 60 * 24 * time.Hour}
+
+	user := models.User{ResourceID: uuid.New(), Email: "sync-" + uuid.NewString() + "@example.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/calendar.ics", Type: TypeProton, UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+
+	items := func() map[string]models.AgendaItem {
+		var found []models.AgendaItem
+		assert.NoError(t, db.Where("agenda_source_id = ?", source.ID).Find(&found).Error)
+		byID := map[string]models.AgendaItem{}
+		for _, item := range found {
+			byID[item.ExternalID] = item
+		}
+		return byID
+	}
+
+	// The second instance is excluded and the third one is moved
+	result, err := syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Added)
+
+	first := items()
+	assert.Len(t, first, 3)
+	assert.Contains(t, first, "one-on-one@proton.me/20240305T100000Z")
+	assert.Equal(t, "one-on-one@proton.me", first["one-on-one@proton.me/20240305T100000Z"].ExternalUID)
+	assert.NotContains(t, first, "one-on-one@proton.me/20240312T100000Z")
+	assert.Contains(t, first, "one-on-one@proton.me/20240326T100000Z")
+	moved := first["one-on-one@proton.me/20240319T100000Z"]
+	assert.Equal(t, "One-on-one (afternoon)", moved.Description)
+	assert.True(t, time.Date(2024, 3, 19, 12, 0, 0, 0, time.UTC).Equal(moved.StartTime))
+
+	// Syncing again changes nothing
+	_, err = syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Len(t, items(), 3)
+
+	// The moved instance is updated in place and the cancelled one is deleted
+	feed.Store("recurring-updated.ics")
+	result, err = syncer.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Removed)
+
+	updated := items()
+	assert.Len(t, updated, 2)
+	assert.NotContains(t, updated, "one-on-one@proton.me/20240326T100000Z")
+	assert.Equal(t, moved.ResourceID, updated["one-on-one@proton.me/20240319T100000Z"].ResourceID)
+	assert.True(t, time.Date(2024, 3, 19, 14, 0, 0, 0, time.UTC).Equal(updated["one-on-one@proton.me/20240319T100000Z"].StartTime))
+
+	// Items that ended before the sync window are kept
+	later := &Syncer{DB: db, Providers: syncer.Providers, Now: func() time.Time { return time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC) }}
+	result, err = later.SyncSource(context.Background(), &source)
+	assert.NoError(t, err)
+	assert.Equal(t, Result{}, *result)
+	assert.Len(t, items(), 2)
+}
+
+// caldavEvent returns a calendar object with a single event
+func caldavEvent(uid, summary string, start time.Time) string {
+	return strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//Test//CalDAV stub//EN",
+		"BEGIN:VEVENT",
+		"UID:" + uid,
+		"DTSTAMP:20240301T080000Z",
+		"DTSTART:" + start.UTC().Format("20060102T150405Z"),
+		"DURATION:PT1H",
+		"SUMMARY:" + summary,
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+}
+
+// caldavChange is a change to an object of the CalDAV stub
+type caldavChange struct {
+	href    string
+	deleted bool
+}
+
+// caldavStub is an in-process CalDAV server of the user alice, with an event
+// calendar and a task list in her calendar home. Its sync tokens count the
+// changes to the objects.
+type caldavStub struct {
+	*httptest.Server
+	// syncable enables sync-collection reports
+	syncable bool
+
+	mu      sync.Mutex
+	objects map[string]string // data by href
+	changes []caldavChange
+	// multigets counts the objects asked for by calendar-multiget reports
+	multigets int
+}
+
+const (
+	caldavPrincipal = "/dav/principals/alice/"
+	caldavHome      = "/dav/calendars/alice/"
+	caldavCalendar  = "/dav/calendars/alice/work/"
+	caldavTasks     = "/dav/calendars/alice/tasks/"
+)
+
+func newCalDAVStub(t *testing.T, syncable bool) *caldavStub {
+	stub := &caldavStub{syncable: syncable, objects: map[string]string{}}
+	stub.Server = httptest.NewServer(http.HandlerFunc(stub.serve))
+	t.Cleanup(stub.Close)
+	return stub
+}
+
+// put adds or changes an object of the event calendar
+func (s *caldavStub) put(name, data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[caldavCalendar+name] = data
+	s.changes = append(s.changes, caldavChange{href: caldavCalendar + name})
+}
+
+// remove deletes an object of the event calendar
+func (s *caldavStub) remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.objects, caldavCalendar+name)
+	s.changes = append(s.changes, caldavChange{href: caldavCalendar + name, deleted: true})
+}
+
+// etag derives the ETag of an object from its data
+func (s *caldavStub) etag(data string) string {
+	return fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(data)))
+}
+
+// response writes a DAV:response with properties
+func response(w *strings.Builder, href string, props ...string) {
+	fmt.Fprintf(w, "<d:response><d:href>%s</d:href><d:propstat><d:prop>%s</d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response>", href, strings.Join(props, ""))
+}
+
+// calendarProps are the properties of a calendar collection
+func (s *caldavStub) calendarProps(components string) []string {
+	props := []string{
+		"<d:resourcetype><d:collection/><c:calendar/></d:resourcetype>",
+		"<c:supported-calendar-component-set><c:comp name=\"" + components + "\"/></c:supported-calendar-component-set>",
+	}
+	if s.syncable {
+		props = append(props, "<d:supported-report-set><d:supported-report><d:report><c:calendar-multiget/></d:report></d:supported-report><d:supported-report><d:report><d:sync-collection/></d:report></d:supported-report></d:supported-report-set>")
+	}
+	return props
+}
+
+func (s *caldavStub) serve(w http.ResponseWriter, r *http.Request) {
+	if username, password, ok := r.BasicAuth(); !ok || username != "alice" || password != "secret" {
+		w.Header().Set("WWW-Authenticate", `Basic realm="CalDAV"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var body strings.Builder
+	body.WriteString(`<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`)
+	switch {
+	case r.Method == "PROPFIND" && r.URL.Path == "/dav/":
+		response(&body, "/dav/", "<d:resourcetype><d:collection/></d:resourcetype>", "<d:current-user-principal><d:href>"+caldavPrincipal+"</d:href></d:current-user-principal>")
+	case r.Method == "PROPFIND" && r.URL.Path == caldavPrincipal:
+		response(&body, caldavPrincipal, "<d:resourcetype><d:principal/></d:resourcetype>", "<c:calendar-home-set><d:href>"+caldavHome+"</d:href></c:calendar-home-set>")
+	case r.Method == "PROPFIND" && r.URL.Path == caldavHome:
+		response(&body, caldavHome, "<d:resourcetype><d:collection/></d:resourcetype>")
+		if r.Header.Get("Depth") == "1" {
+			response(&body, caldavCalendar, s.calendarProps("VEVENT")...)
+			response(&body, caldavTasks, s.calendarProps("VTODO")...)
+		}
+	case r.Method == "PROPFIND" && r.URL.Path == caldavCalendar:
+		response(&body, caldavCalendar, s.calendarProps("VEVENT")...)
+	case r.Method == "REPORT" && r.URL.Path == caldavCalendar:
+		var report struct {
+			XMLName   xml.Name
+			SyncToken string   `xml:"DAV: sync-token"`
+			Hrefs     []string `xml:"DAV: href"`
+			Range     struct {
+				Start string `xml:"start,attr"`
+				End   string `xml:"end,attr"`
+			} `xml:"urn:ietf:params:xml:ns:caldav filter>comp-filter>comp-filter>time-range"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&report); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		switch report.XMLName.Local {
+		case "calendar-query":
+			for href, data := range s.objects {
+				// The stub's events start at their DTSTART and don't recur
+				start := data[strings.Index(data, "DTSTART:")+8:][:16]
+				if start >= report.Range.Start && start < report.Range.End {
+					response(&body, href, "<d:getetag>"+s.etag(data)+"</d:getetag>", "<c:calendar-data>"+data+"</c:calendar-data>")
+				}
+			}
+		case "calendar-multiget":
+			s.multigets += len(report.Hrefs)
+			for _, href := range report.Hrefs {
+				if data, ok := s.objects[href]; ok {
+					response(&body, href, "<d:getetag>"+s.etag(data)+"</d:getetag>", "<c:calendar-data>"+data+"</c:calendar-data>")
+				}
+			}
+		case "sync-collection":
+			if !s.syncable {
+				http.Error(w, "Unsupported report", http.StatusForbidden)
+				return
+			}
+			since := 0
+			if report.SyncToken != "" {
+				n, err := fmt.Sscanf(report.SyncToken, "https://stub.example.com/sync/%d", &since)
+				if n != 1 || err != nil || since > len(s.changes) {
+					w.WriteHeader(http.StatusForbidden)
+					w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><d:error xmlns:d="DAV:"><d:valid-sync-token/></d:error>`))
+					return
+				}
+			}
+			changed := map[string]bool{}
+			for _, change := range s.changes[since:] {
+				changed[change.href] = true
+			}
+			for href := range changed {
+				if data, ok := s.objects[href]; ok {
+					response(&body, href, "<d:getetag>"+s.etag(data)+"</d:getetag>")
+				} else if since > 0 {
+					fmt.Fprintf(&body, "<d:response><d:href>%s</d:href><d:status>HTTP/1.1 404 Not Found</d:status></d:response>", href)
+				}
+			}
+			fmt.Fprintf(&body, "<d:sync-token>https://stub.example.com/sync/%d</d:sync-token>", len(s.changes))
+		default:
+			http.Error(w, "Unsupported report", http.StatusForbidden)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	body.WriteString("</d:multistatus>")
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	w.Write([]byte(body.String()))
+}
+
+// Test the requests of the CalDAV client against the stub
+func TestCalDAVClient(t *testing.T) {
+	stub := newCalDAVStub(t, true)
+	stub.put("standup.ics", caldavEvent("standup@caldav", "Standup", time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)))
+	stub.put("review.ics", caldavEvent("review@caldav", "Review", time.Date(2024, 3, 5, 14, 0, 0, 0, time.UTC)))
+	stub.put("kickoff.ics", caldavEvent("kickoff@caldav", "Kickoff", time.Date(2023, 1, 9, 10, 0, 0, 0, time.UTC)))
+
+	alice := source(TypeCalDAV, stub.URL+"/dav/")
+	alice.Username, alice.Password = "alice", "secret"
+	client := &davClient{source: alice}
+	ctx := context.Background()
+
+	t.Run("Discover the calendars of the principal", func(t *testing.T) {
+		root, _ := url.Parse(stub.URL + "/dav/")
+		calendars, err := client.discover(ctx, root)
+		assert.NoError(t, err)
+		// The task list is left out
+		if assert.Len(t, calendars, 1) {
+			assert.Equal(t, caldavCalendar, calendars[0].href)
+			assert.Equal(t, stub.URL+caldavCalendar, calendars[0].url.String())
+			assert.True(t, calendars[0].syncable)
+		}
+	})
+
+	t.Run("Discover a calendar", func(t *testing.T) {
+		calendar, _ := url.Parse(stub.URL + caldavCalendar)
+		calendars, err := client.discover(ctx, calendar)
+		assert.NoError(t, err)
+		assert.Len(t, calendars, 1)
+	})
+
+	t.Run("Wrong password", func(t *testing.T) {
+		intruder := source(TypeCalDAV, stub.URL+"/dav/")
+		intruder.Username, intruder.Password = "alice", "guess"
+		root, _ := url.Parse(stub.URL + "/dav/")
+		_, err := (&davClient{source: intruder}).discover(ctx, root)
+		var status *davStatusError
+		if assert.ErrorAs(t, err, &status) {
+			assert.Equal(t, http.StatusUnauthorized, status.status)
+		}
+	})
+
+	calendar, _ := url.Parse(stub.URL + caldavCalendar)
+
+	t.Run("Query a time range", func(t *testing.T) {
+		objects, err := client.query(ctx, calendar, march1(), march1().AddDate(0, 1, 0))
+		assert.NoError(t, err)
+		assert.Len(t, objects, 2)
+		for _, object := range objects {
+			assert.NotEmpty(t, object.etag)
+			assert.Contains(t, object.data, "BEGIN:VEVENT")
+		}
+	})
+
+	t.Run("Sync changes", func(t *testing.T) {
+		changes, err := client.syncCollection(ctx, calendar, "")
+		assert.NoError(t, err)
+		assert.True(t, changes.full)
+		assert.Len(t, changes.objects, 3)
+		assert.Empty(t, changes.removed)
+		assert.NotEmpty(t, changes.token)
+
+		stub.put("review.ics", caldavEvent("review@caldav", "Review (moved)", time.Date(2024, 3, 6, 14, 0, 0, 0, time.UTC)))
+		stub.remove("kickoff.ics")
+		next, err := client.syncCollection(ctx, calendar, changes.token)
+		assert.NoError(t, err)
+		assert.False(t, next.full)
+		if assert.Len(t, next.objects, 1) {
+			assert.Equal(t, caldavCalendar+"review.ics", next.objects[0].href)
+		}
+		assert.Equal(t, []string{caldavCalendar + "kickoff.ics"}, next.removed)
+		assert.NotEqual(t, changes.token, next.token)
+
+		objects, err := client.multiget(ctx, calendar, []string{caldavCalendar + "review.ics"})
+		assert.NoError(t, err)
+		if assert.Len(t, objects, 1) {
+			assert.Contains(t, objects[0].data, "Review (moved)")
+		}
+	})
+
+	t.Run("Unknown sync token", func(t *testing.T) {
+		_, err := client.syncCollection(ctx, calendar, "https://stub.example.com/sync/999")
+		var status *davStatusError
+		if assert.ErrorAs(t, err, &status) {
+			assert.True(t, status.invalidToken())
+		}
+	})
+
+	t.Run("Parse calendar objects", func(t *testing.T) {
+		provider := &CalDAVProvider{}
+		data := caldavEvent("one@caldav", "One", march1()) + caldavEvent("two@caldav", "Two", march1()) + "BEGIN:VCALENDAR\r\nnot a calendar\r\n"
+		calendar, err := provider.Parse([]byte(data))
+		assert.NoError(t, err)
+		if assert.Len(t, calendar.Events, 2) {
+			assert.Equal(t, "One", calendar.Events[0].Summary)
+			assert.Equal(t, "Two", calendar.Events[1].Summary)
+		}
+	})
+
+	t.Run("Validate URL", func(t *testing.T) {
+		provider := &CalDAVProvider{}
+		assert.NoError(t, provider.ValidateURL("https://cloud.example.com/remote.php/dav"))
+		assert.Error(t, provider.ValidateURL("webcal://cloud.example.com/calendar.ics"))
+		assert.True(t, provider.Capabilities().Credentials)
+	})
+}
+
+// Test syncing a CalDAV source, incrementally when the server supports it
+func TestSyncCalDAV(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	for _, syncable := range []bool{true, false} {
+		t.Run(fmt.Sprintf("Syncable %v", syncable), func(t *testing.T) {
+			stub := newCalDAVStub(t, syncable)
+			stub.put("standup.ics", caldavEvent("standup@caldav", "Standup", time.Date(2024, 3, 4, 9, 0, 0, 0, time.UTC)))
+			stub.put("review.ics", caldavEvent("review@caldav", "Review", time.Date(2024, 3, 5, 14, 0, 0, 0, time.UTC)))
+			syncer := &Syncer{DB: db, Providers: NewRegistry(&CalDAVProvider{DB: db}), Now: march1}
+
+			user := models.User{ResourceID: uuid.New(), Email: "caldav-" + uuid.NewString() + "@example.com"}
+			assert.NoError(t, db.Create(&user).Error)
+			source := models.AgendaSource{ResourceID: uuid.New(), Url: stub.URL + "/dav/", Type: TypeCalDAV, UserID: user.ID, Username: "alice", Password: "secret"}
+			assert.NoError(t, db.Create(&source).Error)
+
+			items := func() map[string]models.AgendaItem {
+				var found []models.AgendaItem
+				assert.NoError(t, db.Where("agenda_source_id = ?", source.ID).Find(&found).Error)
+				byID := map[string]models.AgendaItem{}
+				for _, item := range found {
+					byID[item.ExternalID] = item
+				}
+				return byID
+			}
+
+			result, err := syncer.SyncSource(context.Background(), &source)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, result.Added)
+			first := items()
+			assert.Equal(t, "Standup", first["standup@caldav"].Description)
+
+			// Only the changed object is downloaded again
+			stub.put("review.ics", caldavEvent("review@caldav", "Review (moved)", time.Date(2024, 3, 6, 14, 0, 0, 0, time.UTC)))
+			stub.remove("standup.ics")
+			stub.multigets = 0
+			result, err = syncer.SyncSource(context.Background(), &source)
+			assert.NoError(t, err)
+			assert.Equal(t, Result{Updated: 1, Removed: 1}, *result)
+			if syncable {
+				assert.Equal(t, 1, stub.multigets)
+			}
+			updated := items()
+			assert.Len(t, updated, 1)
+			assert.Equal(t, "Review (moved)", updated["review@caldav"].Description)
+			assert.Equal(t, first["review@caldav"].ResourceID, updated["review@caldav"].ResourceID)
+
+			var objects int64
+			assert.NoError(t, db.Model(&models.CalendarObject{}).
+				Where("calendar_collection_id IN (?)", db.Model(&models.CalendarCollection{}).Select("id").Where("agenda_source_id = ?", source.ID)).
+				Count(&objects).Error)
+			assert.Equal(t, int64(1), objects)
+
+			// A forgotten sync token starts over
+			assert.NoError(t, db.Model(&models.CalendarCollection{}).Where("agenda_source_id = ?", source.ID).Update("sync_token", "https://stub.example.com/sync/999").Error)
+			_, err = syncer.SyncSource(context.Background(), &source)
+			assert.NoError(t, err)
+			assert.Len(t, items(), 1)
+
+			// Wrong credentials fail the sync and keep the items
+			source.Password = "guess"
+			_, err = syncer.SyncSource(context.Background(), &source)
+			assert.Error(t, err)
+			assert.Len(t, items(), 1)
+		})
+	}
+}
+
+// Test the delays between syncs
+func TestSchedulerDelays(t *testing.T) {
+	scheduler := &Scheduler{Interval: time.Hour, MaxBackoff: time.Hour}
+
+	for i := 0; i < 100; i++ {
+		interval := scheduler.interval(&models.AgendaSource{})
+		assert.GreaterOrEqual(t, interval, 54*time.Minute)
+		assert.LessOrEqual(t, interval, 66*time.Minute)
+		assert.InDelta(t, float64(10*time.Minute), float64(scheduler.interval(&models.AgendaSource{SyncInterval: 10 * time.Minute})), float64(time.Minute))
+
+		// The backoff doubles with every failure, up to the maximum
+		for failures, delay := range map[int]time.Duration{1: time.Minute, 2: 2 * time.Minute, 4: 8 * time.Minute, 7: time.Hour, 100: time.Hour} {
+			backoff := scheduler.backoff(failures)
+			assert.GreaterOrEqual(t, backoff, delay/2, failures)
+			assert.LessOrEqual(t, backoff, delay, failures)
+		}
+	}
+	assert.LessOrEqual(t, (&Scheduler{}).backoff(50), DefaultMaxBackoff)
+}
+
+// Test that the scheduler syncs the sources that are due and backs off from
+// failing ones
+func TestScheduler(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	// Only the sources of this test are due
+	assert.NoError(t, db.Model(&models.AgendaSource{}).Where("type = ?", TypeProton).Update("next_sync_at", time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)).Error)
+
+	var feed atomic.Value
+	feed.Store("proton.ics")
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path == "/broken.ics" {
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("ETag", `"`+feed.Load().(string)+`"`)
+		if r.Header.Get("If-None-Match") == `"`+feed.Load().(string)+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		data, _ := os.ReadFile("testdata/" + feed.Load().(string))
+		w.Write(data)
+	}))
+	defer server.Close()
+
+	now := march1()
+	clock := func() time.Time { return now }
+	scheduler := &Scheduler{
+		Syncer:   &Syncer{DB: db, Providers: NewRegistry(&ProtonProvider{}), Now: clock},
+		Interval: time.Hour,
+	}
+
+	user := models.User{ResourceID: uuid.New(), Email: "scheduler-" + uuid.NewString() + "@example.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	working := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/calendar.ics", Type: TypeProton, UserID: user.ID}
+	broken := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/broken.ics", Type: TypeProton, UserID: user.ID, SyncInterval: 10 * time.Minute}
+	// Sources imported without their real URL wait for it
+	redacted := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/", URLRedacted: true, Type: TypeProton, UserID: user.ID}
+	assert.NoError(t, db.Create(&working).Error)
+	assert.NoError(t, db.Create(&broken).Error)
+	assert.NoError(t, db.Create(&redacted).Error)
+	reload := func(source *models.AgendaSource) {
+		assert.NoError(t, db.First(source, source.ID).Error)
+	}
+
+	synced, err := scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, synced)
+
+	reload(&working)
+	assert.Equal(t, 0, working.SyncFailures)
+	assert.Equal(t, `"proton.ics"`, working.ETag)
+	if assert.NotNil(t, working.NextSyncAt) {
+		assert.WithinDuration(t, now.Add(time.Hour), *working.NextSyncAt, 6*time.Minute)
+	}
+	var count int64
+	assert.NoError(t, db.Model(&models.AgendaItem{}).Where("agenda_source_id = ?", working.ID).Count(&count).Error)
+	assert.Equal(t, int64(3), count)
+
+	reload(&broken)
+	assert.Equal(t, 1, broken.SyncFailures)
+	reload(&redacted)
+	assert.Nil(t, redacted.LastSyncedAt)
+	if assert.NotNil(t, broken.NextSyncAt) {
+		assert.WithinDuration(t, now.Add(45*time.Second), *broken.NextSyncAt, 15*time.Second)
+	}
+
+	// Nothing is due yet
+	synced, err = scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, synced)
+
+	// The broken source backs off further, the unchanged one isn't downloaded
+	now = now.Add(2 * time.Hour)
+	requests.Store(0)
+	synced, err = scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, synced)
+	assert.Equal(t, int32(2), requests.Load())
+	reload(&broken)
+	assert.Equal(t, 2, broken.SyncFailures)
+	assert.WithinDuration(t, now.Add(90*time.Second), *broken.NextSyncAt, 30*time.Second)
+	reload(&working)
+	assert.True(t, march1().Equal(*working.SyncedAt), "an unchanged calendar isn't synced again")
+
+	// Once the feed works again the backoff is over
+	broken.Url = server.URL + "/calendar.ics"
+	assert.NoError(t, db.Save(&broken).Error)
+	now = now.Add(time.Hour)
+	_, err = scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	reload(&broken)
+	assert.Equal(t, 0, broken.SyncFailures)
+	assert.WithinDuration(t, now.Add(10*time.Minute), *broken.NextSyncAt, time.Minute)
+
+	// A day later the events are expanded again, even when nothing changed
+	now = now.Add(DefaultRefresh)
+	_, err = scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	reload(&working)
+	assert.True(t, now.Equal(*working.SyncedAt))
+
+	// Without a default interval only the sources with their own are synced
+	now = now.Add(DefaultRefresh)
+	scheduler.OwnIntervalsOnly = true
+	synced, err = scheduler.SyncDue(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, synced)
+	reload(&working)
+	assert.False(t, now.Equal(*working.LastSyncedAt))
+	scheduler.OwnIntervalsOnly = false
+
+	// Stopping waits for the running syncs
+	scheduler.Poll = 10 * time.Millisecond
+	scheduler.Start()
+	time.Sleep(50 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, scheduler.Stop(ctx))
+	assert.NoError(t, scheduler.Stop(ctx))
+}
+
+// Test that syncs are recorded in the history and status of their source
+func TestSyncHistory(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	var feed atomic.Value
+	feed.Store("proton.ics")
+	server := feedServer(t, &feed)
+	scheduler := &Scheduler{Syncer: &Syncer{DB: db, Providers: NewRegistry(&ProtonProvider{}), Now: march1}, Interval: time.Hour}
+
+	user := models.User{ResourceID: uuid.New(), Email: "history-" + uuid.NewString() + "@example.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/calendar.ics", Type: TypeProton, UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+	history := func() []models.SyncRun {
+		var runs []models.SyncRun
+		assert.NoError(t, db.Where("agenda_source_id = ?", source.ID).Order("id").Find(&runs).Error)
+		return runs
+	}
+
+	run, err := scheduler.Sync(context.Background(), &source, models.SyncTriggerManual)
+	assert.NoError(t, err)
+	assert.Equal(t, models.SyncStatusSuccess, run.Status)
+	assert.Equal(t, models.SyncTriggerManual, run.Trigger)
+	assert.Equal(t, 3, run.Added)
+	assert.True(t, march1().Equal(run.StartedAt))
+
+	var stored models.AgendaSource
+	assert.NoError(t, db.First(&stored, source.ID).Error)
+	assert.Equal(t, models.SyncStatusSuccess, stored.LastSyncStatus)
+	assert.Equal(t, 3, stored.ItemCount)
+	assert.Empty(t, stored.LastError)
+	if assert.NotNil(t, stored.LastSyncedAt) && assert.NotNil(t, stored.NextSyncAt) {
+		assert.True(t, march1().Equal(*stored.LastSyncedAt))
+		assert.WithinDuration(t, march1().Add(time.Hour), *stored.NextSyncAt, 6*time.Minute)
+	}
+
+	// Failures are recorded with their error, the item count is left as it was
+	feed.Store("missing.ics")
+	run, err = scheduler.Sync(context.Background(), &source, models.SyncTriggerScheduled)
+	assert.NoError(t, err)
+	assert.Equal(t, models.SyncStatusFailed, run.Status)
+	assert.Contains(t, run.Error, "404")
+	assert.NoError(t, db.First(&stored, source.ID).Error)
+	assert.Equal(t, models.SyncStatusFailed, stored.LastSyncStatus)
+	assert.Equal(t, run.Error, stored.LastError)
+	assert.Equal(t, 3, stored.ItemCount)
+	assert.Equal(t, 1, stored.SyncFailures)
+
+	feed.Store("proton-updated.ics")
+	run, err = scheduler.Sync(context.Background(), &source, models.SyncTriggerManual)
+	assert.NoError(t, err)
+	assert.Equal(t, models.SyncStatusSuccess, run.Status)
+	assert.Equal(t, 1, run.Added)
+	assert.Equal(t, 2, run.Removed)
+	assert.NoError(t, db.First(&stored, source.ID).Error)
+	assert.Equal(t, 2, stored.ItemCount)
+	assert.Empty(t, stored.LastError)
+
+	runs := history()
+	if assert.Len(t, runs, 3) {
+		assert.Equal(t, []string{models.SyncStatusSuccess, models.SyncStatusFailed, models.SyncStatusSuccess}, []string{runs[0].Status, runs[1].Status, runs[2].Status})
+		assert.Equal(t, models.SyncTriggerScheduled, runs[1].Trigger)
+	}
+
+	// Only the latest runs are kept
+	old := make([]models.SyncRun, HistorySize)
+	for i := range old {
+		old[i] = models.SyncRun{ResourceID: uuid.New(), AgendaSourceID: source.ID, Status: models.SyncStatusSuccess}
+	}
+	assert.NoError(t, db.Create(&old).Error)
+	_, err = scheduler.Sync(context.Background(), &source, models.SyncTriggerManual)
+	assert.NoError(t, err)
+	runs = history()
+	assert.Len(t, runs, HistorySize)
+	assert.Equal(t, models.SyncTriggerManual, runs[len(runs)-1].Trigger)
+}
+
+// Test that a source isn't synced twice at the same time
+func TestSyncRunning(t *testing.T) {
+	db, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+
+	requested, release := make(chan struct{}), make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		data, _ := os.ReadFile("testdata/proton.ics")
+		w.Write(data)
+	}))
+	defer server.Close()
+	scheduler := &Scheduler{Syncer: &Syncer{DB: db, Providers: NewRegistry(&ProtonProvider{}), Now: march1}}
+
+	user := models.User{ResourceID: uuid.New(), Email: "running-" + uuid.NewString() + "@example.com"}
+	assert.NoError(t, db.Create(&user).Error)
+	source := models.AgendaSource{ResourceID: uuid.New(), Url: server.URL + "/calendar.ics", Type: TypeProton, UserID: user.ID}
+	assert.NoError(t, db.Create(&source).Error)
+
+	done := make(chan error)
+	go func() {
+		first := source
+		_, err := scheduler.Sync(context.Background(), &first, models.SyncTriggerScheduled)
+		done <- err
+	}()
+	<-requested
+	_, err = scheduler.Sync(context.Background(), &source, models.SyncTriggerManual)
+	assert.ErrorIs(t, err, ErrSyncRunning)
+	close(release)
+	assert.NoError(t, <-done)
+}